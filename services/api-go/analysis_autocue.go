@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os/exec"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"meta-dj/services/api-go/autocue"
+	"meta-dj/services/api-go/beatgrid"
+	"meta-dj/services/api-go/cuecolor"
+)
+
+const (
+	// autocueRate and autocueWindowMs set the envelope resolution: plenty
+	// for bar energies, and cheap to decode.
+	autocueRate     = 11025
+	autocueWindowMs = 50
+	// autocueFallbackBpm is the tempo of a track with no beatgrid and no
+	// BPM, as in the core CLI.
+	autocueFallbackBpm = 128
+	// autocueBatchMax bounds the track_ids of one batch.
+	autocueBatchMax = 1000
+)
+
+// autocueStyle is the label and palette color of each kind of point. The
+// order is the priority for hot cue pads; points left without one become
+// memory cues.
+var autocueStyle = []autocueKind{
+	{autocue.FirstBeat, "First beat", "green"},
+	{autocue.IntroEnd, "Intro end", "aqua"},
+	{autocue.Drop, "Drop", "red"},
+	{autocue.Breakdown, "Breakdown", "blue"},
+	{autocue.Outro, "Outro", "purple"},
+}
+
+type autocueKind struct {
+	kind         autocue.Kind
+	label, color string
+}
+
+// autocueRank is kind's index in autocueStyle.
+func autocueRank(kind autocue.Kind) int {
+	return slices.IndexFunc(autocueStyle, func(st autocueKind) bool { return st.kind == kind })
+}
+
+// autocueResult is what one track's run placed.
+type autocueResult struct {
+	TrackID string `json:"track_id"`
+	// GridSource is where the grid came from: edited, analysis, or
+	// estimated from the track's BPM.
+	GridSource string    `json:"grid_source"`
+	Cues       []CueRow  `json:"cues"`
+	Loops      []LoopRow `json:"loops"`
+	// Skipped lists points already covered by a cue or loop of the user.
+	Skipped []string `json:"skipped"`
+}
+
+// autocue decodes the track, finds its cue points and swaps them in for
+// the track's previous autogen cues and loops. Points within a beat of a
+// cue the user placed, or whose earlier cue the user edited, are skipped,
+// and hot cue pads the user holds stay theirs.
+func (s *AnalysisService) autocue(ctx context.Context, track *TrackRow) (*autocueResult, error) {
+	env := autocue.NewEnvelope(autocueRate, autocueWindowMs)
+	cmd := exec.CommandContext(ctx, s.ffmpeg,
+		"-hide_banner", "-nostdin", "-v", "error",
+		"-i", s.paths.MapPath(track.FilePath),
+		"-vn", "-ac", "1", "-ar", fmt.Sprint(autocueRate), "-f", "s16le", "pipe:1",
+	)
+	cmd.Stdout = env
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = 3 * time.Second
+	start := time.Now()
+	err := runTraced(ctx, "ffmpeg autocue", cmd)
+	observeFFmpeg("autocue", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %w", err)
+	}
+	levels := env.Levels()
+
+	res := &autocueResult{TrackID: track.ID, Cues: []CueRow{}, Loops: []LoopRow{}, Skipped: []string{}}
+	var grid beatgrid.Grid
+	row, err := s.Grids.Get(ctx, track.ID)
+	switch {
+	case err == nil:
+		grid, res.GridSource = row.Grid, row.Source
+	case errors.Is(err, ErrNotFound):
+		// A tempo out of the grid's range would give a bar per sample or less.
+		bpm := float64(autocueFallbackBpm)
+		if b := track.BpmOverride; b != nil && *b > 0 && *b <= beatgrid.MaxBpm {
+			bpm = *b
+		} else if b := track.TagBpm; b != nil && *b > 0 && *b <= beatgrid.MaxBpm {
+			bpm = *b
+		}
+		onset := autocue.Onset(levels, autocueWindowMs)
+		grid, res.GridSource = beatgrid.Grid{BeatsPerBar: 4, Markers: []beatgrid.Marker{{PositionMs: onset, Bpm: bpm}}}, "estimated"
+	default:
+		return nil, err
+	}
+	found := autocue.Detect(levels, autocueWindowMs, grid)
+
+	stored, err := s.Cues.ListByTrack(ctx, track.ID)
+	if err != nil {
+		return nil, err
+	}
+	storedLoops, err := s.Loops.ListByTrack(ctx, track.ID)
+	if err != nil {
+		return nil, err
+	}
+	// near reports whether a user's position is within a beat of ms.
+	near := func(ms float64, user []int64) bool {
+		return slices.ContainsFunc(user, func(u int64) bool { return math.Abs(grid.BeatAt(float64(u))-grid.BeatAt(ms)) < 1 })
+	}
+	var userCues, userLoops []int64
+	taken := map[string]bool{}
+	// owned holds the ids of autocue points the user has since edited,
+	// wherever they moved them; a rerun leaves those alone.
+	owned := map[string]bool{}
+	for _, c := range stored {
+		if !c.Autogen {
+			owned[c.ID] = true
+			userCues = append(userCues, c.PositionMs)
+			if c.Slot != nil {
+				taken[*c.Slot] = true
+			}
+		}
+	}
+	for _, l := range storedLoops {
+		if !l.Autogen {
+			owned[l.ID] = true
+			userLoops = append(userLoops, l.StartMs)
+		}
+	}
+
+	var kinds []autocue.Kind // of res.Cues
+	seen := map[autocue.Kind]int{}
+	for _, p := range found.Points {
+		n := seen[p.Kind]
+		seen[p.Kind]++
+		id := fmt.Sprintf("%s-%d", p.Kind, n+1)
+		rowID := sha1Hex(track.ID + "/autocue/" + id)
+		if owned[rowID] || near(p.Ms, userCues) {
+			res.Skipped = append(res.Skipped, id)
+			continue
+		}
+		style := autocueStyle[autocueRank(p.Kind)]
+		color, _ := cuecolor.Normalize(style.color)
+		label := style.label
+		if n > 0 {
+			label = fmt.Sprintf("%s %d", label, n+1)
+		}
+		res.Cues = append(res.Cues, CueRow{
+			ID:         rowID,
+			TrackID:    track.ID,
+			PositionMs: autocueMs(p.Ms, track),
+			Color:      &color.Hex,
+			Label:      &label,
+			Type:       CueMemory,
+			Autogen:    true,
+		})
+		kinds = append(kinds, p.Kind)
+	}
+	assignAutocuePads(res.Cues, kinds, taken)
+
+	for _, l := range found.Loops {
+		id := fmt.Sprintf("%s-loop", l.Kind)
+		rowID := sha1Hex(track.ID + "/autocue/" + id)
+		if owned[rowID] || near(l.StartMs, userLoops) {
+			res.Skipped = append(res.Skipped, id)
+			continue
+		}
+		label := fmt.Sprintf("Intro %d", l.Beats)
+		if l.Kind == autocue.Outro {
+			label = fmt.Sprintf("Outro %d", l.Beats)
+		}
+		beats := l.Beats
+		res.Loops = append(res.Loops, LoopRow{
+			ID:          rowID,
+			TrackID:     track.ID,
+			StartMs:     autocueMs(l.StartMs, track),
+			LengthBeats: &beats,
+			Label:       &label,
+			Autogen:     true,
+		})
+	}
+
+	if err := s.Cues.ReplaceAutogen(ctx, track.ID, res.Cues); err != nil {
+		return nil, err
+	}
+	if err := s.Loops.ReplaceAutogen(ctx, track.ID, res.Loops); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// autocueMs rounds a detected position and keeps it within the track.
+func autocueMs(ms float64, track *TrackRow) int64 {
+	out := max(0, int64(math.Round(ms)))
+	if track.DurationMs != nil {
+		out = min(out, *track.DurationMs)
+	}
+	return out
+}
+
+// assignAutocuePads turns the cues of the highest priority kinds into HOT
+// cues on the pads not in taken, handed out A-H in position order; the
+// rest stay memory cues.
+func assignAutocuePads(cues []CueRow, kinds []autocue.Kind, taken map[string]bool) {
+	free := slices.DeleteFunc(slices.Clone(hotCueSlots), func(slot string) bool { return taken[slot] })
+	order := make([]int, len(cues))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return autocueRank(kinds[a]) - autocueRank(kinds[b]) })
+	hot := order[:min(len(order), len(free))]
+	slices.Sort(hot)
+	for i, ci := range hot {
+		cues[ci].Type, cues[ci].Slot = CueHot, &free[i]
+	}
+}
+
+// handleAutocue places autogen cues and loops on one track. Decoding a
+// whole track outlasts the write timeout, so it runs as a job of one and
+// answers 202; the job carries what was placed once done.
+func (s *AnalysisService) handleAutocue(w http.ResponseWriter, r *http.Request) {
+	track, err := s.Tracks.Get(r.Context(), chi.URLParam(r, "trackId"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.startAutocueJob(w, r, []string{track.ID})
+}
+
+// autocueJob is an autocue run in the background.
+type autocueJob struct {
+	ID         string           `json:"id"`
+	Status     string           `json:"status"` // running, done or cancelled
+	Total      int              `json:"total"`
+	Done       int              `json:"done"`
+	Failed     []autocueFailure `json:"failed"`
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty"`
+	// Result is what a single-track job placed.
+	Result *autocueResult `json:"result,omitempty"`
+}
+
+type autocueFailure struct {
+	TrackID string `json:"track_id"`
+	Error   string `json:"error"`
+}
+
+// autocueJobs keeps the most recent batch jobs in memory; they do not
+// survive a restart.
+type autocueJobs struct {
+	mu   sync.Mutex
+	jobs []*autocueJob
+}
+
+const autocueJobsKept = 50
+
+func (j *autocueJobs) add(job *autocueJob) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.jobs = append(j.jobs, job)
+	if len(j.jobs) > autocueJobsKept {
+		j.jobs = slices.Delete(j.jobs, 0, len(j.jobs)-autocueJobsKept)
+	}
+}
+
+// get returns a copy of the job, safe to encode while it runs.
+func (j *autocueJobs) get(id string) (autocueJob, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, job := range j.jobs {
+		if job.ID == id {
+			out := *job
+			out.Failed = slices.Clone(job.Failed)
+			return out, true
+		}
+	}
+	return autocueJob{}, false
+}
+
+func (j *autocueJobs) update(fn func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn()
+}
+
+// handleAutocueBatch starts a background job over track_ids, or every
+// track when the body leaves it out, and answers 202 with the job.
+func (s *AnalysisService) handleAutocueBatch(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		TrackIDs []string `json:"track_ids"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(body.TrackIDs) > autocueBatchMax {
+		writeError(w, r, validationProblem(FieldError{Field: "track_ids", Code: "too_long", Message: fmt.Sprintf("track_ids may list at most %d tracks", autocueBatchMax)}))
+		return
+	}
+	ids := body.TrackIDs
+	if ids == nil {
+		var err error
+		if ids, err = s.allTrackIDs(r.Context()); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	s.startAutocueJob(w, r, slices.Compact(slices.Sorted(slices.Values(ids))))
+}
+
+// startAutocueJob runs ids in the background and answers 202 with the job.
+func (s *AnalysisService) startAutocueJob(w http.ResponseWriter, r *http.Request, ids []string) {
+	var b [8]byte
+	rand.Read(b[:])
+	job := &autocueJob{ID: hex.EncodeToString(b[:]), Status: "running", Total: len(ids), Failed: []autocueFailure{}, StartedAt: time.Now().UTC()}
+	if err := s.lc.Go("autocue "+job.ID, func(ctx context.Context) { s.runAutocueJob(ctx, job, ids) }); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.jobs.add(job)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/v1/analysis/autocue/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	snapshot, _ := s.jobs.get(job.ID)
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// allTrackIDs pages through the whole library.
+func (s *AnalysisService) allTrackIDs(ctx context.Context) ([]string, error) {
+	const page = 500
+	var ids []string
+	for offset := 0; ; offset += page {
+		rows, err := s.Tracks.List(ctx, TrackQuery{Limit: page, Offset: offset})
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range rows {
+			ids = append(ids, t.ID)
+		}
+		if len(rows) < page {
+			return ids, nil
+		}
+	}
+}
+
+// runAutocueJob works through ids one at a time, ffmpeg being the cost;
+// shutdown cancels it between or during tracks.
+func (s *AnalysisService) runAutocueJob(ctx context.Context, job *autocueJob, ids []string) {
+	status := "done"
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			status = "cancelled"
+			break
+		}
+		var res *autocueResult
+		track, err := s.Tracks.Get(ctx, id)
+		if err == nil {
+			res, err = s.autocue(ctx, track)
+		}
+		s.jobs.update(func() {
+			job.Done++
+			if len(ids) == 1 {
+				job.Result = res
+			}
+			if err != nil {
+				job.Failed = append(job.Failed, autocueFailure{TrackID: id, Error: err.Error()})
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			zap.L().Warn("autocue failed", zap.String("job", job.ID), zap.String("track", id), zap.Error(err))
+		}
+	}
+	s.jobs.update(func() {
+		now := time.Now().UTC()
+		job.Status, job.FinishedAt = status, &now
+	})
+}
+
+func (s *AnalysisService) handleAutocueJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.get(chi.URLParam(r, "jobId"))
+	if !ok {
+		writeError(w, r, ErrNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
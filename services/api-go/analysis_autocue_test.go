@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	"meta-dj/services/api-go/beatgrid"
+)
+
+// fakeFFmpeg is a stand-in ffmpeg that decodes every track to pcm.
+func fakeFFmpeg(t *testing.T, pcm []byte) string {
+	t.Helper()
+	dir := t.TempDir()
+	audio := filepath.Join(dir, "track.pcm")
+	if err := os.WriteFile(audio, pcm, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ffmpeg := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(ffmpeg, []byte("#!/bin/sh\ncat "+audio+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return ffmpeg
+}
+
+// barsPCM is autocue-rate PCM holding one level per 2000ms bar, 120 BPM
+// in 4/4 from the start.
+func barsPCM(levels ...float64) []byte {
+	var pcm []byte
+	for _, l := range levels {
+		for i := range autocueRate * 2 {
+			s := int16(l * 32767)
+			if i%2 == 1 {
+				s = -s
+			}
+			pcm = binary.LittleEndian.AppendUint16(pcm, uint16(s))
+		}
+	}
+	return pcm
+}
+
+type autocueTest struct {
+	cues   *MemCueStore
+	loops  *MemLoopStore
+	grids  *MemBeatgridStore
+	tracks *MemTrackStore
+	lc     *Lifecycle
+	r      chi.Router
+}
+
+func newAutocueTest(t *testing.T, ffmpeg string, tracks ...TrackRow) *autocueTest {
+	t.Helper()
+	a := &autocueTest{cues: NewMemCueStore(), loops: NewMemLoopStore(), grids: NewMemBeatgridStore(), lc: NewLifecycle(zap.NewNop())}
+	a.tracks = NewMemTrackStore(a.cues, a.loops, a.grids)
+	for _, row := range tracks {
+		row.Title, row.FilePath = row.ID, "/music/"+row.ID+".mp3"
+		if _, err := a.tracks.Create(context.Background(), row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := NewAnalysisService(a.tracks, a.cues, a.loops, a.grids, nil, a.lc, AnalysisConfig{FFmpegPath: ffmpeg}, ImportConfig{})
+	a.r = chi.NewRouter()
+	a.r.Route("/v1/analysis/autocue", svc.AutocueRoutes)
+	a.r.Route("/v1/cues", NewCuesService(a.cues, a.tracks, a.grids).ProtectedRoutes)
+	a.r.Route("/v1/loops", NewLoopsService(a.loops, a.tracks, a.grids).ProtectedRoutes)
+	return a
+}
+
+func (a *autocueTest) do(method, url, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	a.r.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+	return w
+}
+
+// run autocues the track and returns its finished job.
+func (a *autocueTest) run(t *testing.T, id string) autocueJob {
+	t.Helper()
+	w := a.do(http.MethodPost, "/v1/analysis/autocue/"+id, "")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST = %d %s, want 202", w.Code, w.Body)
+	}
+	loc := w.Header().Get("Location")
+	done := make(chan struct{})
+	go func() { a.lc.wg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("autocue job did not finish")
+	}
+
+	w = a.do(http.MethodGet, loc, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d %s", loc, w.Code, w.Body)
+	}
+	var job autocueJob
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != "done" || job.Total != 1 || job.Done != 1 || len(job.Failed) != 0 {
+		t.Fatalf("job = %+v, want one track done", job)
+	}
+	return job
+}
+
+func TestAutocueRunsAsJob(t *testing.T) {
+	// Ten seconds of silence.
+	a := newAutocueTest(t, fakeFFmpeg(t, make([]byte, 220500)), TrackRow{ID: "t1"})
+	if w := a.do(http.MethodPost, "/v1/analysis/autocue/missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("POST for a missing track = %d %s, want 404", w.Code, w.Body)
+	}
+	job := a.run(t, "t1")
+	if job.Result == nil || job.Result.TrackID != "t1" || job.Result.GridSource != "estimated" || len(job.Result.Cues) != 0 {
+		t.Errorf("job result = %+v, want nothing placed on silence", job.Result)
+	}
+}
+
+func TestAutocueKeepsEditedPoints(t *testing.T) {
+	ctx := context.Background()
+	duration := int64(80000)
+	pcm := barsPCM(slices.Concat(slices.Repeat([]float64{0.3}, 16), slices.Repeat([]float64{1}, 16), slices.Repeat([]float64{0.3}, 8))...)
+	a := newAutocueTest(t, fakeFFmpeg(t, pcm), TrackRow{ID: "t1", TrackFields: TrackFields{DurationMs: &duration}})
+	if _, err := a.grids.Put(ctx, "t1", beatgrid.Grid{BeatsPerBar: 4, Markers: []beatgrid.Marker{{PositionMs: 0, Bpm: 120}}}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	first := a.run(t, "t1").Result
+	var introEnd *CueRow
+	for i, c := range first.Cues {
+		if c.PositionMs == 32000 {
+			introEnd = &first.Cues[i]
+		}
+	}
+	if introEnd == nil || len(first.Loops) != 2 || first.Loops[1].StartMs != 64000 {
+		t.Fatalf("first run = %+v, want an intro end at 32000 and an outro loop at 64000", first)
+	}
+	outroLoop := first.Loops[1]
+
+	// Move both well over a beat away: proximity alone no longer skips them.
+	if w := a.do(http.MethodPut, "/v1/cues/"+introEnd.ID, `{"track_id":"t1","position_ms":40000,"type":"MEMORY"}`); w.Code/100 != 2 {
+		t.Fatalf("PUT cue = %d %s", w.Code, w.Body)
+	}
+	if w := a.do(http.MethodPut, "/v1/loops/"+outroLoop.ID, `{"track_id":"t1","start_ms":56000,"length_beats":32}`); w.Code/100 != 2 {
+		t.Fatalf("PUT loop = %d %s", w.Code, w.Body)
+	}
+
+	second := a.run(t, "t1").Result
+	for _, id := range []string{"intro_end-1", "outro-loop"} {
+		if !slices.Contains(second.Skipped, id) {
+			t.Errorf("second run skipped %v, want %s", second.Skipped, id)
+		}
+	}
+	cues, _ := a.cues.ListByTrack(ctx, "t1")
+	i := slices.IndexFunc(cues, func(c CueRow) bool { return c.ID == introEnd.ID })
+	if i < 0 || cues[i].PositionMs != 40000 || cues[i].Autogen {
+		t.Errorf("edited cue after a rerun = %+v, want it kept at 40000", cues)
+	}
+	loops, _ := a.loops.ListByTrack(ctx, "t1")
+	i = slices.IndexFunc(loops, func(l LoopRow) bool { return l.ID == outroLoop.ID })
+	if i < 0 || loops[i].StartMs != 56000 || loops[i].Autogen {
+		t.Errorf("edited loop after a rerun = %+v, want it kept at 56000", loops)
+	}
+}
+
+func TestAutocueIgnoresOutOfRangeBpm(t *testing.T) {
+	// An override saved before the API bounded it: the estimated grid
+	// falls back to the tag BPM rather than a bar per sample.
+	tag := 120.0
+	a := newAutocueTest(t, fakeFFmpeg(t, barsPCM(0.3, 0.3, 1, 1)), TrackRow{ID: "t1", TrackFields: TrackFields{TagBpm: &tag}})
+	if _, err := a.tracks.SetBpmOverride(context.Background(), "t1", 1e12, 0); err != nil {
+		t.Fatal(err)
+	}
+	if res := a.run(t, "t1").Result; res == nil || res.GridSource != "estimated" || len(res.Cues) == 0 {
+		t.Errorf("result = %+v, want cues on the tag BPM grid", res)
+	}
+}
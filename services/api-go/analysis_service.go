@@ -7,24 +7,35 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
 
 type AnalysisService struct {
-	Tracks  *PgTrackStore
+	Tracks  TrackStore
+	Cues    CueStore      // autocue writes autogen cues and loops,
+	Loops   LoopStore     // placing them on the track's beatgrid
+	Grids   BeatgridStore //
 	Storage *SupabaseStorage
+	ffmpeg  string
+	paths   ImportConfig
+	lc      *Lifecycle // runs batch autocue jobs
+	jobs    autocueJobs
 }
 
-func NewAnalysisService(ctx context.Context, dsn string) (*AnalysisService, error) {
-	ts, err := NewPgTrackStore(ctx, dsn)
-	if err != nil {
-		return nil, err
-	}
+func NewAnalysisService(tracks TrackStore, cues CueStore, loops LoopStore, grids BeatgridStore, storage *SupabaseStorage, lc *Lifecycle, cfg AnalysisConfig, paths ImportConfig) *AnalysisService {
 	return &AnalysisService{
-		Tracks:  ts,
-		Storage: NewSupabaseStorage(),
-	}, nil
+		Tracks:  tracks,
+		Cues:    cues,
+		Loops:   loops,
+		Grids:   grids,
+		Storage: storage,
+		ffmpeg:  cfg.FFmpegPath,
+		paths:   paths,
+		lc:      lc,
+	}
 }
 
 func (s *AnalysisService) Routes(r chi.Router) {
@@ -37,20 +48,28 @@ func (s *AnalysisService) ProtectedRoutes(r chi.Router) {
 	r.Post("/reanalyze", s.handleReanalyze)
 }
 
+// AutocueRoutes need ffmpeg and the database but not storage, so they
+// mount apart from the waveform routes.
+func (s *AnalysisService) AutocueRoutes(r chi.Router) {
+	r.Post("/batch", s.handleAutocueBatch)
+	r.Get("/jobs/{jobId}", s.handleAutocueJob)
+	r.Post("/{trackId}", s.handleAutocue)
+}
+
 func (s *AnalysisService) handleGenerateWaveform(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if id == "" {
-		http.Error(w, "missing id", http.StatusBadRequest)
+		writeProblem(w, r, validationProblem(FieldError{Field: "id", Code: "required", Message: "track id is required"}))
 		return
 	}
 	row, err := s.Tracks.Get(r.Context(), id)
 	if err != nil {
-		http.Error(w, "not found", http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 	path, err := s.generateAndUploadWaveform(r.Context(), row)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -61,18 +80,22 @@ func (s *AnalysisService) handleReanalyze(w http.ResponseWriter, r *http.Request
 	var body struct {
 		TrackID string `json:"trackId"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.TrackID == "" {
-		http.Error(w, "bad json", http.StatusBadRequest)
+	if err := decodeJSON(r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if body.TrackID == "" {
+		writeProblem(w, r, validationProblem(FieldError{Field: "trackId", Code: "required", Message: "trackId is required"}))
 		return
 	}
 	row, err := s.Tracks.Get(r.Context(), body.TrackID)
 	if err != nil {
-		http.Error(w, "not found", http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 	path, err := s.generateAndUploadWaveform(r.Context(), row)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -80,13 +103,8 @@ func (s *AnalysisService) handleReanalyze(w http.ResponseWriter, r *http.Request
 }
 
 func (s *AnalysisService) generateAndUploadWaveform(ctx context.Context, row *TrackRow) (string, error) {
-	inPath := row.FilePath
 	// Optional host→container remap as used in import service
-	hostPrefix := os.Getenv("IMPORT_HOST_PREFIX")
-	containerPrefix := os.Getenv("IMPORT_CONTAINER_PREFIX")
-	if hostPrefix != "" && containerPrefix != "" && len(inPath) >= len(hostPrefix) && inPath[:len(hostPrefix)] == hostPrefix {
-		inPath = containerPrefix + inPath[len(hostPrefix):]
-	}
+	inPath := s.paths.MapPath(row.FilePath)
 
 	tmpf, err := os.CreateTemp("", "wave-*.png")
 	if err != nil {
@@ -96,15 +114,22 @@ func (s *AnalysisService) generateAndUploadWaveform(ctx context.Context, row *Tr
 	tmpf.Close()
 	defer os.Remove(tmpPath)
 
-	// Requires ffmpeg available in PATH
-	cmd := exec.CommandContext(ctx, "ffmpeg",
+	// Requires ffmpeg (analysis.ffmpeg_path, default from PATH)
+	cmd := exec.CommandContext(ctx, s.ffmpeg,
 		"-hide_banner", "-y",
 		"-i", inPath,
 		"-filter_complex", "aformat=channel_layouts=mono,showwavespic=s=1200x200:colors=#00d1b2",
 		"-frames:v", "1",
 		tmpPath,
 	)
-	if err := cmd.Run(); err != nil {
+	// On cancellation (client gone or shutdown deadline) ask ffmpeg to stop,
+	// then kill it if it has not exited shortly after.
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = 3 * time.Second
+	start := time.Now()
+	err = runTraced(ctx, "ffmpeg waveform", cmd)
+	observeFFmpeg("waveform", start, err)
+	if err != nil {
 		return "", fmt.Errorf("ffmpeg failed: %w", err)
 	}
 
@@ -1,55 +1,115 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
-	"os"
 	"strings"
+	"sync"
 	"time"
 
 	keyfunc "github.com/MicahParks/keyfunc"
 	jwt "github.com/golang-jwt/jwt/v4"
 )
 
-var (
-	jwks *keyfunc.JWKS
-)
+// Auth validates Bearer JWTs against a JWKS endpoint or a shared HMAC secret.
+type Auth struct {
+	secret string
+	jwks   *keyfunc.JWKS
+
+	mu         sync.Mutex
+	refreshErr error // last background refresh failure
+}
 
-// maybeJWT enforces Bearer JWT validation if JWT_SECRET is set or SUPABASE_JWKS_URL is configured.
-func maybeJWT(next http.Handler) http.Handler {
-	secret := os.Getenv("JWT_SECRET")
-	jwksURL := os.Getenv("SUPABASE_JWKS_URL")
-	if jwks == nil && jwksURL != "" {
-		// initialize JWKS (with refresh)
-		options := keyfunc.Options{RefreshInterval: time.Hour}
-		if jw, err := keyfunc.Get(jwksURL, options); err == nil {
-			jwks = jw
+// NewAuth loads the JWKS (with hourly refresh) when configured. A JWKS that
+// cannot be fetched is an error rather than silently disabling auth.
+func NewAuth(cfg AuthConfig) (*Auth, error) {
+	a := &Auth{secret: cfg.JWTSecret}
+	if cfg.JWKSURL != "" {
+		jw, err := keyfunc.Get(cfg.JWKSURL, keyfunc.Options{
+			RefreshInterval:     time.Hour,
+			RefreshErrorHandler: a.setRefreshErr,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("jwks %s: %w", cfg.JWKSURL, err)
 		}
+		a.jwks = jw
+	}
+	return a, nil
+}
+
+func (a *Auth) setRefreshErr(err error) {
+	a.mu.Lock()
+	a.refreshErr = err
+	a.mu.Unlock()
+}
+
+// Check reports whether the JWKS is loaded. After a failed background refresh
+// it retries once, so recovery is visible without waiting for the next interval.
+func (a *Auth) Check(ctx context.Context) error {
+	if a.jwks == nil {
+		return nil
 	}
-	if secret == "" && jwks == nil {
+	a.mu.Lock()
+	failed := a.refreshErr != nil
+	a.refreshErr = nil
+	a.mu.Unlock()
+	if failed {
+		// The background refresher reports failures through setRefreshErr.
+		if err := a.jwks.Refresh(ctx, keyfunc.RefreshOptions{IgnoreRateLimit: true}); err != nil {
+			return fmt.Errorf("jwks refresh: %w", err)
+		}
+		a.mu.Lock()
+		err := a.refreshErr
+		a.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("jwks refresh: %w", err)
+		}
+	}
+	if a.jwks.Len() == 0 {
+		return errors.New("jwks has no keys")
+	}
+	return nil
+}
+
+// Close stops the background JWKS refresh.
+func (a *Auth) Close() error {
+	if a.jwks != nil {
+		a.jwks.EndBackground()
+	}
+	return nil
+}
+
+// Middleware enforces Bearer JWT validation if JWT_SECRET is set or SUPABASE_JWKS_URL is configured.
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	if a.secret == "" && a.jwks == nil {
 		return next
 	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		auth := r.Header.Get("Authorization")
 		if !strings.HasPrefix(strings.ToLower(auth), "bearer ") {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeProblem(w, r, newProblem(http.StatusUnauthorized, CodeUnauthorized, "missing bearer token"))
 			return
 		}
 		tokenStr := strings.TrimSpace(auth[len("Bearer "):])
 		var parser jwt.Parser
 		var claims jwt.MapClaims
 		var err error
-		if jwks != nil {
-			_, err = parser.ParseWithClaims(tokenStr, jwt.MapClaims{}, jwks.Keyfunc)
+		if a.jwks != nil {
+			_, err = parser.ParseWithClaims(tokenStr, jwt.MapClaims{}, a.jwks.Keyfunc)
 		} else {
 			_, err = parser.ParseWithClaims(tokenStr, jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
 				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 					return nil, jwt.ErrTokenUnverifiable
 				}
-				return []byte(secret), nil
+				return []byte(a.secret), nil
 			})
 		}
 		if err != nil {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeProblem(w, r, newProblem(http.StatusUnauthorized, CodeUnauthorized, "invalid bearer token"))
 			return
 		}
 		_ = claims // reserved for future user context extraction
@@ -0,0 +1,259 @@
+// Package autocue finds where a DJ would put cues in a track: the first
+// beat, the end of the intro, breakdowns, drops and the outro. It reads the
+// RMS energy envelope of the decoded audio, averages it per bar of the
+// track's beatgrid and splits the track into loud and quiet sections, with
+// boundaries moved onto four-bar phrases where that keeps them apart.
+package autocue
+
+import (
+	"encoding/binary"
+	"math"
+	"slices"
+
+	"meta-dj/services/api-go/beatgrid"
+)
+
+// Envelope is an io.Writer taking mono signed 16-bit little-endian PCM, as
+// ffmpeg -f s16le -ac 1 writes it, and keeping the RMS level of each window.
+type Envelope struct {
+	WindowMs float64
+	levels   []float64
+	size     int // samples per window
+	n        int // samples in the current window
+	sum      float64
+	carry    []byte // half a sample left over from the last Write
+}
+
+// NewEnvelope measures windows of windowMs at sampleRate.
+func NewEnvelope(sampleRate, windowMs int) *Envelope {
+	return &Envelope{WindowMs: float64(windowMs), size: max(1, sampleRate*windowMs/1000)}
+}
+
+func (e *Envelope) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(e.carry) > 0 && len(p) > 0 {
+		e.add(int16(binary.LittleEndian.Uint16([]byte{e.carry[0], p[0]})))
+		e.carry, p = nil, p[1:]
+	}
+	for ; len(p) >= 2; p = p[2:] {
+		e.add(int16(binary.LittleEndian.Uint16(p)))
+	}
+	if len(p) == 1 {
+		e.carry = []byte{p[0]}
+	}
+	return n, nil
+}
+
+func (e *Envelope) add(sample int16) {
+	v := float64(sample) / 32768
+	e.sum += v * v
+	if e.n++; e.n == e.size {
+		e.levels = append(e.levels, math.Sqrt(e.sum/float64(e.n)))
+		e.n, e.sum = 0, 0
+	}
+}
+
+// Levels returns the RMS of every window, 0-1, including a final partial one.
+func (e *Envelope) Levels() []float64 {
+	if e.n == 0 {
+		return e.levels
+	}
+	return append(slices.Clip(e.levels), math.Sqrt(e.sum/float64(e.n)))
+}
+
+// Kind is what a cue point marks.
+type Kind string
+
+const (
+	FirstBeat Kind = "first_beat"
+	IntroEnd  Kind = "intro_end"
+	Breakdown Kind = "breakdown"
+	Drop      Kind = "drop"
+	Outro     Kind = "outro"
+)
+
+// Point is a detected cue position.
+type Point struct {
+	Kind Kind
+	Ms   float64
+}
+
+// Loop is a suggested loop; Beats is its length.
+type Loop struct {
+	Kind    Kind // the point it starts at: FirstBeat or Outro
+	StartMs float64
+	Beats   int
+}
+
+// Result is what Detect found, points in position order.
+type Result struct {
+	Points []Point
+	Loops  []Loop
+}
+
+const (
+	// audible is the level, relative to the loud reference, where the
+	// music starts.
+	audible = 0.1
+	// loud is the bar energy, relative to the loud reference, from which a
+	// bar belongs to a loud section.
+	loud = 0.65
+	// minBars is the shortest section; shorter runs are fills and merge
+	// into their neighbours.
+	minBars = 4
+	// phraseBars is the phrase length boundaries are moved onto.
+	phraseBars = 4
+	// loopBars is the length of the intro and outro loops.
+	loopBars = 8
+)
+
+// Onset is the time in ms of the first window where the music is audible,
+// for anchoring a grid when the track has none.
+func Onset(levels []float64, windowMs float64) float64 {
+	ref := percentile(levels, 0.95)
+	for i, l := range levels {
+		if ref > 0 && l >= audible*ref {
+			return float64(i) * windowMs
+		}
+	}
+	return 0
+}
+
+// Detect places cue points and loops from an envelope of windowMs windows
+// on grid g. Silence gives an empty Result.
+func Detect(levels []float64, windowMs float64, g beatgrid.Grid) Result {
+	var res Result
+	if percentile(levels, 0.95) == 0 {
+		return res
+	}
+	endMs := float64(len(levels)) * windowMs
+	bpb := float64(g.BeatsPerBar)
+
+	// The first beat is the grid beat nearest the onset, unless that is
+	// more than a quarter beat before it.
+	first := math.Ceil(g.BeatAt(Onset(levels, windowMs)) - 0.25)
+	firstMs := max(0, g.TimeOf(first))
+	res.Points = append(res.Points, Point{FirstBeat, firstMs})
+	if g.TimeOf(first+float64(loopBars)*bpb) <= endMs {
+		res.Loops = append(res.Loops, Loop{FirstBeat, firstMs, loopBars * g.BeatsPerBar})
+	}
+
+	// Bars run on the grid's downbeats from the one at or before the first beat.
+	bar0 := math.Floor(first / bpb)
+	var energy []float64
+	for b := bar0; ; b++ {
+		from, to := g.TimeOf(b*bpb), g.TimeOf((b+1)*bpb)
+		if (from+to)/2 > endMs {
+			break
+		}
+		energy = append(energy, mean(levels, from/windowMs, to/windowMs))
+	}
+	barMs := func(i int) float64 { return max(firstMs, g.TimeOf((bar0+float64(i))*bpb)) }
+
+	segs := sections(energy)
+	if len(segs) == 0 {
+		return res
+	}
+	for i := 1; i < len(segs); i++ {
+		s, prev := segs[i], segs[i-1]
+		switch {
+		case s.loud && i == 1:
+			res.Points = append(res.Points, Point{IntroEnd, barMs(s.from)})
+		case s.loud:
+			res.Points = append(res.Points, Point{Drop, barMs(s.from)})
+		case i == len(segs)-1 && prev.loud:
+			res.Points = append(res.Points, Point{Outro, barMs(s.from)})
+		default:
+			res.Points = append(res.Points, Point{Breakdown, barMs(s.from)})
+		}
+	}
+	if last := segs[len(segs)-1]; last.loud {
+		// No quiet ending: the outro is the last full loop's worth of bars.
+		if i := (len(energy) - loopBars) / phraseBars * phraseBars; i > last.from {
+			res.Points = append(res.Points, Point{Outro, barMs(i)})
+		}
+	}
+	if p := res.Points[len(res.Points)-1]; p.Kind == Outro {
+		if beat := g.BeatAt(p.Ms); g.TimeOf(beat+float64(loopBars)*bpb) <= endMs {
+			res.Loops = append(res.Loops, Loop{Outro, p.Ms, loopBars * g.BeatsPerBar})
+		}
+	}
+	return res
+}
+
+// section is a run of bars [from, to) that are all loud or all quiet.
+type section struct {
+	from, to int
+	loud     bool
+}
+
+// sections splits bar energies into loud and quiet runs of at least
+// minBars, then moves each boundary onto the nearest phrase when that
+// leaves both sides non-empty.
+func sections(energy []float64) []section {
+	if len(energy) == 0 {
+		return nil
+	}
+	ref := percentile(energy, 0.9)
+	isLoud := make([]bool, len(energy))
+	for i, e := range energy {
+		isLoud[i] = e >= loud*ref
+	}
+	segs := runs(isLoud)
+	for len(segs) > 1 {
+		// Flip the shortest run that is too short, merging it away.
+		short := -1
+		for i, s := range segs {
+			if s.to-s.from < minBars && (short < 0 || s.to-s.from < segs[short].to-segs[short].from) {
+				short = i
+			}
+		}
+		if short < 0 {
+			break
+		}
+		for i := segs[short].from; i < segs[short].to; i++ {
+			isLoud[i] = !isLoud[i]
+		}
+		segs = runs(isLoud)
+	}
+	for i := 1; i < len(segs); i++ {
+		b := int(math.Round(float64(segs[i].from)/phraseBars)) * phraseBars
+		if b > segs[i-1].from && b < segs[i].to {
+			segs[i-1].to, segs[i].from = b, b
+		}
+	}
+	return segs
+}
+
+func runs(isLoud []bool) []section {
+	var out []section
+	for i, l := range isLoud {
+		if len(out) > 0 && out[len(out)-1].loud == l {
+			out[len(out)-1].to = i + 1
+			continue
+		}
+		out = append(out, section{i, i + 1, l})
+	}
+	return out
+}
+
+// mean averages levels over the windows from index from to index to.
+func mean(levels []float64, from, to float64) float64 {
+	i, j := max(0, int(from)), min(len(levels), int(math.Ceil(to)))
+	if i >= j {
+		return 0
+	}
+	sum := 0.0
+	for _, l := range levels[i:j] {
+		sum += l
+	}
+	return sum / float64(j-i)
+}
+
+func percentile(xs []float64, p float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	s := slices.Sorted(slices.Values(xs))
+	return s[int(p*float64(len(s)-1))]
+}
@@ -0,0 +1,118 @@
+package autocue
+
+import (
+	"encoding/binary"
+	"math"
+	"slices"
+	"testing"
+
+	"meta-dj/services/api-go/beatgrid"
+)
+
+const windowMs = 50
+
+// grid is 120 BPM in 4/4 from 1000ms: a bar every 2000ms.
+var grid = beatgrid.Grid{BeatsPerBar: 4, Markers: []beatgrid.Marker{{PositionMs: 1000, Bpm: 120}}}
+
+// envelope is a second of silence, then one level per bar of grid.
+func envelope(bars ...float64) []float64 {
+	levels := make([]float64, 1000/windowMs)
+	for _, l := range bars {
+		for range 2000 / windowMs {
+			levels = append(levels, l)
+		}
+	}
+	return levels
+}
+
+// repeat is n bars at level l.
+func repeat(l float64, n int) []float64 { return slices.Repeat([]float64{l}, n) }
+
+func TestEnvelope(t *testing.T) {
+	// Two samples a window; the writes split the second sample.
+	e := NewEnvelope(1000, 2)
+	var pcm []byte
+	for _, s := range []int16{16384, -16384, 8192} {
+		pcm = binary.LittleEndian.AppendUint16(pcm, uint16(s))
+	}
+	for _, p := range [][]byte{pcm[:3], pcm[3:]} {
+		if n, err := e.Write(p); n != len(p) || err != nil {
+			t.Fatalf("Write = %d, %v", n, err)
+		}
+	}
+	got := e.Levels()
+	if len(got) != 2 || math.Abs(got[0]-0.5) > 1e-9 || math.Abs(got[1]-0.25) > 1e-9 {
+		t.Errorf("Levels = %v, want [0.5 0.25]", got)
+	}
+}
+
+func TestOnset(t *testing.T) {
+	levels := append(make([]float64, 10), 0.05, 0.5, 1, 1)
+	if got := Onset(levels, windowMs); got != 11*windowMs {
+		t.Errorf("Onset = %v, want %v", got, 11*windowMs)
+	}
+	if got := Onset(make([]float64, 10), windowMs); got != 0 {
+		t.Errorf("Onset of silence = %v, want 0", got)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	bar := func(i int) float64 { return grid.TimeOf(float64(i * 4)) }
+	quiet, full := 0.3, 1.0
+	for _, tc := range []struct {
+		name   string
+		bars   []float64
+		points []Point
+		loops  []Loop
+	}{
+		{
+			name: "quiet outro",
+			// Intro, drop with a one-bar fill, breakdown, drop, outro.
+			bars: slices.Concat(repeat(quiet, 16), repeat(full, 8), repeat(quiet, 1), repeat(full, 7),
+				repeat(quiet, 8), repeat(full, 16), repeat(quiet, 16)),
+			points: []Point{{FirstBeat, bar(0)}, {IntroEnd, bar(16)}, {Breakdown, bar(32)}, {Drop, bar(40)}, {Outro, bar(56)}},
+			loops:  []Loop{{FirstBeat, bar(0), 32}, {Outro, bar(56), 32}},
+		},
+		{
+			name:   "loud ending",
+			bars:   slices.Concat(repeat(quiet, 16), repeat(full, 16), repeat(quiet, 8), repeat(full, 16)),
+			points: []Point{{FirstBeat, bar(0)}, {IntroEnd, bar(16)}, {Breakdown, bar(32)}, {Drop, bar(40)}, {Outro, bar(48)}},
+			loops:  []Loop{{FirstBeat, bar(0), 32}, {Outro, bar(48), 32}},
+		},
+		{
+			name:   "off-phrase boundary",
+			bars:   slices.Concat(repeat(quiet, 15), repeat(full, 17)),
+			points: []Point{{FirstBeat, bar(0)}, {IntroEnd, bar(16)}, {Outro, bar(24)}},
+			loops:  []Loop{{FirstBeat, bar(0), 32}, {Outro, bar(24), 32}},
+		},
+		{
+			name:   "too short to loop",
+			bars:   repeat(full, 6),
+			points: []Point{{FirstBeat, bar(0)}},
+		},
+	} {
+		got := Detect(envelope(tc.bars...), windowMs, grid)
+		if !slices.Equal(got.Points, tc.points) {
+			t.Errorf("%s: points = %v, want %v", tc.name, got.Points, tc.points)
+		}
+		if !slices.Equal(got.Loops, tc.loops) {
+			t.Errorf("%s: loops = %v, want %v", tc.name, got.Loops, tc.loops)
+		}
+	}
+}
+
+func TestDetectFirstBeat(t *testing.T) {
+	// Music from 1100ms: the beat at 1000ms is within a quarter beat.
+	levels := slices.Concat(make([]float64, 22), slices.Repeat([]float64{1}, 400))
+	if got := Detect(levels, windowMs, grid); got.Points[0] != (Point{FirstBeat, 1000}) {
+		t.Errorf("first point = %v, want the first beat at 1000", got.Points[0])
+	}
+	// From 1200ms it is not: the next beat, 1500ms.
+	levels = slices.Concat(make([]float64, 24), slices.Repeat([]float64{1}, 400))
+	if got := Detect(levels, windowMs, grid); got.Points[0] != (Point{FirstBeat, 1500}) {
+		t.Errorf("first point = %v, want the first beat at 1500", got.Points[0])
+	}
+	if got := Detect(make([]float64, 400), windowMs, grid); len(got.Points) != 0 || len(got.Loops) != 0 {
+		t.Errorf("Detect(silence) = %+v, want nothing", got)
+	}
+}
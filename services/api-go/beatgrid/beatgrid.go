@@ -0,0 +1,166 @@
+// Package beatgrid models a track's beatgrid as tempo markers: the first
+// marker anchors the grid on a downbeat and each marker sets the tempo up to
+// the next, so live-drummed tracks can drift. It converts between time and
+// beats and snaps positions to the nearest beat or bar.
+package beatgrid
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+)
+
+// Marker starts a tempo at a beat.
+type Marker struct {
+	PositionMs float64 `json:"position_ms"`
+	Bpm        float64 `json:"bpm"`
+}
+
+// Grid is a beatgrid. Markers are in position order; beats are counted
+// continuously from the first, so bars stay in phase across tempo changes.
+type Grid struct {
+	BeatsPerBar int      `json:"beats_per_bar"`
+	Markers     []Marker `json:"markers"`
+}
+
+// Unit is what Snap rounds to.
+type Unit string
+
+const (
+	Beat Unit = "beat"
+	Bar  Unit = "bar"
+)
+
+// ParseUnit accepts "beat" and "bar".
+func ParseUnit(s string) (Unit, bool) {
+	switch u := Unit(s); u {
+	case Beat, Bar:
+		return u, true
+	}
+	return "", false
+}
+
+// MaxBpm bounds marker tempos.
+const MaxBpm = 999
+
+// Validate reports the first problem with g: no markers, a tempo out of
+// (0, MaxBpm], a negative or out-of-order position, or two markers less
+// than a beat apart.
+func (g Grid) Validate() error {
+	if g.BeatsPerBar < 1 || g.BeatsPerBar > 16 {
+		return errors.New("beats_per_bar must be 1-16")
+	}
+	if len(g.Markers) == 0 {
+		return errors.New("a beatgrid needs at least one marker")
+	}
+	beats := g.beats()
+	for i, m := range g.Markers {
+		switch {
+		case !(m.Bpm > 0 && m.Bpm <= MaxBpm):
+			return fmt.Errorf("marker %d: bpm must be in (0, %d]", i, MaxBpm)
+		case !(m.PositionMs >= 0) || math.IsInf(m.PositionMs, 0):
+			return fmt.Errorf("marker %d: position_ms must not be negative", i)
+		case i > 0 && m.PositionMs <= g.Markers[i-1].PositionMs:
+			return fmt.Errorf("marker %d: markers must be in position order", i)
+		case i > 0 && beats[i] == beats[i-1]:
+			return fmt.Errorf("marker %d: less than a beat after the previous marker", i)
+		}
+	}
+	return nil
+}
+
+// beats returns the beat number of each marker: whole beats from the
+// anchor, each marker landing on the beat nearest it.
+func (g Grid) beats() []float64 {
+	out := make([]float64, len(g.Markers))
+	for i := 1; i < len(g.Markers); i++ {
+		prev := g.Markers[i-1]
+		out[i] = out[i-1] + math.Round((g.Markers[i].PositionMs-prev.PositionMs)*prev.Bpm/60000)
+	}
+	return out
+}
+
+// BeatAt is the beat number at ms, fractional between beats. The first
+// tempo extends back before the anchor, the last one forward.
+func (g Grid) BeatAt(ms float64) float64 {
+	beats := g.beats()
+	i := max(0, sort.Search(len(g.Markers), func(i int) bool { return g.Markers[i].PositionMs > ms })-1)
+	m := g.Markers[i]
+	return beats[i] + (ms-m.PositionMs)*m.Bpm/60000
+}
+
+// TimeOf is the time of beat number beat, in ms.
+func (g Grid) TimeOf(beat float64) float64 {
+	beats := g.beats()
+	i := max(0, sort.Search(len(beats), func(i int) bool { return beats[i] > beat })-1)
+	m := g.Markers[i]
+	return m.PositionMs + (beat-beats[i])*60000/m.Bpm
+}
+
+// Snap moves ms to the nearest beat, or downbeat for Bar.
+func (g Grid) Snap(ms float64, u Unit) float64 {
+	n := 1.0
+	if u == Bar {
+		n = float64(g.BeatsPerBar)
+	}
+	return g.TimeOf(math.Round(g.BeatAt(ms)/n) * n)
+}
+
+// Shift moves the anchor to anchorMs and every other marker with it.
+func (g Grid) Shift(anchorMs float64) Grid {
+	delta := anchorMs - g.Markers[0].PositionMs
+	out := g.clone()
+	for i := range out.Markers {
+		out.Markers[i].PositionMs += delta
+	}
+	return out
+}
+
+// WithTempo makes g a constant bpm from its anchor, dropping tempo changes.
+func (g Grid) WithTempo(bpm float64) Grid {
+	return Grid{BeatsPerBar: g.BeatsPerBar, Markers: []Marker{{PositionMs: g.Markers[0].PositionMs, Bpm: bpm}}}
+}
+
+// WithMarker adds a tempo change, replacing a marker at the same position.
+func (g Grid) WithMarker(m Marker) Grid {
+	out := g.clone()
+	out.Markers = slices.DeleteFunc(out.Markers, func(o Marker) bool { return o.PositionMs == m.PositionMs })
+	i := sort.Search(len(out.Markers), func(i int) bool { return out.Markers[i].PositionMs > m.PositionMs })
+	out.Markers = slices.Insert(out.Markers, i, m)
+	return out
+}
+
+func (g Grid) clone() Grid {
+	return Grid{BeatsPerBar: g.BeatsPerBar, Markers: slices.Clone(g.Markers)}
+}
+
+// FromAnalysis reads the analyzers' beatgrid_json:
+// {"bpm":128,"markers":[{"positionMs":0,"downbeat":true}]}. The first
+// downbeat marker (else the first marker, else 0) anchors a constant grid.
+func FromAnalysis(data []byte) (Grid, error) {
+	var a struct {
+		Bpm     float64 `json:"bpm"`
+		Markers []struct {
+			PositionMs float64 `json:"positionMs"`
+			Downbeat   bool    `json:"downbeat"`
+		} `json:"markers"`
+	}
+	if err := json.Unmarshal(data, &a); err != nil {
+		return Grid{}, err
+	}
+	anchor := 0.0
+	if len(a.Markers) > 0 {
+		anchor = a.Markers[0].PositionMs
+	}
+	for _, m := range a.Markers {
+		if m.Downbeat {
+			anchor = m.PositionMs
+			break
+		}
+	}
+	g := Grid{BeatsPerBar: 4, Markers: []Marker{{PositionMs: anchor, Bpm: a.Bpm}}}
+	return g, g.Validate()
+}
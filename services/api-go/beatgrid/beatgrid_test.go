@@ -0,0 +1,138 @@
+package beatgrid
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-6 }
+
+func TestSnapOneMarker(t *testing.T) {
+	// 120 BPM from 100ms: beats every 500ms, bars every 2000ms.
+	g := Grid{BeatsPerBar: 4, Markers: []Marker{{PositionMs: 100, Bpm: 120}}}
+	for _, tc := range []struct {
+		ms   float64
+		unit Unit
+		want float64
+	}{
+		{100, Beat, 100},
+		{340, Beat, 100},
+		{360, Beat, 600},
+		{849, Beat, 600},
+		{10_100, Beat, 10_100},
+		{0, Beat, 100},
+		{-160, Beat, -400}, // the tempo extends back before the anchor
+		{1000, Bar, 100},
+		{1200, Bar, 2100},
+		{5000, Bar, 4100},
+	} {
+		if got := g.Snap(tc.ms, tc.unit); !near(got, tc.want) {
+			t.Errorf("Snap(%v, %s) = %v, want %v", tc.ms, tc.unit, got, tc.want)
+		}
+	}
+	if b := g.BeatAt(1350); !near(b, 2.5) {
+		t.Errorf("BeatAt(1350) = %v, want 2.5", b)
+	}
+	if ms := g.TimeOf(-2); !near(ms, -900) {
+		t.Errorf("TimeOf(-2) = %v, want -900", ms)
+	}
+}
+
+func TestSnapAcrossTempoChanges(t *testing.T) {
+	// 120 BPM to 2100ms, then 60 BPM: the change lands on beat 4 (4.2
+	// rounds down) and beats run on every 1000ms from the marker.
+	g := Grid{BeatsPerBar: 4, Markers: []Marker{{PositionMs: 0, Bpm: 120}, {PositionMs: 2100, Bpm: 60}}}
+	for _, tc := range []struct {
+		ms   float64
+		unit Unit
+		want float64
+	}{
+		{1400, Beat, 1500},
+		{1900, Beat, 2100},
+		{2500, Beat, 2100},
+		{2700, Beat, 3100},
+		{5500, Beat, 5100}, // beat 7.4
+		{3000, Bar, 2100},  // beat 4.9: the bar at the change
+		{4700, Bar, 6100},  // beat 6.6 rounds to bar 2, beat 8
+		{900, Bar, 0},
+	} {
+		if got := g.Snap(tc.ms, tc.unit); !near(got, tc.want) {
+			t.Errorf("Snap(%v, %s) = %v, want %v", tc.ms, tc.unit, got, tc.want)
+		}
+	}
+	for _, ms := range []float64{-300, 0, 1234, 2100, 2101, 9876} {
+		if got := g.TimeOf(g.BeatAt(ms)); !near(got, ms) {
+			t.Errorf("TimeOf(BeatAt(%v)) = %v", ms, got)
+		}
+	}
+	// Three tempos: beats stay counted continuously.
+	g = g.WithMarker(Marker{PositionMs: 6100, Bpm: 240})
+	if b := g.BeatAt(6600); !near(b, 10) {
+		t.Errorf("BeatAt(6600) with a third tempo = %v, want 10", b)
+	}
+	if got := g.Snap(6700, Beat); !near(got, 6600) {
+		t.Errorf("Snap(6700) = %v, want 6600", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	m := func(pos, bpm float64) Marker { return Marker{PositionMs: pos, Bpm: bpm} }
+	for _, tc := range []struct {
+		g  Grid
+		ok bool
+	}{
+		{Grid{BeatsPerBar: 4, Markers: []Marker{m(0, 128)}}, true},
+		{Grid{BeatsPerBar: 3, Markers: []Marker{m(50, 90), m(4050, 120)}}, true},
+		{Grid{BeatsPerBar: 0, Markers: []Marker{m(0, 128)}}, false},
+		{Grid{BeatsPerBar: 17, Markers: []Marker{m(0, 128)}}, false},
+		{Grid{BeatsPerBar: 4}, false},
+		{Grid{BeatsPerBar: 4, Markers: []Marker{m(0, 0)}}, false},
+		{Grid{BeatsPerBar: 4, Markers: []Marker{m(0, MaxBpm+1)}}, false},
+		{Grid{BeatsPerBar: 4, Markers: []Marker{m(-1, 128)}}, false},
+		{Grid{BeatsPerBar: 4, Markers: []Marker{m(math.NaN(), 128)}}, false},
+		{Grid{BeatsPerBar: 4, Markers: []Marker{m(1000, 128), m(500, 128)}}, false},
+		{Grid{BeatsPerBar: 4, Markers: []Marker{m(0, 120), m(200, 128)}}, false}, // under a beat apart
+	} {
+		if err := tc.g.Validate(); (err == nil) != tc.ok {
+			t.Errorf("Validate(%+v) = %v, want ok=%v", tc.g, err, tc.ok)
+		}
+	}
+}
+
+func TestEdits(t *testing.T) {
+	g := Grid{BeatsPerBar: 4, Markers: []Marker{{PositionMs: 100, Bpm: 120}, {PositionMs: 2100, Bpm: 60}}}
+	if s := g.Shift(350); s.Markers[0].PositionMs != 350 || s.Markers[1].PositionMs != 2350 || g.Markers[0].PositionMs != 100 {
+		t.Errorf("Shift(350) = %+v (original %+v)", s, g)
+	}
+	if w := g.WithTempo(128); len(w.Markers) != 1 || w.Markers[0] != (Marker{PositionMs: 100, Bpm: 128}) {
+		t.Errorf("WithTempo(128) = %+v", w)
+	}
+	w := g.WithMarker(Marker{PositionMs: 2100, Bpm: 90}).WithMarker(Marker{PositionMs: 1100, Bpm: 100})
+	want := []Marker{{100, 120}, {1100, 100}, {2100, 90}}
+	if len(w.Markers) != 3 || w.Markers[0] != want[0] || w.Markers[1] != want[1] || w.Markers[2] != want[2] {
+		t.Errorf("WithMarker = %+v, want %+v", w.Markers, want)
+	}
+}
+
+func TestFromAnalysis(t *testing.T) {
+	for _, tc := range []struct {
+		json   string
+		anchor float64
+		ok     bool
+	}{
+		{`{"bpm":128,"markers":[{"positionMs":20},{"positionMs":489,"downbeat":true}]}`, 489, true},
+		{`{"bpm":128,"markers":[{"positionMs":20}]}`, 20, true},
+		{`{"bpm":128}`, 0, true},
+		{`{"bpm":0}`, 0, false},
+		{`not json`, 0, false},
+	} {
+		g, err := FromAnalysis([]byte(tc.json))
+		if (err == nil) != tc.ok {
+			t.Errorf("FromAnalysis(%s) = %v, want ok=%v", tc.json, err, tc.ok)
+			continue
+		}
+		if tc.ok && (g.BeatsPerBar != 4 || g.Markers[0].PositionMs != tc.anchor) {
+			t.Errorf("FromAnalysis(%s) = %+v, want anchor %v", tc.json, g, tc.anchor)
+		}
+	}
+}
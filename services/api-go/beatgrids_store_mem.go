@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"meta-dj/services/api-go/beatgrid"
+)
+
+// MemBeatgridStore keeps edited grids only; the memory backend has no
+// analysis to fall back to.
+type MemBeatgridStore struct {
+	mu    sync.RWMutex
+	grids map[string]BeatgridRow
+}
+
+func NewMemBeatgridStore() *MemBeatgridStore {
+	return &MemBeatgridStore{grids: map[string]BeatgridRow{}}
+}
+
+func (s *MemBeatgridStore) Get(ctx context.Context, trackID string) (*BeatgridRow, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	row, ok := s.grids[trackID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &row, nil
+}
+
+func (s *MemBeatgridStore) Put(ctx context.Context, trackID string, g beatgrid.Grid, version int64) (*BeatgridRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.grids[trackID]
+	if version != 0 && prev.Version != version {
+		return nil, ErrVersionMismatch
+	}
+	now := time.Now().UTC()
+	row := BeatgridRow{TrackID: trackID, Grid: g, Source: "edited", Version: prev.Version + 1, UpdatedAt: &now}
+	s.grids[trackID] = row
+	return &row, nil
+}
+
+func (s *MemBeatgridStore) Insert(ctx context.Context, trackID string, g beatgrid.Grid) (*BeatgridRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.grids[trackID]; ok {
+		return nil, ErrVersionMismatch
+	}
+	now := time.Now().UTC()
+	row := BeatgridRow{TrackID: trackID, Grid: g, Source: "edited", Version: 1, UpdatedAt: &now}
+	s.grids[trackID] = row
+	return &row, nil
+}
+
+func (s *MemBeatgridStore) Delete(ctx context.Context, trackID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.grids, trackID)
+	return nil
+}
+
+// dropTrack deletes the grid of a deleted track.
+func (s *MemBeatgridStore) dropTrack(trackID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.grids, trackID)
+}
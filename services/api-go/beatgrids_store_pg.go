@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"meta-dj/services/api-go/beatgrid"
+)
+
+type PgBeatgridStore struct{ conn *pgxpool.Pool }
+
+func NewPgBeatgridStore(pool *pgxpool.Pool) *PgBeatgridStore {
+	return &PgBeatgridStore{conn: pool}
+}
+
+func (s *PgBeatgridStore) Get(ctx context.Context, trackID string) (*BeatgridRow, error) {
+	row := BeatgridRow{TrackID: trackID, Source: "edited"}
+	err := s.conn.QueryRow(ctx, `SELECT beats_per_bar, markers, version, updated_at FROM beatgrids WHERE track_id=$1`, trackID).
+		Scan(&row.BeatsPerBar, &row.Markers, &row.Version, &row.UpdatedAt)
+	if err == nil {
+		return &row, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	var data []byte
+	err = s.conn.QueryRow(ctx, `SELECT beatgrid_json FROM analysis WHERE track_id=$1 AND beatgrid_json IS NOT NULL
+             ORDER BY created_at DESC LIMIT 1`, trackID).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	g, err := beatgrid.FromAnalysis(data)
+	if err != nil {
+		return nil, ErrNotFound // an analysis without a usable tempo has no grid
+	}
+	return &BeatgridRow{TrackID: trackID, Grid: g, Source: "analysis"}, nil
+}
+
+func (s *PgBeatgridStore) Put(ctx context.Context, trackID string, g beatgrid.Grid, version int64) (*BeatgridRow, error) {
+	row := BeatgridRow{TrackID: trackID, Grid: g, Source: "edited"}
+	var err error
+	if version == 0 {
+		err = s.conn.QueryRow(ctx, `INSERT INTO beatgrids (track_id, beats_per_bar, markers) VALUES ($1,$2,$3)
+             ON CONFLICT (track_id) DO UPDATE SET beats_per_bar=EXCLUDED.beats_per_bar, markers=EXCLUDED.markers,
+                 version=beatgrids.version+1, updated_at=now()
+             RETURNING version, updated_at`, trackID, g.BeatsPerBar, g.Markers).Scan(&row.Version, &row.UpdatedAt)
+	} else {
+		err = s.conn.QueryRow(ctx, `UPDATE beatgrids SET beats_per_bar=$2, markers=$3, version=version+1, updated_at=now()
+             WHERE track_id=$1 AND version=$4 RETURNING version, updated_at`,
+			trackID, g.BeatsPerBar, g.Markers, version).Scan(&row.Version, &row.UpdatedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrVersionMismatch
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+func (s *PgBeatgridStore) Insert(ctx context.Context, trackID string, g beatgrid.Grid) (*BeatgridRow, error) {
+	row := BeatgridRow{TrackID: trackID, Grid: g, Source: "edited"}
+	err := s.conn.QueryRow(ctx, `INSERT INTO beatgrids (track_id, beats_per_bar, markers) VALUES ($1,$2,$3)
+             ON CONFLICT (track_id) DO NOTHING RETURNING version, updated_at`, trackID, g.BeatsPerBar, g.Markers).
+		Scan(&row.Version, &row.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrVersionMismatch
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+func (s *PgBeatgridStore) Delete(ctx context.Context, trackID string) error {
+	_, err := s.conn.Exec(ctx, `DELETE FROM beatgrids WHERE track_id=$1`, trackID)
+	return err
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
 	"net/http"
 
@@ -9,7 +8,7 @@ import (
 )
 
 type ChangesService struct {
-	Store *PgChangeStore
+	Store ChangeStore
 }
 
 func (s *ChangesService) Routes(r chi.Router) {
@@ -24,7 +23,7 @@ func (s *ChangesService) handleGet(w http.ResponseWriter, r *http.Request) {
 	since := r.URL.Query().Get("since")
 	items, err := s.Store.Since(r.Context(), since)
 	if err != nil {
-		http.Error(w, "error", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -33,13 +32,13 @@ func (s *ChangesService) handleGet(w http.ResponseWriter, r *http.Request) {
 
 func (s *ChangesService) handlePost(w http.ResponseWriter, r *http.Request) {
 	var payload []Change
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "invalid json", http.StatusBadRequest)
+	if err := decodeJSON(r, &payload); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	n, err := s.Store.Append(r.Context(), payload)
 	if err != nil {
-		http.Error(w, "error", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -47,10 +46,6 @@ func (s *ChangesService) handlePost(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]any{"status": "ok", "received": n})
 }
 
-func NewChangesService(ctx context.Context, dsn string) (*ChangesService, error) {
-	store, err := NewPgChangeStore(ctx, dsn)
-	if err != nil {
-		return nil, err
-	}
-	return &ChangesService{Store: store}, nil
+func NewChangesService(store ChangeStore) *ChangesService {
+	return &ChangesService{Store: store}
 }
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"gopkg.in/yaml.v3"
+
+	"meta-dj/services/api-go/musickey"
+)
+
+// Config is the single source of runtime settings. Load order (later wins):
+// built-in defaults, optional YAML file (--config / CONFIG_FILE), environment, flags.
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Store    StoreConfig    `yaml:"store"`
+	Database DatabaseConfig `yaml:"database"`
+	Storage  StorageConfig  `yaml:"storage"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Import   ImportConfig   `yaml:"import"`
+	Analysis AnalysisConfig `yaml:"analysis"`
+	Search   SearchConfig   `yaml:"search"`
+	Mixing   MixingConfig   `yaml:"mixing"`
+	Keys     KeysConfig     `yaml:"keys"`
+	Tracing  TracingConfig  `yaml:"tracing"`
+	// AllowDegraded keeps serving when a configured dependency fails at startup;
+	// affected routes answer 503 instead of the process exiting.
+	AllowDegraded bool `yaml:"allow_degraded"`
+}
+
+type ServerConfig struct {
+	Port         int           `yaml:"port"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	// ShutdownTimeout bounds draining of in-flight requests and background work.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// Debug mounts /debug/* (pool internals), behind auth.
+	Debug bool `yaml:"debug"`
+}
+
+type StoreConfig struct {
+	// Backend is "postgres" or "memory"; empty picks postgres when a database URL is set.
+	Backend string `yaml:"backend"`
+}
+
+type DatabaseConfig struct {
+	URL             string        `yaml:"url"`
+	AutoMigrate     bool          `yaml:"auto_migrate"`
+	MaxConns        int32         `yaml:"max_conns"`
+	MinConns        int32         `yaml:"min_conns"`
+	MaxConnLifetime time.Duration `yaml:"max_conn_lifetime"`
+	MaxConnIdleTime time.Duration `yaml:"max_conn_idle_time"`
+}
+
+type StorageConfig struct {
+	SupabaseURL    string `yaml:"supabase_url"`
+	ServiceRoleKey string `yaml:"service_role_key"`
+	Bucket         string `yaml:"bucket"`
+}
+
+type AuthConfig struct {
+	JWTSecret string `yaml:"jwt_secret"`
+	JWKSURL   string `yaml:"jwks_url"`
+}
+
+type ImportConfig struct {
+	HostPrefix      string `yaml:"host_prefix"`
+	ContainerPrefix string `yaml:"container_prefix"`
+}
+
+type AnalysisConfig struct {
+	FFmpegPath string `yaml:"ffmpeg_path"`
+}
+
+type SearchConfig struct {
+	// Fuzzy also matches q against titles and artist names by trigram
+	// similarity; requests override it with fuzzy=.
+	Fuzzy bool `yaml:"fuzzy"`
+	// SimilarityThreshold (0-1] is the word similarity a fuzzy match needs.
+	SimilarityThreshold float64 `yaml:"similarity_threshold"`
+	// SuggestionThreshold (0-1] is the looser bar for "did you mean" suggestions.
+	SuggestionThreshold float64 `yaml:"suggestion_threshold"`
+}
+
+type MixingConfig struct {
+	// BpmWindow is the tempo difference, in percent, compatible tracks may
+	// have; requests override it with bpm_window=.
+	BpmWindow float64 `yaml:"bpm_window"`
+}
+
+type KeysConfig struct {
+	// Notation is how track responses write keys (classical, camelot or
+	// open_key); requests override it with key_notation=.
+	Notation string `yaml:"notation"`
+}
+
+type TracingConfig struct {
+	// Exporter is none, otlp (HTTP), stdout or file.
+	Exporter string `yaml:"exporter"`
+	// OTLPEndpoint is the full traces URL, e.g. http://localhost:4318/v1/traces.
+	// When empty the exporter honours the standard OTEL_EXPORTER_OTLP_* variables.
+	OTLPEndpoint string  `yaml:"otlp_endpoint"`
+	File         string  `yaml:"file"` // span output for the file exporter
+	ServiceName  string  `yaml:"service_name"`
+	SampleRatio  float64 `yaml:"sample_ratio"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:            8080,
+			ReadTimeout:     5 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			ShutdownTimeout: 15 * time.Second,
+		},
+		Storage:  StorageConfig{Bucket: "meta-dj"},
+		Analysis: AnalysisConfig{FFmpegPath: "ffmpeg"},
+		Search:   SearchConfig{Fuzzy: true, SimilarityThreshold: 0.5, SuggestionThreshold: 0.3},
+		Mixing:   MixingConfig{BpmWindow: 6},
+		Keys:     KeysConfig{Notation: "camelot"},
+		Tracing:  TracingConfig{Exporter: "none", ServiceName: "meta-dj-api", SampleRatio: 1},
+	}
+}
+
+// Configured reports whether any Supabase storage setting is present.
+func (c StorageConfig) Configured() bool { return c.SupabaseURL != "" || c.ServiceRoleKey != "" }
+
+// Check reports a partially configured storage client; nil when complete or absent.
+func (c StorageConfig) Check() error {
+	switch {
+	case !c.Configured():
+		return nil
+	case c.SupabaseURL == "":
+		return errors.New("storage: SUPABASE_URL is not set")
+	case c.ServiceRoleKey == "":
+		return errors.New("storage: SUPABASE_SERVICE_ROLE_KEY is not set")
+	}
+	return nil
+}
+
+// Enabled reports whether protected routes require a Bearer JWT.
+func (c AuthConfig) Enabled() bool { return c.JWTSecret != "" || c.JWKSURL != "" }
+
+// MapPath applies the optional host→container prefix remap used for API-driven imports.
+func (c ImportConfig) MapPath(p string) string {
+	if c.HostPrefix != "" && c.ContainerPrefix != "" && strings.HasPrefix(p, c.HostPrefix) {
+		return c.ContainerPrefix + strings.TrimPrefix(p, c.HostPrefix)
+	}
+	return p
+}
+
+func (c DatabaseConfig) Pool() PoolConfig {
+	return PoolConfig{
+		MaxConns:        c.MaxConns,
+		MinConns:        c.MinConns,
+		MaxConnLifetime: c.MaxConnLifetime,
+		MaxConnIdleTime: c.MaxConnIdleTime,
+	}
+}
+
+// BackendName resolves the store backend, defaulting on the database URL.
+func (c Config) BackendName() string {
+	if c.Store.Backend != "" {
+		return c.Store.Backend
+	}
+	if c.Database.URL != "" {
+		return "postgres"
+	}
+	return "memory"
+}
+
+// LoadOptions carries the command-line switches that are not config values.
+type LoadOptions struct {
+	PrintConfig bool
+	Args        []string // positional arguments left after flags, e.g. ["migrate", "up"]
+}
+
+// LoadConfig builds and validates the configuration from args (without the program name).
+func LoadConfig(args []string) (*Config, LoadOptions, error) {
+	var opts LoadOptions
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	port := fs.Int("port", 0, "HTTP listen port")
+	backend := fs.String("store-backend", "", "store backend: postgres or memory")
+	dbURL := fs.String("database-url", "", "Postgres connection string")
+	autoMigrate := fs.Bool("auto-migrate", false, "apply pending migrations at startup")
+	allowDegraded := fs.Bool("allow-degraded", false, "serve with failed dependencies disabled instead of exiting")
+	fs.BoolVar(&opts.PrintConfig, "print-config", false, "print the effective config (secrets redacted) and exit")
+	if err := fs.Parse(args); err != nil {
+		return nil, opts, err
+	}
+	opts.Args = fs.Args()
+
+	cfg := defaultConfig()
+	if *configPath != "" {
+		f, err := os.Open(*configPath)
+		if err != nil {
+			return nil, opts, fmt.Errorf("config file: %w", err)
+		}
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		err = dec.Decode(&cfg)
+		f.Close()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, opts, fmt.Errorf("config file %s: %w", *configPath, err)
+		}
+	}
+	if err := applyEnv(&cfg, os.LookupEnv); err != nil {
+		return nil, opts, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Server.Port = *port
+		case "store-backend":
+			cfg.Store.Backend = *backend
+		case "database-url":
+			cfg.Database.URL = *dbURL
+		case "auto-migrate":
+			cfg.Database.AutoMigrate = *autoMigrate
+		case "allow-degraded":
+			cfg.AllowDegraded = *allowDegraded
+		}
+	})
+	if err := cfg.Validate(); err != nil {
+		return nil, opts, err
+	}
+	return &cfg, opts, nil
+}
+
+// applyEnv overlays environment variables; malformed values are errors, not ignored.
+func applyEnv(cfg *Config, lookup func(string) (string, bool)) error {
+	// Empty values count as unset, matching docker compose's `${VAR:-}` defaults.
+	str := func(name string, dst *string) {
+		if v, ok := lookup(name); ok && v != "" {
+			*dst = v
+		}
+	}
+	var errs []error
+	boolean := func(name string, dst *bool) {
+		if v, ok := lookup(name); ok && v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = b
+		}
+	}
+	integer := func(name string, set func(int)) {
+		if v, ok := lookup(name); ok && v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			set(n)
+		}
+	}
+	float := func(name string, dst *float64) {
+		if v, ok := lookup(name); ok && v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = f
+		}
+	}
+	duration := func(name string, dst *time.Duration) {
+		if v, ok := lookup(name); ok && v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	integer("PORT", func(n int) { cfg.Server.Port = n })
+	duration("HTTP_READ_TIMEOUT", &cfg.Server.ReadTimeout)
+	duration("HTTP_WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
+	duration("HTTP_IDLE_TIMEOUT", &cfg.Server.IdleTimeout)
+	duration("SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout)
+	boolean("DEBUG_ENDPOINTS", &cfg.Server.Debug)
+	str("STORE_BACKEND", &cfg.Store.Backend)
+	str("DATABASE_URL", &cfg.Database.URL)
+	boolean("DB_AUTO_MIGRATE", &cfg.Database.AutoMigrate)
+	integer("DB_MAX_CONNS", func(n int) { cfg.Database.MaxConns = int32(n) })
+	integer("DB_MIN_CONNS", func(n int) { cfg.Database.MinConns = int32(n) })
+	duration("DB_MAX_CONN_LIFETIME", &cfg.Database.MaxConnLifetime)
+	duration("DB_MAX_CONN_IDLE_TIME", &cfg.Database.MaxConnIdleTime)
+	str("SUPABASE_URL", &cfg.Storage.SupabaseURL)
+	str("SUPABASE_SERVICE_ROLE_KEY", &cfg.Storage.ServiceRoleKey)
+	str("SUPABASE_STORAGE_BUCKET", &cfg.Storage.Bucket)
+	str("JWT_SECRET", &cfg.Auth.JWTSecret)
+	str("SUPABASE_JWKS_URL", &cfg.Auth.JWKSURL)
+	str("IMPORT_HOST_PREFIX", &cfg.Import.HostPrefix)
+	str("IMPORT_CONTAINER_PREFIX", &cfg.Import.ContainerPrefix)
+	str("FFMPEG_PATH", &cfg.Analysis.FFmpegPath)
+	boolean("SEARCH_FUZZY", &cfg.Search.Fuzzy)
+	float("SEARCH_SIMILARITY_THRESHOLD", &cfg.Search.SimilarityThreshold)
+	float("SEARCH_SUGGESTION_THRESHOLD", &cfg.Search.SuggestionThreshold)
+	float("MIXING_BPM_WINDOW", &cfg.Mixing.BpmWindow)
+	str("KEY_NOTATION", &cfg.Keys.Notation)
+	str("TRACING_EXPORTER", &cfg.Tracing.Exporter)
+	str("TRACING_OTLP_ENDPOINT", &cfg.Tracing.OTLPEndpoint)
+	str("TRACING_FILE", &cfg.Tracing.File)
+	str("OTEL_SERVICE_NAME", &cfg.Tracing.ServiceName)
+	float("TRACING_SAMPLE_RATIO", &cfg.Tracing.SampleRatio)
+	boolean("ALLOW_DEGRADED", &cfg.AllowDegraded)
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid setting at once so startup fails with one clear message.
+func (c *Config) Validate() error {
+	var errs []error
+	bad := func(format string, a ...any) { errs = append(errs, fmt.Errorf(format, a...)) }
+
+	if c.Server.Port <= 0 || c.Server.Port > 65535 {
+		bad("server.port: %d out of range", c.Server.Port)
+	}
+	for name, d := range map[string]time.Duration{
+		"server.read_timeout":     c.Server.ReadTimeout,
+		"server.write_timeout":    c.Server.WriteTimeout,
+		"server.idle_timeout":     c.Server.IdleTimeout,
+		"server.shutdown_timeout": c.Server.ShutdownTimeout,
+	} {
+		if d <= 0 {
+			bad("%s: must be positive", name)
+		}
+	}
+	switch c.Store.Backend {
+	case "", "postgres", "memory":
+	default:
+		bad("store.backend: %q is not postgres or memory", c.Store.Backend)
+	}
+	if c.BackendName() == "postgres" {
+		if c.Database.URL == "" {
+			bad("database.url: required for the postgres backend")
+		} else if _, err := pgxpool.ParseConfig(c.Database.URL); err != nil {
+			bad("database.url: not a postgres:// URL or key=value connection string: %v", err)
+		}
+	}
+	if c.Database.MaxConns < 0 || c.Database.MinConns < 0 {
+		bad("database: pool sizes must not be negative")
+	}
+	if c.Database.MaxConns > 0 && c.Database.MinConns > c.Database.MaxConns {
+		bad("database.min_conns (%d) exceeds max_conns (%d)", c.Database.MinConns, c.Database.MaxConns)
+	}
+	if c.Storage.SupabaseURL != "" {
+		if u, err := url.Parse(c.Storage.SupabaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			bad("storage.supabase_url: %q is not an absolute URL", c.Storage.SupabaseURL)
+		}
+	}
+	if c.Storage.Configured() && c.Storage.Bucket == "" {
+		bad("storage.bucket: required when storage is configured")
+	}
+	if c.Auth.JWKSURL != "" {
+		if u, err := url.Parse(c.Auth.JWKSURL); err != nil || u.Scheme == "" || u.Host == "" {
+			bad("auth.jwks_url: %q is not an absolute URL", c.Auth.JWKSURL)
+		}
+	}
+	if c.Import.HostPrefix != "" && c.Import.ContainerPrefix == "" {
+		bad("import: host_prefix requires container_prefix")
+	}
+	if c.Analysis.FFmpegPath == "" {
+		bad("analysis.ffmpeg_path: must not be empty")
+	}
+	if t := c.Search.SimilarityThreshold; t <= 0 || t > 1 {
+		bad("search.similarity_threshold: %v is not within (0, 1]", t)
+	}
+	if t := c.Search.SuggestionThreshold; t <= 0 || t > 1 {
+		bad("search.suggestion_threshold: %v is not within (0, 1]", t)
+	}
+	if w := c.Mixing.BpmWindow; w < 0 || w > 50 {
+		bad("mixing.bpm_window: %v is not within [0, 50] percent", w)
+	}
+	if _, ok := musickey.ParseNotation(c.Keys.Notation); !ok {
+		bad("keys.notation: %q is not classical, camelot or open_key", c.Keys.Notation)
+	}
+	switch c.Tracing.Exporter {
+	case "", "none", "otlp", "stdout":
+	case "file":
+		if c.Tracing.File == "" {
+			bad("tracing.file: required for the file exporter")
+		}
+	default:
+		bad("tracing.exporter: %q is not none, otlp, stdout or file", c.Tracing.Exporter)
+	}
+	if c.Tracing.OTLPEndpoint != "" {
+		if u, err := url.Parse(c.Tracing.OTLPEndpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			bad("tracing.otlp_endpoint: %q is not an absolute URL", c.Tracing.OTLPEndpoint)
+		}
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		bad("tracing.sample_ratio: %v is not within [0, 1]", c.Tracing.SampleRatio)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Redacted returns a copy safe to print: secrets keep only their presence.
+func (c Config) Redacted() Config {
+	mask := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return "<redacted>"
+	}
+	c.Storage.ServiceRoleKey = mask(c.Storage.ServiceRoleKey)
+	c.Auth.JWTSecret = mask(c.Auth.JWTSecret)
+	c.Database.URL = redactDSN(c.Database.URL)
+	return c
+}
+
+// dsnPassword matches the password of a key=value connection string,
+// quoted or not.
+var dsnPassword = regexp.MustCompile(`(\b(?:ssl)?password\s*=\s*)('(?:\\.|[^'\\])*'|\S+)`)
+
+// redactDSN masks the password of a postgres:// URL, in its userinfo or a
+// ?password= parameter, or of a key=value connection string.
+func redactDSN(dsn string) string {
+	if dsn == "" {
+		return ""
+	}
+	if !strings.Contains(dsn, "://") {
+		return dsnPassword.ReplaceAllString(dsn, "${1}redacted")
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "<redacted>"
+	}
+	if u.User != nil {
+		if _, has := u.User.Password(); has {
+			u.User = url.UserPassword(u.User.Username(), "redacted")
+		}
+	}
+	if q := u.Query(); q.Has("password") {
+		q.Set("password", "redacted")
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// PrintRedacted writes the effective configuration as YAML for --print-config.
+func (c Config) PrintRedacted(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	defer enc.Close()
+	return enc.Encode(c.Redacted())
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateDatabaseURL(t *testing.T) {
+	for _, tc := range []struct {
+		url string
+		ok  bool
+	}{
+		{"postgres://app:secret@db:5432/metadj?sslmode=disable", true},
+		{"postgresql://db/metadj", true},
+		{"host=db port=5432 user=app password=secret dbname=metadj sslmode=disable", true},
+		{"postgres://db:notaport/metadj", false},
+		{"host=db port=x", false},
+	} {
+		c := defaultConfig()
+		c.Store.Backend = "postgres"
+		c.Database.URL = tc.url
+		if err := c.Validate(); (err == nil) != tc.ok {
+			t.Errorf("Validate(%q) = %v, want ok=%v", tc.url, err, tc.ok)
+		}
+	}
+}
+
+func TestRedactDSN(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"", ""},
+		{"postgres://app:secret@db/metadj", "postgres://app:redacted@db/metadj"},
+		{"postgres://app@db/metadj", "postgres://app@db/metadj"},
+		{"postgres://db/metadj?password=secret&sslmode=disable", "postgres://db/metadj?password=redacted&sslmode=disable"},
+		{"host=db password=secret dbname=metadj", "host=db password=redacted dbname=metadj"},
+		{"host=db password = 'se cr\\'et' dbname=metadj", "host=db password = redacted dbname=metadj"},
+		{"host=db sslpassword=secret", "host=db sslpassword=redacted"},
+	} {
+		got := redactDSN(tc.in)
+		if got != tc.want {
+			t.Errorf("redactDSN(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+		if strings.Contains(got, "secret") || strings.Contains(got, "cr") {
+			t.Errorf("redactDSN(%q) leaks the password: %q", tc.in, got)
+		}
+	}
+}
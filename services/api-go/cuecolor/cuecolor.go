@@ -0,0 +1,74 @@
+// Package cuecolor normalizes cue colors to a fixed palette that round-trips
+// through DJ software: each entry carries the RGB value Rekordbox writes for
+// its hot cue color and the one Serato stores in its cue markers. Anything
+// else ("#ff0000", "red", Serato's "#CC0000") snaps to the nearest entry.
+package cuecolor
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Color is one palette entry. Hex is the canonical value the API stores,
+// the same as Rekordbox's.
+type Color struct {
+	Name      string
+	Hex       string // "#RRGGBB"
+	Rekordbox uint32 // 0xRRGGBB in rekordbox XML POSITION_MARK Red/Green/Blue
+	Serato    uint32 // 0xRRGGBB in Serato Markers2 CUE entries
+}
+
+// Palette lists the colors, in the order hot cue pads show them.
+var Palette = []Color{
+	{"red", "#E62828", 0xE62828, 0xCC0000},
+	{"orange", "#E0641B", 0xE0641B, 0xCC4400},
+	{"yellow", "#B4BE04", 0xB4BE04, 0xCCCC00},
+	{"green", "#28E214", 0x28E214, 0x00CC00},
+	{"aqua", "#00E0FF", 0x00E0FF, 0x00CCCC},
+	{"blue", "#305AFF", 0x305AFF, 0x0000CC},
+	{"purple", "#AA72FF", 0xAA72FF, 0x8800CC},
+	{"pink", "#FF127B", 0xFF127B, 0xCC0088},
+}
+
+// Normalize maps a palette name or a "#RRGGBB" / "RRGGBB" / "0xRRGGBB"
+// value to the nearest palette color. ok is false for anything else.
+func Normalize(s string) (c Color, ok bool) {
+	s = strings.TrimSpace(s)
+	for _, c := range Palette {
+		if strings.EqualFold(s, c.Name) {
+			return c, true
+		}
+	}
+	hex := strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(s, "#"), "0x"), "0X")
+	if len(hex) != 6 {
+		return c, false
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return c, false
+	}
+	return Nearest(uint32(rgb)), true
+}
+
+// Nearest returns the palette color closest to rgb, comparing against both
+// vendors' values so each maps back to its own entry.
+func Nearest(rgb uint32) Color {
+	best, bestD := Palette[0], -1
+	for _, c := range Palette {
+		for _, v := range []uint32{c.Rekordbox, c.Serato} {
+			if d := distance(rgb, v); bestD < 0 || d < bestD {
+				best, bestD = c, d
+			}
+		}
+	}
+	return best
+}
+
+// distance is a squared RGB distance weighted for how the eye sees green
+// and blue, good enough to tell eight hues apart.
+func distance(a, b uint32) int {
+	dr := int(a>>16&0xFF) - int(b>>16&0xFF)
+	dg := int(a>>8&0xFF) - int(b>>8&0xFF)
+	db := int(a&0xFF) - int(b&0xFF)
+	return 3*dr*dr + 4*dg*dg + 2*db*db
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// decodeCueList reads an array of cues that must each carry a distinct id.
+func decodeCueList(r *http.Request) ([]CueRow, error) {
+	var cues []CueRow
+	if err := decodeJSON(r, &cues); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var errs []FieldError
+	for i, c := range cues {
+		switch {
+		case c.ID == "":
+			errs = append(errs, FieldError{Field: itemField(i, "id"), Code: "required", Message: "id is required"})
+		case seen[c.ID]:
+			errs = append(errs, FieldError{Field: itemField(i, "id"), Code: "duplicate", Message: "id " + c.ID + " appears twice"})
+		}
+		seen[c.ID] = true
+	}
+	if len(errs) > 0 {
+		return nil, validationProblem(errs...)
+	}
+	return cues, nil
+}
+
+// handleReplace makes the body the track's whole cue set: cues it leaves
+// out are deleted, in the same transaction.
+func (s *CuesService) handleReplace(w http.ResponseWriter, r *http.Request) {
+	tid := chi.URLParam(r, "trackId")
+	cues, err := decodeCueList(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	for i := range cues {
+		cues[i].TrackID = tid
+	}
+	if _, err := s.Tracks.Get(r.Context(), tid); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.prepare(r, cues, true, itemField); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.Store.ReplaceTrack(r.Context(), tid, cues); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	s.writeTrackCues(w, r, tid)
+}
+
+// handleBatch upserts cues across any number of tracks, all or nothing,
+// and answers with them as saved.
+func (s *CuesService) handleBatch(w http.ResponseWriter, r *http.Request) {
+	cues, err := decodeCueList(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.prepare(r, cues, false, itemField); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.Store.UpsertMany(r.Context(), cues); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cues)
+}
+
+type cueCopyReq struct {
+	FromTrack string `json:"from_track"`
+	ToTrack   string `json:"to_track"`
+	// OffsetMs shifts every cue, e.g. by the difference in intro length
+	// between a promo and the purchased file.
+	OffsetMs int64 `json:"offset_ms"`
+	// Replace drops the target's own cues instead of adding to them.
+	Replace bool `json:"replace"`
+}
+
+type cueCopyResp struct {
+	Cues    []CueRow `json:"cues"`
+	Skipped []string `json:"skipped"` // source cues the offset moved off the track
+}
+
+// handleCopy copies a track's cues to another. Copies get ids derived from
+// the target and source cue, so copying again updates them instead of
+// piling up; a copied slot another cue holds goes to the first free pad.
+func (s *CuesService) handleCopy(w http.ResponseWriter, r *http.Request) {
+	var req cueCopyReq
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.FromTrack == req.ToTrack {
+		writeError(w, r, validationProblem(FieldError{Field: "to_track", Code: "invalid_value", Message: "to_track must differ from from_track"}))
+		return
+	}
+	ctx := r.Context()
+	var target *TrackRow // the last one fetched
+	for _, id := range []string{req.FromTrack, req.ToTrack} {
+		t, err := s.Tracks.Get(ctx, id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		target = t
+	}
+	src, err := s.Store.ListByTrack(ctx, req.FromTrack)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var stored []CueRow
+	if !req.Replace {
+		if stored, err = s.Store.ListByTrack(ctx, req.ToTrack); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	resp := cueCopyResp{Cues: []CueRow{}, Skipped: []string{}}
+	for _, c := range src {
+		pos := c.PositionMs + req.OffsetMs
+		if pos < 0 || target.DurationMs != nil && pos > *target.DurationMs {
+			resp.Skipped = append(resp.Skipped, c.ID)
+			continue
+		}
+		c.ID, c.TrackID, c.PositionMs = sha1Hex(req.ToTrack+"/"+c.ID), req.ToTrack, pos
+		if c.Slot != nil && slices.ContainsFunc(stored, func(o CueRow) bool {
+			return o.ID != c.ID && o.Slot != nil && *o.Slot == *c.Slot
+		}) {
+			c.Slot = nil
+		}
+		resp.Cues = append(resp.Cues, c)
+	}
+	if err := s.prepare(r, resp.Cues, req.Replace, itemField); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Replace {
+		err = s.Store.ReplaceTrack(ctx, req.ToTrack, resp.Cues)
+	} else {
+		err = s.Store.UpsertMany(ctx, resp.Cues)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *CuesService) writeTrackCues(w http.ResponseWriter, r *http.Request, trackID string) {
+	rows, err := s.Store.ListByTrack(r.Context(), trackID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rows)
+}
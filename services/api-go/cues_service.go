@@ -1,28 +1,184 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
+
+	"meta-dj/services/api-go/cuecolor"
 )
 
-type CuesService struct{ Store *PgCueStore }
+type CuesService struct {
+	Store  CueStore
+	Tracks TrackStore    // positions are checked against the track's duration
+	Grids  BeatgridStore // and snapped to its beatgrid with ?quantize=
+}
+
+func NewCuesService(store CueStore, tracks TrackStore, grids BeatgridStore) *CuesService {
+	return &CuesService{Store: store, Tracks: tracks, Grids: grids}
+}
 
-func NewCuesService(ctx context.Context, dsn string) (*CuesService, error) {
-	st, err := NewPgCueStore(ctx, dsn)
+// hotCueSlots are the pads of a CDJ or controller, in order.
+var hotCueSlots = []string{"A", "B", "C", "D", "E", "F", "G", "H"}
+
+// prepare validates cues written together and normalizes them: positions
+// are quantized as r asks, types upper-cased, colors snapped to the
+// cuecolor palette and HOT cues without a slot keep their current one or
+// take the first free pad. With replace the write is a track's whole cue
+// set, so stored cues hold no slots. field names the field of the i-th cue
+// in errors.
+func (s *CuesService) prepare(r *http.Request, cues []CueRow, replace bool, field func(i int, name string) string) error {
+	ctx := r.Context()
+	q, err := newQuantizer(r, s.Grids)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	var errs []FieldError
+	tracks := map[string]*TrackRow{}
+	for i := range cues {
+		cue := &cues[i]
+		track, ok := tracks[cue.TrackID]
+		if !ok {
+			t, err := s.Tracks.Get(ctx, cue.TrackID)
+			switch {
+			case errors.Is(err, ErrNotFound):
+				return newProblem(http.StatusUnprocessableEntity, CodeUnprocessable, "track "+cue.TrackID+" does not exist")
+			case err != nil:
+				return err
+			}
+			track, tracks[cue.TrackID] = t, t
+		}
+		cue.Autogen = false // a cue written here is the user's, even if autocue placed it
+		if cue.PositionMs, err = q.snap(ctx, cue.TrackID, cue.PositionMs); err != nil {
+			return err
+		}
+		for _, e := range checkCue(cue, track) {
+			e.Field = field(i, e.Field)
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) > 0 {
+		return validationProblem(errs...)
 	}
-	return &CuesService{Store: st}, nil
+	for id := range tracks {
+		var stored []CueRow
+		if !replace {
+			var err error
+			if stored, err = s.Store.ListByTrack(ctx, id); err != nil {
+				return err
+			}
+		}
+		if err := assignSlots(cues, id, stored); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// checkCue normalizes cue and reports what is wrong with it for track.
+func checkCue(cue *CueRow, track *TrackRow) []FieldError {
+	var errs []FieldError
+	cue.Type = strings.ToUpper(cue.Type)
+	if cue.Type == "" {
+		cue.Type = CueHot
+	}
+	if !slices.Contains([]string{CueHot, CueMemory, CueLoop}, cue.Type) {
+		errs = append(errs, FieldError{Field: "type", Code: "invalid_value", Message: "type must be HOT, MEMORY or LOOP"})
+	}
+	if cue.Slot != nil {
+		slot := strings.ToUpper(*cue.Slot)
+		cue.Slot = &slot
+		switch {
+		case cue.Type != CueHot:
+			errs = append(errs, FieldError{Field: "slot", Code: "invalid_value", Message: "only HOT cues have a slot"})
+		case !slices.Contains(hotCueSlots, slot):
+			errs = append(errs, FieldError{Field: "slot", Code: "invalid_value", Message: "slot must be A-H"})
+		}
+	}
+	if cue.Color != nil {
+		if c, ok := cuecolor.Normalize(*cue.Color); ok {
+			cue.Color = &c.Hex
+		} else {
+			errs = append(errs, FieldError{Field: "color", Code: "invalid_format", Message: `color must be "#RRGGBB" or a palette name`})
+		}
+	}
+	if cue.PositionMs < 0 {
+		errs = append(errs, FieldError{Field: "position_ms", Code: "out_of_range", Message: "position_ms must not be negative"})
+	} else if track.DurationMs != nil && cue.PositionMs > *track.DurationMs {
+		errs = append(errs, FieldError{Field: "position_ms", Code: "out_of_range", Message: fmt.Sprintf("position_ms is past the end of the track (%d ms)", *track.DurationMs)})
+	}
+	return errs
+}
+
+// assignSlots gives the HOT cues of trackID in cues a slot each. Stored
+// cues the write does not overwrite keep theirs; two cues asking for one
+// slot, or a ninth hot cue, is a conflict.
+func assignSlots(cues []CueRow, trackID string, stored []CueRow) error {
+	writing := map[string]bool{}
+	for _, c := range cues {
+		if c.TrackID == trackID {
+			writing[c.ID] = true
+		}
+	}
+	taken := map[string]bool{}
+	current := map[string]*string{} // slots of the stored cues being rewritten
+	for _, c := range stored {
+		switch {
+		case c.Slot == nil:
+		case writing[c.ID]:
+			current[c.ID] = c.Slot
+		default:
+			taken[*c.Slot] = true
+		}
+	}
+	for _, c := range cues {
+		if c.TrackID != trackID || c.Slot == nil {
+			continue
+		}
+		if taken[*c.Slot] {
+			return newProblem(http.StatusConflict, CodeConflict, "hot cue slot "+*c.Slot+" is taken")
+		}
+		taken[*c.Slot] = true
+	}
+	for i := range cues {
+		c := &cues[i]
+		if c.TrackID != trackID || c.Type != CueHot || c.Slot != nil {
+			continue
+		}
+		if cur := current[c.ID]; cur != nil && !taken[*cur] {
+			c.Slot = cur
+			taken[*cur] = true
+			continue
+		}
+		free := slices.IndexFunc(hotCueSlots, func(slot string) bool { return !taken[slot] })
+		if free < 0 {
+			return newProblem(http.StatusConflict, CodeConflict, "all eight hot cue slots are taken")
+		}
+		slot := hotCueSlots[free]
+		c.Slot, taken[slot] = &slot, true
+	}
+	return nil
+}
+
+// fieldName is prepare's field for a single cue.
+func fieldName(_ int, name string) string { return name }
+
+// itemField is prepare's field for the i-th cue of an array body.
+func itemField(i int, name string) string { return fmt.Sprintf("[%d].%s", i, name) }
+
 func (s *CuesService) Routes(r chi.Router) {
 	r.Get("/track/{trackId}", s.handleListByTrack)
 }
 
 func (s *CuesService) ProtectedRoutes(r chi.Router) {
+	r.Put("/track/{trackId}", s.handleReplace)
+	r.Post("/batch", s.handleBatch)
+	r.Post("/copy", s.handleCopy)
 	r.Put("/{id}", s.handleUpsert)
 	r.Delete("/{id}", s.handleDelete)
 }
@@ -31,7 +187,7 @@ func (s *CuesService) handleListByTrack(w http.ResponseWriter, r *http.Request)
 	tid := chi.URLParam(r, "trackId")
 	rows, err := s.Store.ListByTrack(r.Context(), tid)
 	if err != nil {
-		http.Error(w, "error", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -41,13 +197,19 @@ func (s *CuesService) handleListByTrack(w http.ResponseWriter, r *http.Request)
 func (s *CuesService) handleUpsert(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	var body CueRow
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "bad json", http.StatusBadRequest)
+	if err := decodeJSON(r, &body); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	body.ID = id
+	cues := []CueRow{body}
+	if err := s.prepare(r, cues, false, fieldName); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	body = cues[0]
 	if err := s.Store.Upsert(r.Context(), body); err != nil {
-		http.Error(w, "error", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -56,7 +218,7 @@ func (s *CuesService) handleUpsert(w http.ResponseWriter, r *http.Request) {
 func (s *CuesService) handleDelete(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if err := s.Store.Delete(r.Context(), id); err != nil {
-		http.Error(w, "error", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestCueIdsStayOnTheirTrack(t *testing.T) {
+	ctx := context.Background()
+	cues := NewMemCueStore()
+	grids := NewMemBeatgridStore()
+	tracks := NewMemTrackStore(cues, NewMemLoopStore(), grids)
+	for _, id := range []string{"a", "b"} {
+		if _, err := tracks.Create(ctx, TrackRow{ID: id, TrackFields: TrackFields{Title: id, FilePath: "/music/" + id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cues.Upsert(ctx, CueRow{ID: "cue-a", TrackID: "a", Type: CueMemory, PositionMs: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	svc := NewCuesService(cues, tracks, grids)
+	r := chi.NewRouter()
+	r.Route("/v1/cues", svc.ProtectedRoutes)
+
+	for _, tc := range []struct{ method, url, body string }{
+		{http.MethodPut, "/v1/cues/cue-a", `{"track_id":"b","position_ms":0,"type":"MEMORY"}`},
+		{http.MethodPut, "/v1/cues/track/b", `[{"id":"cue-a","position_ms":0,"type":"MEMORY"}]`},
+		{http.MethodPost, "/v1/cues/batch", `[{"id":"new","track_id":"b","position_ms":0,"type":"MEMORY"},{"id":"cue-a","track_id":"b","position_ms":0,"type":"MEMORY"}]`},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body)))
+		if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "cue-a belongs to another track") {
+			t.Errorf("%s %s = %d %s, want 409", tc.method, tc.url, w.Code, w.Body)
+		}
+	}
+
+	a, _ := cues.ListByTrack(ctx, "a")
+	b, _ := cues.ListByTrack(ctx, "b")
+	if len(a) != 1 || a[0].PositionMs != 1000 || len(b) != 0 {
+		t.Errorf("after rejected writes: a = %+v, b = %+v; want a untouched and b empty", a, b)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+)
+
+type MemCueStore struct {
+	mu   sync.RWMutex
+	cues map[string]CueRow
+}
+
+func NewMemCueStore() *MemCueStore {
+	return &MemCueStore{cues: map[string]CueRow{}}
+}
+
+func (s *MemCueStore) ListByTrack(ctx context.Context, trackID string) ([]CueRow, error) {
+	s.mu.RLock()
+	out := []CueRow{}
+	for _, c := range s.cues {
+		if c.TrackID == trackID {
+			out = append(out, c)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].PositionMs < out[j].PositionMs })
+	return out, nil
+}
+
+func (s *MemCueStore) Upsert(ctx context.Context, cue CueRow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.cues[cue.ID]; ok && prev.TrackID != cue.TrackID {
+		// Like the Postgres upsert, a cue never moves between tracks.
+		return fmt.Errorf("cue %s %w", cue.ID, ErrOtherTrack)
+	}
+	if cue.Slot != nil && s.slotTaken(cue.TrackID, *cue.Slot, cue.ID) {
+		return ErrConflict
+	}
+	s.cues[cue.ID] = cue
+	return nil
+}
+
+func (s *MemCueStore) UpsertMany(ctx context.Context, cues []CueRow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.apply(cues, nil)
+}
+
+func (s *MemCueStore) ReplaceTrack(ctx context.Context, trackID string, cues []CueRow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.apply(cues, func(c CueRow) bool { return c.TrackID == trackID })
+}
+
+func (s *MemCueStore) ReplaceAutogen(ctx context.Context, trackID string, cues []CueRow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.apply(cues, func(c CueRow) bool { return c.TrackID == trackID && c.Autogen })
+}
+
+// apply drops the cues matching drop, then upserts cues, all or nothing:
+// it works on a copy and swaps it in only when every slot is still unique.
+// Callers hold mu.
+func (s *MemCueStore) apply(cues []CueRow, drop func(CueRow) bool) error {
+	staged := maps.Clone(s.cues)
+	if drop != nil {
+		maps.DeleteFunc(staged, func(_ string, c CueRow) bool { return drop(c) })
+	}
+	for _, cue := range cues {
+		if prev, ok := staged[cue.ID]; ok && prev.TrackID != cue.TrackID {
+			return fmt.Errorf("cue %s %w", cue.ID, ErrOtherTrack)
+		}
+		staged[cue.ID] = cue
+	}
+	slots := map[[2]string]bool{}
+	for _, c := range staged {
+		if c.Slot == nil {
+			continue
+		}
+		k := [2]string{c.TrackID, *c.Slot}
+		if slots[k] {
+			return ErrConflict
+		}
+		slots[k] = true
+	}
+	s.cues = staged
+	return nil
+}
+
+func (s *MemCueStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cues, id)
+	return nil
+}
+
+// dropTrack deletes the cues of a deleted track.
+func (s *MemCueStore) dropTrack(trackID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	maps.DeleteFunc(s.cues, func(_ string, c CueRow) bool { return c.TrackID == trackID })
+}
+
+// slotTaken reports whether another cue of the track holds hot cue slot.
+// Callers hold mu.
+func (s *MemCueStore) slotTaken(trackID, slot, except string) bool {
+	for id, c := range s.cues {
+		if id != except && c.TrackID == trackID && c.Slot != nil && *c.Slot == slot {
+			return true
+		}
+	}
+	return false
+}
+
+// moveTracks hands the cues of tracks from to track to by the Postgres
+// merge's rules: cues of the same type within 50ms of one to already had
+// are dropped, then a slot to holds, or a lower remaining cue id of from
+// holds, is cleared. It returns how many cues it dropped and cleared.
+func (s *MemCueStore) moveTracks(to string, from []string) (dropped, cleared int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var kept []CueRow
+	for _, c := range s.cues {
+		if c.TrackID == to {
+			kept = append(kept, c)
+		}
+	}
+	var moving []string
+	for _, id := range slices.Sorted(maps.Keys(s.cues)) {
+		c := s.cues[id]
+		if !slices.Contains(from, c.TrackID) {
+			continue
+		}
+		if slices.ContainsFunc(kept, func(k CueRow) bool {
+			d := k.PositionMs - c.PositionMs
+			return k.Type == c.Type && d >= -50 && d <= 50
+		}) {
+			delete(s.cues, id)
+			dropped++
+			continue
+		}
+		moving = append(moving, id)
+	}
+	taken := func(slot string, below int) bool {
+		return slices.ContainsFunc(kept, func(k CueRow) bool { return k.Slot != nil && *k.Slot == slot }) ||
+			slices.ContainsFunc(moving[:below], func(id string) bool { o := s.cues[id]; return o.Slot != nil && *o.Slot == slot })
+	}
+	moved := make([]CueRow, len(moving))
+	for i, id := range moving {
+		c := s.cues[id]
+		if c.Slot != nil && taken(*c.Slot, i) {
+			c.Slot = nil
+			cleared++
+		}
+		c.TrackID = to
+		moved[i] = c
+	}
+	for _, c := range moved {
+		s.cues[c.ID] = c
+	}
+	return dropped, cleared
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type CueRow struct {
@@ -14,41 +17,34 @@ type CueRow struct {
 	Color      *string `json:"color,omitempty"`
 	Label      *string `json:"label,omitempty"`
 	Type       string  `json:"type"`
+	// Slot is the hot cue pad, A-H; only HOT cues have one.
+	Slot *string `json:"slot,omitempty"`
+	// Autogen marks cues placed by autocue, which a rerun replaces; writes
+	// through the cues API clear it, so an adjusted cue is the user's.
+	Autogen bool `json:"autogen"`
 }
 
-type PgCueStore struct{ conn *pgx.Conn }
+// Cue types, as in the SQLite schema plus LOOP.
+const (
+	CueHot    = "HOT"
+	CueMemory = "MEMORY"
+	CueLoop   = "LOOP"
+)
 
-func NewPgCueStore(ctx context.Context, dsn string) (*PgCueStore, error) {
-	c, err := pgx.Connect(ctx, dsn)
-	if err != nil {
-		return nil, err
-	}
-	s := &PgCueStore{conn: c}
-	if err := s.init(ctx); err != nil {
-		c.Close(ctx)
-		return nil, err
-	}
-	return s, nil
+const cueColumns = `id, track_id, position_ms, color, label, type, slot, autogen`
+
+func scanCue(c *CueRow) []any {
+	return []any{&c.ID, &c.TrackID, &c.PositionMs, &c.Color, &c.Label, &c.Type, &c.Slot, &c.Autogen}
 }
 
-func (s *PgCueStore) init(ctx context.Context) error {
-	_, err := s.conn.Exec(ctx, `
-CREATE TABLE IF NOT EXISTS cues (
-  id TEXT PRIMARY KEY,
-  track_id TEXT NOT NULL,
-  position_ms BIGINT NOT NULL,
-  color TEXT,
-  label TEXT,
-  type TEXT NOT NULL DEFAULT 'HOT',
-  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
-);
-CREATE INDEX IF NOT EXISTS idx_cues_track ON cues(track_id);
-`)
-	return err
+type PgCueStore struct{ conn *pgxpool.Pool }
+
+func NewPgCueStore(pool *pgxpool.Pool) *PgCueStore {
+	return &PgCueStore{conn: pool}
 }
 
 func (s *PgCueStore) ListByTrack(ctx context.Context, trackID string) ([]CueRow, error) {
-	rows, err := s.conn.Query(ctx, `SELECT id, track_id, position_ms, color, label, type FROM cues WHERE track_id=$1 ORDER BY position_ms`, trackID)
+	rows, err := s.conn.Query(ctx, `SELECT `+cueColumns+` FROM cues WHERE track_id=$1 ORDER BY position_ms`, trackID)
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +52,7 @@ func (s *PgCueStore) ListByTrack(ctx context.Context, trackID string) ([]CueRow,
 	out := []CueRow{}
 	for rows.Next() {
 		var r CueRow
-		if err := rows.Scan(&r.ID, &r.TrackID, &r.PositionMs, &r.Color, &r.Label, &r.Type); err != nil {
+		if err := rows.Scan(scanCue(&r)...); err != nil {
 			return nil, err
 		}
 		out = append(out, r)
@@ -64,14 +60,83 @@ func (s *PgCueStore) ListByTrack(ctx context.Context, trackID string) ([]CueRow,
 	return out, rows.Err()
 }
 
-func (s *PgCueStore) Upsert(ctx context.Context, cue CueRow) error {
-	_, err := s.conn.Exec(ctx, `INSERT INTO cues (id, track_id, position_ms, color, label, type, created_at)
-VALUES ($1,$2,$3,$4,$5,$6,$7)
-ON CONFLICT (id) DO UPDATE SET position_ms=EXCLUDED.position_ms, color=EXCLUDED.color, label=EXCLUDED.label, type=EXCLUDED.type`,
-		cue.ID, cue.TrackID, cue.PositionMs, cue.Color, cue.Label, cue.Type, time.Now().UTC())
+// cueUpsertSQL never moves a cue between tracks: an id taken on another
+// track updates no row, which cueUpserted reports.
+const cueUpsertSQL = `INSERT INTO cues (id, track_id, position_ms, color, label, type, slot, autogen, created_at)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+ON CONFLICT (id) DO UPDATE SET position_ms=EXCLUDED.position_ms, color=EXCLUDED.color, label=EXCLUDED.label, type=EXCLUDED.type,
+    slot=EXCLUDED.slot, autogen=EXCLUDED.autogen, updated_at=now()
+WHERE cues.track_id = EXCLUDED.track_id`
+
+// cueUpserted turns the result of cueUpsertSQL into ErrOtherTrack when
+// it wrote nothing.
+func cueUpserted(tag pgconn.CommandTag, err error, id string) error {
+	if err == nil && tag.RowsAffected() == 0 {
+		return fmt.Errorf("cue %s %w", id, ErrOtherTrack)
+	}
 	return err
 }
 
+func cueUpsertArgs(cue CueRow, now time.Time) []any {
+	return []any{cue.ID, cue.TrackID, cue.PositionMs, cue.Color, cue.Label, cue.Type, cue.Slot, cue.Autogen, now}
+}
+
+func (s *PgCueStore) Upsert(ctx context.Context, cue CueRow) error {
+	tag, err := s.conn.Exec(ctx, cueUpsertSQL, cueUpsertArgs(cue, time.Now().UTC())...)
+	return cueUpserted(tag, err, cue.ID)
+}
+
+func (s *PgCueStore) UpsertMany(ctx context.Context, cues []CueRow) error {
+	return pgx.BeginFunc(ctx, s.conn, func(tx pgx.Tx) error {
+		return upsertCues(ctx, tx, cues)
+	})
+}
+
+func (s *PgCueStore) ReplaceTrack(ctx context.Context, trackID string, cues []CueRow) error {
+	return pgx.BeginFunc(ctx, s.conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM cues WHERE track_id=$1`, trackID); err != nil {
+			return err
+		}
+		return upsertCues(ctx, tx, cues)
+	})
+}
+
+func (s *PgCueStore) ReplaceAutogen(ctx context.Context, trackID string, cues []CueRow) error {
+	return pgx.BeginFunc(ctx, s.conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM cues WHERE track_id=$1 AND autogen`, trackID); err != nil {
+			return err
+		}
+		return upsertCues(ctx, tx, cues)
+	})
+}
+
+// upsertCues writes cues in tx. Their old slots are released first so a
+// batch may swap two pads without tripping the unique index midway.
+func upsertCues(ctx context.Context, tx pgx.Tx, cues []CueRow) error {
+	ids := make([]string, len(cues))
+	for i, c := range cues {
+		ids[i] = c.ID
+	}
+	batch := &pgx.Batch{}
+	batch.Queue(`UPDATE cues SET slot = NULL WHERE id = ANY($1) AND slot IS NOT NULL`, ids)
+	now := time.Now().UTC()
+	for _, c := range cues {
+		batch.Queue(cueUpsertSQL, cueUpsertArgs(c, now)...)
+	}
+	br := tx.SendBatch(ctx, batch)
+	defer br.Close()
+	if _, err := br.Exec(); err != nil {
+		return err
+	}
+	for _, c := range cues {
+		tag, err := br.Exec()
+		if err := cueUpserted(tag, err, c.ID); err != nil {
+			return err
+		}
+	}
+	return br.Close()
+}
+
 func (s *PgCueStore) Delete(ctx context.Context, id string) error {
 	_, err := s.conn.Exec(ctx, `DELETE FROM cues WHERE id=$1`, id)
 	return err
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// Dependencies records startup failures of external dependencies. With
+// allowDegraded unset a failure is fatal; otherwise it is logged and the
+// services depending on it answer 503.
+type Dependencies struct {
+	mu            sync.RWMutex
+	logger        *zap.Logger
+	allowDegraded bool
+	down          map[string]error
+	disabled      map[string]bool
+	services      map[string]serviceMount
+}
+
+type serviceMount struct {
+	needs []string
+	err   error // non-nil when mounted as a 503 catch-all
+}
+
+func NewDependencies(logger *zap.Logger, allowDegraded bool) *Dependencies {
+	return &Dependencies{
+		logger:        logger,
+		allowDegraded: allowDegraded,
+		down:          map[string]error{},
+		disabled:      map[string]bool{},
+		services:      map[string]serviceMount{},
+	}
+}
+
+// Fail handles a configured dependency that could not be initialized.
+func (d *Dependencies) Fail(name string, err error) {
+	if !d.allowDegraded {
+		d.logger.Fatal("dependency unavailable (set ALLOW_DEGRADED=true to serve without it)",
+			zap.String("dependency", name), zap.Error(err))
+	}
+	d.logger.Warn("degraded: dependency unavailable", zap.String("dependency", name), zap.Error(err))
+	d.mu.Lock()
+	d.down[name] = err
+	d.mu.Unlock()
+}
+
+// Disable marks an optional dependency that is not configured; never fatal.
+func (d *Dependencies) Disable(name string, err error) {
+	d.logger.Warn("degraded: dependency disabled", zap.String("dependency", name), zap.Error(err))
+	d.mu.Lock()
+	d.down[name] = err
+	d.disabled[name] = true
+	d.mu.Unlock()
+}
+
+// State reports the startup failure of a dependency and whether it was merely disabled.
+func (d *Dependencies) State(name string) (err error, disabled bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.down[name], d.disabled[name]
+}
+
+// Services returns a snapshot of every mounted service and what it needs.
+func (d *Dependencies) Services() map[string]serviceMount {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	out := make(map[string]serviceMount, len(d.services))
+	for k, v := range d.services {
+		out[k] = v
+	}
+	return out
+}
+
+// Unavailable returns the first failure among the named dependencies, or nil.
+func (d *Dependencies) Unavailable(names ...string) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, n := range names {
+		if err := d.down[n]; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mount registers a service's routes, or a 503 catch-all when one of its
+// dependencies is unavailable.
+func (d *Dependencies) Mount(r chi.Router, service string, needs []string, mount func(chi.Router)) {
+	err := d.Unavailable(needs...)
+	d.mu.Lock()
+	d.services[service] = serviceMount{needs: needs, err: err}
+	d.mu.Unlock()
+	if err == nil {
+		mount(r)
+		return
+	}
+	d.logger.Warn("service unavailable", zap.String("service", service), zap.Error(err))
+	h := func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, newProblem(http.StatusServiceUnavailable, CodeServiceUnavailable, service+" unavailable: "+err.Error()))
+	}
+	r.HandleFunc("/*", h)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Duplicate criteria.
+const (
+	dupByHash     = "hash"     // same content_hash
+	dupByMetadata = "metadata" // same normalized artists and title, durations within 2s
+	dupByAudio    = "audio"    // analysis agrees: key, BPM, loudness and duration
+)
+
+var dupCriteria = []string{dupByHash, dupByMetadata, dupByAudio}
+
+// dupCandidate is a track as a duplicate criterion sees it. Tracks group
+// when Key is equal and the measurements agree within dupTolerances.
+type dupCandidate struct {
+	ID         string
+	Key        string
+	DurationMs *int64
+	Bpm, Lufs  *float64
+}
+
+// dupTolerance bounds how far apart grouped measurements may be; zero
+// ignores a measurement.
+type dupTolerance struct {
+	DurationMs int64
+	Bpm, Lufs  float64
+}
+
+var dupTolerances = map[string]dupTolerance{
+	dupByMetadata: {DurationMs: 2000},
+	dupByAudio:    {DurationMs: 1000, Bpm: 0.5, Lufs: 1},
+}
+
+var nonAlnum = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// normalizeName folds case and punctuation, as the SQL of the metadata
+// criterion does: "Amelie Lens – Feel It!" becomes "amelie lens feel it".
+func normalizeName(s string) string {
+	return strings.TrimSpace(nonAlnum.ReplaceAllString(strings.ToLower(s), " "))
+}
+
+// metadataKey is the metadata criterion's key: artist names in name order,
+// then the title. It is "" for untitled tracks.
+func metadataKey(t *TrackRow) string {
+	if normalizeName(t.Title) == "" {
+		return ""
+	}
+	names := make([]string, len(t.Artists))
+	for i, a := range t.Artists {
+		names[i] = normalizeName(a.Name)
+	}
+	slices.Sort(names)
+	return strings.TrimSpace(strings.Join(names, " ")) + " / " + normalizeName(t.Title)
+}
+
+// clusterDuplicates groups candidates: equal keys, then single linkage over
+// the measurements, so a chain of near matches forms one group. Groups of
+// one are dropped; the result maps each group's ids to their key.
+func clusterDuplicates(cands []dupCandidate, tol dupTolerance) map[string][]string {
+	slices.SortFunc(cands, func(a, b dupCandidate) int {
+		if c := strings.Compare(a.Key, b.Key); c != 0 {
+			return c
+		}
+		return cmp.Compare(durationOr(a), durationOr(b))
+	})
+	parent := make([]int, len(cands))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range cands {
+		for j := i + 1; j < len(cands) && cands[j].Key == cands[i].Key; j++ {
+			if tol.DurationMs > 0 && durationOr(cands[j])-durationOr(cands[i]) > tol.DurationMs {
+				break
+			}
+			if closeEnough(cands[i].Bpm, cands[j].Bpm, tol.Bpm) && closeEnough(cands[i].Lufs, cands[j].Lufs, tol.Lufs) {
+				parent[root(j)] = root(i)
+			}
+		}
+	}
+	groups := map[int][]string{}
+	for i := range cands {
+		groups[root(i)] = append(groups[root(i)], cands[i].ID)
+	}
+	out := map[string][]string{}
+	for r, ids := range groups {
+		if len(ids) > 1 {
+			// Keys repeat when one key splits by duration; the first id tells them apart.
+			out[cands[r].Key+"\x00"+cands[r].ID] = ids
+		}
+	}
+	return out
+}
+
+// durationOr is the duration, -1 when unknown: unknown only matches unknown.
+func durationOr(c dupCandidate) int64 {
+	if c.DurationMs == nil {
+		return -1
+	}
+	return *c.DurationMs
+}
+
+func closeEnough(a, b *float64, tol float64) bool {
+	switch {
+	case tol == 0:
+		return true
+	case a == nil || b == nil:
+		return false
+	}
+	return math.Abs(*a-*b) <= tol
+}
+
+// findDuplicates is Duplicates for any store: candidates lists what one
+// criterion compares, load fetches the grouped tracks by id. Groups come
+// per criterion in the order of by, then by key.
+func findDuplicates(by []string, candidates func(by string) ([]dupCandidate, error), load func(ids []string) (map[string]TrackRow, error)) ([]DuplicateGroup, error) {
+	type found struct {
+		by, key string
+		ids     []string
+	}
+	var all []found
+	var ids []string
+	for _, b := range by {
+		cands, err := candidates(b)
+		if err != nil {
+			return nil, err
+		}
+		clusters := clusterDuplicates(cands, dupTolerances[b])
+		keys := make([]string, 0, len(clusters))
+		for k := range clusters {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			all = append(all, found{by: b, key: strings.SplitN(k, "\x00", 2)[0], ids: clusters[k]})
+			ids = append(ids, clusters[k]...)
+		}
+	}
+	tracks, err := load(ids)
+	if err != nil {
+		return nil, err
+	}
+	out := []DuplicateGroup{}
+	for _, f := range all {
+		g := DuplicateGroup{By: f.by, Key: f.key}
+		for _, id := range f.ids {
+			if t, ok := tracks[id]; ok {
+				g.Tracks = append(g.Tracks, t)
+			}
+		}
+		if len(g.Tracks) > 1 {
+			out = append(out, g)
+		}
+	}
+	return out, nil
+}
+
+// lossless codecs win survivor suggestions.
+var losslessCodecs = map[string]bool{"flac": true, "wav": true, "aiff": true, "aif": true, "alac": true}
+
+// suggestSurvivor picks the copy to keep: lossless first, then the higher
+// bit rate and sample rate, then the one added first.
+func suggestSurvivor(tracks []TrackRow) string {
+	best := slices.MinFunc(tracks, func(a, b TrackRow) int {
+		if la, lb := isLossless(a), isLossless(b); la != lb {
+			if la {
+				return -1
+			}
+			return 1
+		}
+		if c := cmp.Compare(intOr(b.BitRateKbps), intOr(a.BitRateKbps)); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(intOr(b.SampleRateHz), intOr(a.SampleRateHz)); c != 0 {
+			return c
+		}
+		return a.AddedAt.Compare(b.AddedAt)
+	})
+	return best.ID
+}
+
+func isLossless(t TrackRow) bool {
+	return t.Codec != nil && losslessCodecs[strings.ToLower(*t.Codec)]
+}
+
+func intOr(n *int) int {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Health runs dependency probes for /readyz and remembers each one's last error.
+// /livez only reports that the process is serving.
+type Health struct {
+	deps    *Dependencies
+	timeout time.Duration
+	maxAge  time.Duration
+
+	mu      sync.Mutex
+	checks  []healthCheck
+	results map[string]*CheckResult
+	last    time.Time
+}
+
+type healthCheck struct {
+	name     string
+	critical bool // a failing critical check makes /readyz answer 503
+	fn       func(ctx context.Context) error
+}
+
+type CheckResult struct {
+	Status      string     `json:"status"` // ok | down | disabled
+	Critical    bool       `json:"critical"`
+	LatencyMs   float64    `json:"latency_ms"`
+	CheckedAt   time.Time  `json:"checked_at"`
+	Error       string     `json:"error,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+type ServiceStatus struct {
+	Status string   `json:"status"` // ok | degraded | unavailable
+	Needs  []string `json:"needs"`
+	Reason string   `json:"reason,omitempty"`
+}
+
+type Readiness struct {
+	Status       string                   `json:"status"` // ok | degraded | down
+	Dependencies map[string]*CheckResult  `json:"dependencies"`
+	Services     map[string]ServiceStatus `json:"services"`
+}
+
+func NewHealth(deps *Dependencies) *Health {
+	return &Health{deps: deps, timeout: 2 * time.Second, maxAge: time.Second, results: map[string]*CheckResult{}}
+}
+
+// Register adds a probe. Dependencies disabled or failed at startup report that
+// state without running fn.
+func (h *Health) Register(name string, critical bool, fn func(ctx context.Context) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, healthCheck{name: name, critical: critical, fn: fn})
+	h.results[name] = &CheckResult{Status: "ok", Critical: critical}
+}
+
+// Check runs every probe concurrently, reusing results younger than maxAge so
+// frequent orchestrator polls do not hammer dependencies.
+func (h *Health) Check(ctx context.Context) Readiness {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if time.Since(h.last) >= h.maxAge {
+		h.run(ctx)
+		h.last = time.Now()
+	}
+	out := Readiness{Status: "ok", Dependencies: map[string]*CheckResult{}, Services: map[string]ServiceStatus{}}
+	for name, res := range h.results {
+		cp := *res
+		out.Dependencies[name] = &cp
+		if res.Status == "down" {
+			if res.Critical {
+				out.Status = "down"
+			} else if out.Status == "ok" {
+				out.Status = "degraded"
+			}
+		}
+	}
+	for name, sm := range h.deps.Services() {
+		st := ServiceStatus{Status: "ok", Needs: sm.needs}
+		if sm.err != nil {
+			st.Status, st.Reason = "unavailable", sm.err.Error()
+		} else {
+			for _, n := range sm.needs {
+				if res, ok := h.results[n]; ok && res.Status == "down" {
+					st.Status, st.Reason = "degraded", n+": "+res.Error
+					break
+				}
+			}
+		}
+		if st.Status != "ok" && out.Status == "ok" {
+			out.Status = "degraded"
+		}
+		out.Services[name] = st
+	}
+	return out
+}
+
+func (h *Health) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, c := range h.checks {
+		c := c
+		res := h.results[c.name]
+		if err, disabled := h.deps.State(c.name); err != nil {
+			at := time.Now().UTC()
+			res.CheckedAt, res.Error = at, err.Error()
+			if disabled {
+				res.Status = "disabled"
+				continue
+			}
+			res.Status = "down"
+			res.LastError, res.LastErrorAt = res.Error, &at
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, h.timeout)
+			defer cancel()
+			start := time.Now()
+			err := c.fn(cctx)
+			mu.Lock()
+			defer mu.Unlock()
+			res.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+			res.CheckedAt = time.Now().UTC()
+			if err != nil {
+				at := res.CheckedAt
+				res.Status, res.Error = "down", err.Error()
+				res.LastError, res.LastErrorAt = res.Error, &at
+			} else {
+				res.Status, res.Error = "ok", ""
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (h *Health) handleLivez(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
+}
+
+func (h *Health) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	rd := h.Check(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if rd.Status == "down" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(rd)
+}
@@ -1,26 +1,27 @@
 package main
 
 import (
-	"context"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
 
-type ImportService struct{ Store *PgTrackStore }
+type ImportService struct {
+	Store TrackStore
+	cfg   ImportConfig
+}
 
-func NewImportService(ctx context.Context, dsn string) (*ImportService, error) {
-	st, err := NewPgTrackStore(ctx, dsn)
-	if err != nil {
-		return nil, err
-	}
-	return &ImportService{Store: st}, nil
+func NewImportService(store TrackStore, cfg ImportConfig) *ImportService {
+	return &ImportService{Store: store, cfg: cfg}
 }
 
 func (s *ImportService) Routes(r chi.Router) {
@@ -33,6 +34,9 @@ type importScanReq struct {
 type importScanResp struct {
 	Scanned  int `json:"scanned"`
 	Imported int `json:"imported"`
+	// Hashed counts the files read for a content hash; the others were
+	// unchanged since the last scan.
+	Hashed int `json:"hashed"`
 }
 
 var audioExts = map[string]bool{".mp3": true, ".flac": true, ".wav": true, ".aiff": true, ".aif": true, ".m4a": true, ".ogg": true}
@@ -45,26 +49,70 @@ func baseTitle(path string) string {
 	return strings.TrimSuffix(name, ext)
 }
 
+// contentHash is the sha256 of a file's audio: leading ID3v2 and trailing
+// ID3v1 tags are skipped, so retagging a file keeps its hash.
+func contentHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	start, end := int64(0), info.Size()
+	var head [10]byte
+	if n, _ := io.ReadFull(f, head[:]); n == 10 && string(head[:3]) == "ID3" {
+		// Synchsafe size: 7 bits per byte, excluding the header and footer.
+		start = 10 + (int64(head[6])<<21 | int64(head[7])<<14 | int64(head[8])<<7 | int64(head[9]))
+		if head[5]&0x10 != 0 {
+			start += 10
+		}
+	}
+	if end-start >= 128 {
+		var tail [3]byte
+		if _, err := f.ReadAt(tail[:], end-128); err == nil && string(tail[:]) == "TAG" {
+			end -= 128
+		}
+	}
+	if start > end {
+		start = end
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, start, end-start)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 func (s *ImportService) handleScan(w http.ResponseWriter, r *http.Request) {
 	var req importScanReq
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Root) == "" {
-		http.Error(w, "bad json", http.StatusBadRequest)
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
 		return
 	}
-	// Optional host→container path remap via env
-	hostPrefix := os.Getenv("IMPORT_HOST_PREFIX")
-	containerPrefix := os.Getenv("IMPORT_CONTAINER_PREFIX")
-	root := req.Root
-	if hostPrefix != "" && containerPrefix != "" && strings.HasPrefix(root, hostPrefix) {
-		root = containerPrefix + strings.TrimPrefix(root, hostPrefix)
+	if strings.TrimSpace(req.Root) == "" {
+		writeProblem(w, r, validationProblem(FieldError{Field: "root", Code: "required", Message: "root is required"}))
+		return
 	}
+	// Optional host→container path remap
+	root := s.cfg.MapPath(req.Root)
 	info, err := os.Stat(root)
 	if err != nil || !info.IsDir() {
-		http.Error(w, "root not found or not a directory", http.StatusBadRequest)
+		importScans.WithLabelValues("invalid_root").Inc()
+		writeProblem(w, r, validationProblem(FieldError{Field: "root", Code: "not_a_directory", Message: "root not found or not a directory"}))
 		return
 	}
-	scanned := 0
-	imported := 0
+	// Hashing reads every byte; files whose size and mtime match the last
+	// scan keep their hash.
+	known, err := s.Store.FileStats(r.Context(), root)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	start := time.Now()
+	var resp importScanResp
 	_ = filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
 		if err != nil {
 			return nil
@@ -76,20 +124,33 @@ func (s *ImportService) handleScan(w http.ResponseWriter, r *http.Request) {
 		if !audioExts[ext] {
 			return nil
 		}
-		scanned++
-		id := sha1Hex(p)
-		title := baseTitle(p)
+		resp.Scanned++
+		info, err := d.Info()
+		if err != nil {
+			importFiles.WithLabelValues("failed").Inc()
+			return nil
+		}
+		// Postgres keeps microseconds.
+		f := ScannedFile{ID: sha1Hex(p), Title: baseTitle(p), Path: p,
+			FileStat: FileStat{Size: info.Size(), ModTime: info.ModTime().UTC().Truncate(time.Microsecond)}}
+		if prev, ok := known[p]; !ok || prev.Size != f.Size || !prev.ModTime.Equal(f.ModTime) {
+			if f.ContentHash, err = contentHash(p); err != nil {
+				importFiles.WithLabelValues("failed").Inc()
+				return nil
+			}
+			resp.Hashed++
+		}
 		// upsert minimal fields
-		_, execErr := s.Store.conn.Exec(r.Context(),
-			`INSERT INTO tracks(id, title, file_path) VALUES($1,$2,$3)
-             ON CONFLICT(id) DO UPDATE SET title=EXCLUDED.title, file_path=EXCLUDED.file_path`,
-			id, title, p,
-		)
-		if execErr == nil {
-			imported++
+		if err := s.Store.UpsertScanned(r.Context(), f); err == nil {
+			resp.Imported++
+			importFiles.WithLabelValues("imported").Inc()
+		} else {
+			importFiles.WithLabelValues("failed").Inc()
 		}
 		return nil
 	})
+	importScanDuration.Observe(time.Since(start).Seconds())
+	importScans.WithLabelValues("ok").Inc()
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(importScanResp{Scanned: scanned, Imported: imported})
+	json.NewEncoder(w).Encode(resp)
 }
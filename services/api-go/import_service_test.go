@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScanHashesChangedFilesOnly(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.mp3"), filepath.Join(dir, "b.flac")
+	for _, p := range []string{a, b, filepath.Join(dir, "notes.txt")} {
+		if err := os.WriteFile(p, []byte("audio "+p), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := NewMemTrackStore(NewMemCueStore(), NewMemLoopStore(), NewMemBeatgridStore())
+	svc := NewImportService(store, ImportConfig{})
+	scan := func() importScanResp {
+		t.Helper()
+		w := httptest.NewRecorder()
+		svc.handleScan(w, httptest.NewRequest(http.MethodPost, "/v1/import/scan", strings.NewReader(`{"root":"`+dir+`"}`)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("scan: %d %s", w.Code, w.Body)
+		}
+		var resp importScanResp
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if got := scan(); got != (importScanResp{Scanned: 2, Imported: 2, Hashed: 2}) {
+		t.Fatalf("first scan = %+v", got)
+	}
+	if got := scan(); got.Hashed != 0 {
+		t.Errorf("rescan hashed %d unchanged files", got.Hashed)
+	}
+
+	// Same size, new content and mtime: only the mtime tells.
+	if err := os.WriteFile(a, []byte("AUDIO "+a), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(a, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := scan(); got.Hashed != 1 {
+		t.Errorf("scan after touching a file hashed %d, want 1", got.Hashed)
+	}
+	track, err := store.Get(context.Background(), sha1Hex(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := contentHash(a); track.ContentHash == nil || *track.ContentHash != want {
+		t.Errorf("content hash not refreshed: %v", track.ContentHash)
+	}
+}
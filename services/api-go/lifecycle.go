@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Lifecycle owns the process root context, tracked background work and the
+// resources closed on shutdown. Request contexts derive from the root context
+// (see BaseContext), so cancelling it aborts in-flight ffmpeg runs and uploads.
+type Lifecycle struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	logger  *zap.Logger
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	closing bool // set once Shutdown waits for background work
+	closers []closer
+}
+
+// ErrShuttingDown is returned by Go once Shutdown has started waiting for
+// background work.
+var ErrShuttingDown = errors.New("shutting down")
+
+type closer struct {
+	name string
+	fn   func() error
+}
+
+func NewLifecycle(logger *zap.Logger) *Lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Lifecycle{ctx: ctx, cancel: cancel, logger: logger}
+}
+
+// Context is cancelled once the drain deadline has passed.
+func (l *Lifecycle) Context() context.Context { return l.ctx }
+
+// BaseContext plugs the root context into http.Server.
+func (l *Lifecycle) BaseContext(net.Listener) context.Context { return l.ctx }
+
+// Go runs fn as tracked background work; shutdown waits for it to return.
+// It refuses work with ErrShuttingDown once that wait has begun.
+func (l *Lifecycle) Go(name string, fn func(ctx context.Context)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closing {
+		return ErrShuttingDown
+	}
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		defer func() {
+			if rec := recover(); rec != nil {
+				l.logger.Error("background task panicked", zap.String("task", name), zap.Any("panic", rec))
+			}
+		}()
+		fn(l.ctx)
+	}()
+	return nil
+}
+
+// OnClose registers a resource to release after draining; closers run in reverse order.
+func (l *Lifecycle) OnClose(name string, fn func() error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closers = append(l.closers, closer{name: name, fn: fn})
+}
+
+// Shutdown stops accepting connections, drains in-flight handlers and
+// background work within timeout, then cancels whatever is left and closes
+// every registered resource.
+func (l *Lifecycle) Shutdown(srv *http.Server, timeout time.Duration) {
+	start := time.Now()
+	l.logger.Info("shutdown: draining", zap.Duration("timeout", timeout))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		l.logger.Warn("shutdown: drain deadline exceeded, cancelling in-flight requests", zap.Error(err))
+		l.cancel()
+		srv.Close()
+	} else {
+		l.logger.Info("shutdown: http server drained", zap.Duration("elapsed", time.Since(start)))
+	}
+
+	// Handlers left running after a failed drain may still start work;
+	// close the door first so none is added while waiting.
+	l.mu.Lock()
+	l.closing = true
+	l.mu.Unlock()
+	done := make(chan struct{})
+	go func() { l.wg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		l.logger.Warn("shutdown: cancelling background work")
+		l.cancel()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			l.logger.Warn("shutdown: background work did not stop")
+		}
+	}
+	l.cancel()
+
+	l.mu.Lock()
+	closers := l.closers
+	l.mu.Unlock()
+	for i := len(closers) - 1; i >= 0; i-- {
+		c := closers[i]
+		if err := c.fn(); err != nil && !errors.Is(err, context.Canceled) {
+			l.logger.Warn("shutdown: close failed", zap.String("resource", c.name), zap.Error(err))
+			continue
+		}
+		l.logger.Info("shutdown: closed", zap.String("resource", c.name))
+	}
+	l.logger.Info("shutdown: complete", zap.Duration("elapsed", time.Since(start)))
+}
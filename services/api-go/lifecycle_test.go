@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestGoAfterShutdown(t *testing.T) {
+	lc := NewLifecycle(zap.NewNop())
+	ran := make(chan struct{})
+	if err := lc.Go("before", func(context.Context) { close(ran) }); err != nil {
+		t.Fatalf("Go before shutdown = %v", err)
+	}
+	lc.Shutdown(&http.Server{}, time.Second)
+	<-ran
+
+	if err := lc.Go("after", func(context.Context) { t.Error("work started after shutdown") }); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Go after shutdown = %v, want ErrShuttingDown", err)
+	}
+	p, unexpected := problemFor(httptest.NewRequest(http.MethodPost, "/", nil), ErrShuttingDown)
+	if unexpected || p.Status != http.StatusServiceUnavailable || p.Code != CodeServiceUnavailable {
+		t.Errorf("problemFor(ErrShuttingDown) = %d %s, want 503 %s", p.Status, p.Code, CodeServiceUnavailable)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"meta-dj/services/api-go/cuecolor"
+)
+
+type LoopsService struct {
+	Store  LoopStore
+	Tracks TrackStore    // loops are checked against the track's duration
+	Grids  BeatgridStore // start_ms is snapped to it with ?quantize=
+}
+
+func NewLoopsService(store LoopStore, tracks TrackStore, grids BeatgridStore) *LoopsService {
+	return &LoopsService{Store: store, Tracks: tracks, Grids: grids}
+}
+
+func (s *LoopsService) Routes(r chi.Router) {
+	r.Get("/track/{trackId}", s.handleListByTrack)
+}
+
+func (s *LoopsService) ProtectedRoutes(r chi.Router) {
+	r.Put("/{id}", s.handleUpsert)
+	r.Delete("/{id}", s.handleDelete)
+}
+
+func (s *LoopsService) handleListByTrack(w http.ResponseWriter, r *http.Request) {
+	tid := chi.URLParam(r, "trackId")
+	rows, err := s.Store.ListByTrack(r.Context(), tid)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rows)
+}
+
+// handleUpsert saves a loop whose length is given in beats (needs a
+// beatgrid to play), milliseconds, or both. Like cue writes, it makes the
+// loop the user's and checks it against the track.
+func (s *LoopsService) handleUpsert(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var body LoopRow
+	if err := decodeJSON(r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if body.LengthBeats == nil && body.LengthMs == nil {
+		writeError(w, r, validationProblem(FieldError{Field: "length_beats", Code: "required", Message: "length_beats or length_ms is required"}))
+		return
+	}
+	body.ID = id
+	body.Autogen = false // a loop written here is the user's, even if autocue placed it
+	track, err := s.Tracks.Get(r.Context(), body.TrackID)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, r, newProblem(http.StatusUnprocessableEntity, CodeUnprocessable, "track "+body.TrackID+" does not exist"))
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+	q, err := newQuantizer(r, s.Grids)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if body.StartMs, err = q.snap(r.Context(), body.TrackID, body.StartMs); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	errs, err := s.checkLoop(r.Context(), &body, track)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(errs) > 0 {
+		writeError(w, r, validationProblem(errs...))
+		return
+	}
+	if err := s.Store.Upsert(r.Context(), body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkLoop normalizes loop's color to the cuecolor palette and reports
+// a loop that starts or ends outside track. A length in beats only is
+// measured on the track's beatgrid, and goes unchecked without one.
+func (s *LoopsService) checkLoop(ctx context.Context, loop *LoopRow, track *TrackRow) ([]FieldError, error) {
+	var errs []FieldError
+	if loop.Color != nil {
+		if c, ok := cuecolor.Normalize(*loop.Color); ok {
+			loop.Color = &c.Hex
+		} else {
+			errs = append(errs, FieldError{Field: "color", Code: "invalid_format", Message: `color must be "#RRGGBB" or a palette name`})
+		}
+	}
+	if loop.LengthBeats != nil && *loop.LengthBeats < 1 {
+		errs = append(errs, FieldError{Field: "length_beats", Code: "out_of_range", Message: "length_beats must be positive"})
+	}
+	if loop.LengthMs != nil && *loop.LengthMs < 1 {
+		errs = append(errs, FieldError{Field: "length_ms", Code: "out_of_range", Message: "length_ms must be positive"})
+	}
+	switch {
+	case loop.StartMs < 0:
+		errs = append(errs, FieldError{Field: "start_ms", Code: "out_of_range", Message: "start_ms must not be negative"})
+	case track.DurationMs == nil || len(errs) > 0:
+	case loop.StartMs >= *track.DurationMs:
+		errs = append(errs, FieldError{Field: "start_ms", Code: "out_of_range", Message: fmt.Sprintf("start_ms is at or past the end of the track (%d ms)", *track.DurationMs)})
+	default:
+		field, end := "length_ms", int64(-1)
+		if loop.LengthMs != nil {
+			end = loop.StartMs + *loop.LengthMs
+		} else {
+			grid, err := s.Grids.Get(ctx, track.ID)
+			switch {
+			case errors.Is(err, ErrNotFound):
+			case err != nil:
+				return nil, err
+			default:
+				g := grid.Grid
+				field, end = "length_beats", int64(math.Round(g.TimeOf(g.BeatAt(float64(loop.StartMs))+float64(*loop.LengthBeats))))
+			}
+		}
+		if end > *track.DurationMs {
+			errs = append(errs, FieldError{Field: field, Code: "out_of_range", Message: fmt.Sprintf("the loop ends past the end of the track (%d ms)", *track.DurationMs)})
+		}
+	}
+	return errs, nil
+}
+
+func (s *LoopsService) handleDelete(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if err := s.Store.Delete(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
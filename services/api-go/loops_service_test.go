@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"meta-dj/services/api-go/beatgrid"
+	"meta-dj/services/api-go/cuecolor"
+)
+
+func TestUpsertLoop(t *testing.T) {
+	ctx := context.Background()
+	loops, grids := NewMemLoopStore(), NewMemBeatgridStore()
+	tracks := NewMemTrackStore(NewMemCueStore(), loops, grids)
+	duration := int64(60000)
+	for _, id := range []string{"gridded", "plain"} {
+		if _, err := tracks.Create(ctx, TrackRow{ID: id, TrackFields: TrackFields{Title: id, FilePath: "/music/" + id, DurationMs: &duration}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 120 BPM: a beat every 500ms.
+	if _, err := grids.Put(ctx, "gridded", beatgrid.Grid{BeatsPerBar: 4, Markers: []beatgrid.Marker{{PositionMs: 0, Bpm: 120}}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	svc := NewLoopsService(loops, tracks, grids)
+	r := chi.NewRouter()
+	r.Route("/v1/loops", svc.ProtectedRoutes)
+	put := func(id, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/v1/loops/"+id, strings.NewReader(body)))
+		return w
+	}
+
+	for i, tc := range []struct {
+		body   string
+		status int
+		field  string
+	}{
+		{`{"track_id":"missing","start_ms":0,"length_ms":1000}`, http.StatusUnprocessableEntity, ""},
+		{`{"track_id":"plain","start_ms":60000,"length_ms":1000}`, http.StatusBadRequest, "start_ms"},
+		{`{"track_id":"plain","start_ms":59500,"length_ms":1000}`, http.StatusBadRequest, "length_ms"},
+		{`{"track_id":"plain","start_ms":59000,"length_ms":1000}`, http.StatusNoContent, ""},
+		{`{"track_id":"plain","start_ms":59000,"length_beats":64}`, http.StatusNoContent, ""}, // no grid to measure on
+		{`{"track_id":"gridded","start_ms":58000,"length_beats":8}`, http.StatusBadRequest, "length_beats"},
+		{`{"track_id":"gridded","start_ms":56000,"length_beats":8}`, http.StatusNoContent, ""},
+		{`{"track_id":"plain","start_ms":0,"length_ms":1000,"color":"chartreuse"}`, http.StatusBadRequest, "color"},
+	} {
+		w := put(fmt.Sprintf("l%d", i), tc.body)
+		if w.Code != tc.status {
+			t.Errorf("PUT %s = %d %s, want %d", tc.body, w.Code, w.Body, tc.status)
+			continue
+		}
+		if tc.field != "" {
+			var p Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || len(p.Errors) != 1 || p.Errors[0].Field != tc.field {
+				t.Errorf("PUT %s = %s, want an error on %s", tc.body, w.Body, tc.field)
+			}
+		}
+	}
+
+	if w := put("gridded-loop", `{"track_id":"gridded","start_ms":0,"length_ms":1000}`); w.Code != http.StatusNoContent {
+		t.Fatalf("PUT = %d %s", w.Code, w.Body)
+	}
+	if w := put("gridded-loop", `{"track_id":"plain","start_ms":0,"length_ms":1000}`); w.Code != http.StatusConflict {
+		t.Errorf("PUT of another track's loop id = %d %s, want 409", w.Code, w.Body)
+	}
+
+	if w := put("red", `{"track_id":"plain","start_ms":1000,"length_ms":4000,"color":"red","autogen":true}`); w.Code != http.StatusNoContent {
+		t.Fatalf("PUT = %d %s", w.Code, w.Body)
+	}
+	all, err := loops.ListByTrack(ctx, "plain")
+	i := slices.IndexFunc(all, func(l LoopRow) bool { return l.ID == "red" })
+	if err != nil || i < 0 {
+		t.Fatalf("ListByTrack = %v, %v", all, err)
+	}
+	got := all[i]
+	red, _ := cuecolor.Normalize("red")
+	if got.Autogen || got.Color == nil || *got.Color != red.Hex {
+		t.Errorf("stored loop = %+v, want autogen cleared and color %s", got, red.Hex)
+	}
+}
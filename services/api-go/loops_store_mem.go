@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+)
+
+type MemLoopStore struct {
+	mu    sync.RWMutex
+	loops map[string]LoopRow
+}
+
+func NewMemLoopStore() *MemLoopStore {
+	return &MemLoopStore{loops: map[string]LoopRow{}}
+}
+
+func (s *MemLoopStore) ListByTrack(ctx context.Context, trackID string) ([]LoopRow, error) {
+	s.mu.RLock()
+	out := []LoopRow{}
+	for _, l := range s.loops {
+		if l.TrackID == trackID {
+			out = append(out, l)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].StartMs < out[j].StartMs })
+	return out, nil
+}
+
+func (s *MemLoopStore) Upsert(ctx context.Context, loop LoopRow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.loops[loop.ID]; ok && prev.TrackID != loop.TrackID {
+		// Like the Postgres upsert, a loop never moves between tracks.
+		return fmt.Errorf("loop %s %w", loop.ID, ErrOtherTrack)
+	}
+	s.loops[loop.ID] = loop
+	return nil
+}
+
+func (s *MemLoopStore) ReplaceAutogen(ctx context.Context, trackID string, loops []LoopRow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range loops {
+		if prev, ok := s.loops[l.ID]; ok && prev.TrackID != l.TrackID {
+			return fmt.Errorf("loop %s %w", l.ID, ErrOtherTrack)
+		}
+	}
+	maps.DeleteFunc(s.loops, func(_ string, l LoopRow) bool { return l.TrackID == trackID && l.Autogen })
+	for _, l := range loops {
+		s.loops[l.ID] = l
+	}
+	return nil
+}
+
+func (s *MemLoopStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.loops, id)
+	return nil
+}
+
+// dropTrack deletes the loops of a deleted track.
+func (s *MemLoopStore) dropTrack(trackID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	maps.DeleteFunc(s.loops, func(_ string, l LoopRow) bool { return l.TrackID == trackID })
+}
+
+// moveTracks hands the loops of tracks from to track to, for merges.
+func (s *MemLoopStore) moveTracks(to string, from []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, l := range s.loops {
+		if slices.Contains(from, l.TrackID) {
+			l.TrackID = to
+			s.loops[id] = l
+		}
+	}
+}
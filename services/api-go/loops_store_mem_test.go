@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemLoopReplaceAutogenKeepsOtherTracks(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemLoopStore()
+	length := int64(4000)
+	if err := store.Upsert(ctx, LoopRow{ID: "l1", TrackID: "a", LengthMs: &length}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Upsert(ctx, LoopRow{ID: "auto", TrackID: "b", LengthMs: &length, Autogen: true}); err != nil {
+		t.Fatal(err)
+	}
+	err := store.ReplaceAutogen(ctx, "b", []LoopRow{{ID: "l1", TrackID: "b", LengthMs: &length, Autogen: true}})
+	if !errors.Is(err, ErrOtherTrack) {
+		t.Fatalf("ReplaceAutogen over another track's loop = %v, want ErrOtherTrack", err)
+	}
+	if a, _ := store.ListByTrack(ctx, "a"); len(a) != 1 || a[0].Autogen {
+		t.Errorf("track a loops = %+v, want l1 untouched", a)
+	}
+	if b, _ := store.ListByTrack(ctx, "b"); len(b) != 1 || b[0].ID != "auto" {
+		t.Errorf("track b loops = %+v, want the failed replace to change nothing", b)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// LoopRow is a saved loop. Its length is in beats, milliseconds or both;
+// at least one is set.
+type LoopRow struct {
+	ID          string  `json:"id"`
+	TrackID     string  `json:"track_id"`
+	StartMs     int64   `json:"start_ms"`
+	LengthBeats *int    `json:"length_beats,omitempty"`
+	LengthMs    *int64  `json:"length_ms,omitempty"`
+	Color       *string `json:"color,omitempty"`
+	Label       *string `json:"label,omitempty"`
+	Autogen     bool    `json:"autogen"`
+}
+
+const loopColumns = `id, track_id, start_ms, length_beats, length_ms, color, label, autogen`
+
+func scanLoop(l *LoopRow) []any {
+	return []any{&l.ID, &l.TrackID, &l.StartMs, &l.LengthBeats, &l.LengthMs, &l.Color, &l.Label, &l.Autogen}
+}
+
+type PgLoopStore struct{ conn *pgxpool.Pool }
+
+func NewPgLoopStore(pool *pgxpool.Pool) *PgLoopStore {
+	return &PgLoopStore{conn: pool}
+}
+
+func (s *PgLoopStore) ListByTrack(ctx context.Context, trackID string) ([]LoopRow, error) {
+	rows, err := s.conn.Query(ctx, `SELECT `+loopColumns+` FROM loops WHERE track_id=$1 ORDER BY start_ms`, trackID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []LoopRow{}
+	for rows.Next() {
+		var l LoopRow
+		if err := rows.Scan(scanLoop(&l)...); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+const loopUpsertSQL = `INSERT INTO loops (id, track_id, start_ms, length_beats, length_ms, color, label, autogen)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+ON CONFLICT (id) DO UPDATE SET start_ms=EXCLUDED.start_ms, length_beats=EXCLUDED.length_beats, length_ms=EXCLUDED.length_ms,
+    color=EXCLUDED.color, label=EXCLUDED.label, autogen=EXCLUDED.autogen, updated_at=now()
+WHERE loops.track_id = EXCLUDED.track_id`
+
+func loopUpsertArgs(l LoopRow) []any {
+	return []any{l.ID, l.TrackID, l.StartMs, l.LengthBeats, l.LengthMs, l.Color, l.Label, l.Autogen}
+}
+
+func (s *PgLoopStore) Upsert(ctx context.Context, loop LoopRow) error {
+	tag, err := s.conn.Exec(ctx, loopUpsertSQL, loopUpsertArgs(loop)...)
+	if err == nil && tag.RowsAffected() == 0 {
+		// The id is taken by a loop on another track.
+		return fmt.Errorf("loop %s %w", loop.ID, ErrOtherTrack)
+	}
+	return err
+}
+
+func (s *PgLoopStore) ReplaceAutogen(ctx context.Context, trackID string, loops []LoopRow) error {
+	return pgx.BeginFunc(ctx, s.conn, func(tx pgx.Tx) error {
+		batch := &pgx.Batch{}
+		batch.Queue(`DELETE FROM loops WHERE track_id=$1 AND autogen`, trackID)
+		for _, l := range loops {
+			batch.Queue(loopUpsertSQL, loopUpsertArgs(l)...)
+		}
+		return tx.SendBatch(ctx, batch).Close()
+	})
+}
+
+func (s *PgLoopStore) Delete(ctx context.Context, id string) error {
+	_, err := s.conn.Exec(ctx, `DELETE FROM loops WHERE id=$1`, id)
+	return err
+}
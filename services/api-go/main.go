@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -15,12 +20,47 @@ import (
 func main() {
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
+	zap.ReplaceGlobals(logger)
+
+	cfg, opts, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		logger.Fatal("config", zap.Error(err))
+	}
+	if opts.PrintConfig {
+		if err := cfg.PrintRedacted(os.Stdout); err != nil {
+			logger.Fatal("print config", zap.Error(err))
+		}
+		return
+	}
+
+	// `api migrate up|down|status` manages the Postgres schema and exits.
+	if len(opts.Args) > 0 {
+		if opts.Args[0] != "migrate" {
+			logger.Fatal("unknown command", zap.String("command", opts.Args[0]))
+		}
+		if err := runMigrate(context.Background(), cfg.Database.URL, opts.Args[1:], os.Stdout); err != nil {
+			logger.Fatal("migrate failed", zap.Error(err))
+		}
+		return
+	}
+
+	shutdownTracing, err := SetupTracing(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Fatal("tracing", zap.Error(err))
+	}
+
+	spec, err := LoadAPISpec(context.Background())
+	if err != nil {
+		logger.Fatal("openapi", zap.Error(err))
+	}
 
 	r := chi.NewRouter()
+	r.NotFound(handleNotFound)
+	r.MethodNotAllowed(handleMethodNotAllowed)
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
+	r.Use(observeHTTP(logger))
+	r.Use(recoverProblem)
 
 	// Dev CORS (allow localhost)
 	r.Use(func(next http.Handler) http.Handler {
@@ -28,6 +68,7 @@ func main() {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, Link, Location, "+suggestionsHeader)
 			if req.Method == http.MethodOptions {
 				w.WriteHeader(http.StatusNoContent)
 				return
@@ -35,159 +76,228 @@ func main() {
 			next.ServeHTTP(w, req)
 		})
 	})
+	// Reject requests that do not match openapi.yaml before they reach a handler.
+	r.Use(spec.Validate)
 
 	r.Get("/health", func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("ok"))
 	})
 
-	// Optional: JWT auth middleware can be added here in future.
-
-	// Choose store: Postgres if available, else in-memory
-	var getHandler http.HandlerFunc = handleGetChanges
-	var postHandler http.HandlerFunc = handlePostChanges
-	var tracksSvc *TracksService
-	var changesSvc *ChangesService
-	var cuesSvc *CuesService
-	var importSvc *ImportService
-	var storageSvc *StorageService
-	var analysisSvc *AnalysisService
-	if dsn := os.Getenv("DATABASE_URL"); dsn != "" {
-		if pgStore, err := NewPgChangeStore(context.Background(), dsn); err == nil {
-			getHandler = func(w http.ResponseWriter, r *http.Request) {
-				since := r.URL.Query().Get("since")
-				items, err := pgStore.Since(r.Context(), since)
-				if err != nil {
-					http.Error(w, "error", http.StatusInternalServerError)
-					return
-				}
-				w.Header().Set("Content-Type", "application/json")
-				json.NewEncoder(w).Encode(items)
-			}
-			postHandler = func(w http.ResponseWriter, r *http.Request) {
-				var payload []Change
-				if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-					http.Error(w, "invalid json", http.StatusBadRequest)
-					return
-				}
-				n, err := pgStore.Append(r.Context(), payload)
-				if err != nil {
-					http.Error(w, "error", http.StatusInternalServerError)
-					return
-				}
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusAccepted)
-				json.NewEncoder(w).Encode(map[string]any{"status": "ok", "received": n})
-			}
-		}
-		if tsvc, err := NewTracksService(context.Background(), dsn); err == nil {
-			tracksSvc = tsvc
+	// Dependencies: a configured dependency that fails is fatal unless
+	// allow_degraded is set; an unconfigured optional one is disabled.
+	deps := NewDependencies(logger, cfg.AllowDegraded)
+	lc := NewLifecycle(logger)
+	// Registered first so it closes last and exports the shutdown spans.
+	lc.OnClose("tracing", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return shutdownTracing(ctx)
+	})
+	health := NewHealth(deps)
+	r.Get("/livez", health.handleLivez)
+	r.Get("/readyz", health.handleReadyz)
+	r.Get("/v1/openapi.json", spec.handleJSON)
+	r.Handle("/metrics", metricsHandler())
+
+	backend := cfg.BackendName()
+	var trackStore TrackStore
+	var cueStore CueStore
+	var loopStore LoopStore
+	var gridStore BeatgridStore
+	var changeStore ChangeStore
+	var dbStats http.HandlerFunc // Postgres only
+	dbCheck := func(context.Context) error { return nil }
+	switch backend {
+	case "postgres":
+		// One pool shared by every Postgres store.
+		pool, err := NewPool(context.Background(), cfg.Database.URL, cfg.Database.Pool())
+		if err != nil {
+			deps.Fail("database", fmt.Errorf("connect: %w", err))
+			break
 		}
-		if csvc, err := NewChangesService(context.Background(), dsn); err == nil {
-			changesSvc = csvc
+		lc.OnClose("database", func() error { pool.Close(); return nil })
+		// Refuse to serve against an outdated schema unless auto_migrate is set.
+		applied, err := ensureSchema(context.Background(), pool, cfg.Database.AutoMigrate)
+		if err != nil {
+			deps.Fail("database", fmt.Errorf("schema: %w", err))
+			break
 		}
-		if cusvc, err := NewCuesService(context.Background(), dsn); err == nil {
-			cuesSvc = cusvc
+		for _, v := range applied {
+			logger.Info("applied migration", zap.String("version", v))
 		}
-		if isvc, err := NewImportService(context.Background(), dsn); err == nil {
-			importSvc = isvc
+		dbStats = poolStatsHandler(pool)
+		metricsRegistry.MustRegister(newPoolCollector(pool))
+		dbCheck = pool.Ping
+		trackStore = NewPgTrackStore(pool)
+		cueStore = NewPgCueStore(pool)
+		loopStore = NewPgLoopStore(pool)
+		gridStore = NewPgBeatgridStore(pool)
+		changeStore = NewPgChangeStore(pool)
+	case "memory":
+		cues, loops, grids := NewMemCueStore(), NewMemLoopStore(), NewMemBeatgridStore()
+		trackStore = NewMemTrackStore(cues, loops, grids)
+		cueStore, loopStore, gridStore = cues, loops, grids
+		changeStore = NewMemChangeStore()
+	}
+	logger.Info("store backend", zap.String("backend", backend))
+	health.Register("database", true, dbCheck)
+
+	if err := cfg.Storage.Check(); err != nil {
+		deps.Fail("storage", err)
+	} else if !cfg.Storage.Configured() {
+		deps.Disable("storage", errors.New("storage not configured (SUPABASE_URL, SUPABASE_SERVICE_ROLE_KEY)"))
+	}
+	if _, err := exec.LookPath(cfg.Analysis.FFmpegPath); err != nil {
+		if cfg.Analysis.FFmpegPath == defaultConfig().Analysis.FFmpegPath {
+			deps.Disable("ffmpeg", err)
+		} else {
+			deps.Fail("ffmpeg", err)
 		}
-		storageSvc = NewStorageService()
-		if asvc, err := NewAnalysisService(context.Background(), dsn); err == nil {
-			analysisSvc = asvc
+	}
+	health.Register("ffmpeg", false, func(context.Context) error {
+		_, err := exec.LookPath(cfg.Analysis.FFmpegPath)
+		return err
+	})
+	var maybeJWT func(http.Handler) http.Handler
+	auth, err := NewAuth(cfg.Auth)
+	if err != nil {
+		deps.Fail("jwks", err)
+		// Fail closed: protected routes stay unreachable without a verifier.
+		maybeJWT = func(http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writeProblem(w, r, newProblem(http.StatusServiceUnavailable, CodeServiceUnavailable, "auth unavailable"))
+			})
 		}
+	} else {
+		maybeJWT = auth.Middleware
+		lc.OnClose("jwks", auth.Close)
+	}
+	if cfg.Server.Debug && dbStats != nil {
+		r.With(maybeJWT).Get("/debug/db/stats", dbStats)
+	}
+	if cfg.Auth.JWKSURL != "" {
+		health.Register("jwks", false, func(ctx context.Context) error { return auth.Check(ctx) })
 	}
+	if !cfg.Auth.Enabled() {
+		logger.Warn("auth disabled: protected routes are open (set SUPABASE_JWKS_URL or JWT_SECRET)")
+	}
+
+	blobs := NewSupabaseStorage(cfg.Storage)
+	health.Register("storage", false, blobs.Ping)
+	tracksSvc := NewTracksService(trackStore, gridStore, cfg.Search, cfg.Mixing, cfg.Keys)
+	changesSvc := NewChangesService(changeStore)
+	cuesSvc := NewCuesService(cueStore, trackStore, gridStore)
+	loopsSvc := NewLoopsService(loopStore, trackStore, gridStore)
+	importSvc := NewImportService(trackStore, cfg.Import)
+	storageSvc := NewStorageService(blobs)
+	analysisSvc := NewAnalysisService(trackStore, cueStore, loopStore, gridStore, blobs, lc, cfg.Analysis, cfg.Import)
 
 	r.Route("/v1/sync", func(sr chi.Router) {
-		if changesSvc != nil {
+		deps.Mount(sr, "sync", []string{"database"}, func(sr chi.Router) {
 			changesSvc.Routes(sr)
-		} else {
-			sr.Get("/changes", getHandler)
-			sr.Post("/changes", postHandler)
-		}
-		// Protected subset under the same base path
-		sr.Group(func(gr chi.Router) {
-			gr.Use(maybeJWT)
-			if changesSvc != nil {
+			// Protected subset under the same base path
+			sr.Group(func(gr chi.Router) {
+				gr.Use(maybeJWT)
 				changesSvc.ProtectedRoutes(gr)
-			}
+			})
 		})
 	})
 
 	r.Route("/v1/tracks", func(tr chi.Router) {
-		if tracksSvc != nil {
+		deps.Mount(tr, "tracks", []string{"database"}, func(tr chi.Router) {
 			tracksSvc.Routes(tr)
-		}
-		tr.Group(func(gr chi.Router) {
-			gr.Use(maybeJWT)
-			if tracksSvc != nil {
+			tr.Group(func(gr chi.Router) {
+				gr.Use(maybeJWT)
 				tracksSvc.ProtectedRoutes(gr)
-			}
+			})
 		})
 	})
 
 	r.Route("/v1/cues", func(cr chi.Router) {
-		if cuesSvc != nil {
+		deps.Mount(cr, "cues", []string{"database"}, func(cr chi.Router) {
 			cuesSvc.Routes(cr)
-		}
-		cr.Group(func(gr chi.Router) {
-			gr.Use(maybeJWT)
-			if cuesSvc != nil {
+			cr.Group(func(gr chi.Router) {
+				gr.Use(maybeJWT)
 				cuesSvc.ProtectedRoutes(gr)
-			}
+			})
+		})
+	})
+
+	r.Route("/v1/loops", func(lr chi.Router) {
+		deps.Mount(lr, "loops", []string{"database"}, func(lr chi.Router) {
+			loopsSvc.Routes(lr)
+			lr.Group(func(gr chi.Router) {
+				gr.Use(maybeJWT)
+				loopsSvc.ProtectedRoutes(gr)
+			})
 		})
 	})
 
 	r.Route("/v1/import", func(ir chi.Router) {
-		if importSvc != nil {
+		deps.Mount(ir, "import", []string{"database"}, func(ir chi.Router) {
 			importSvc.Routes(ir)
-		}
-		// No protected import endpoints currently
+			// No protected import endpoints currently
+		})
 	})
 
 	// Storage protected routes under its own base path
 	r.Route("/v1/storage", func(sr chi.Router) {
-		sr.Group(func(gr chi.Router) {
-			gr.Use(maybeJWT)
-			if storageSvc != nil {
+		deps.Mount(sr, "storage", []string{"storage"}, func(sr chi.Router) {
+			sr.Group(func(gr chi.Router) {
+				gr.Use(maybeJWT)
 				storageSvc.ProtectedRoutes(gr)
-			}
+			})
 		})
 	})
 
 	// Analysis protected routes
 	r.Route("/v1/analysis", func(ar chi.Router) {
-		ar.Group(func(gr chi.Router) {
-			gr.Use(maybeJWT)
-			if analysisSvc != nil {
+		ar.Route("/autocue", func(acr chi.Router) {
+			deps.Mount(acr, "autocue", []string{"database", "ffmpeg"}, func(acr chi.Router) {
+				acr.Group(func(gr chi.Router) {
+					gr.Use(maybeJWT)
+					analysisSvc.AutocueRoutes(gr)
+				})
+			})
+		})
+		deps.Mount(ar, "analysis", []string{"database", "storage", "ffmpeg"}, func(ar chi.Router) {
+			ar.Group(func(gr chi.Router) {
+				gr.Use(maybeJWT)
 				analysisSvc.ProtectedRoutes(gr)
-			}
+			})
 		})
 	})
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// The spec must describe every mounted route.
+	if missing := spec.Undocumented(r); len(missing) > 0 {
+		logger.Fatal("openapi: routes missing from openapi.yaml", zap.Strings("routes", missing))
 	}
+
 	srv := &http.Server{
-		Addr:         ":" + port,
+		Addr:         ":" + strconv.Itoa(cfg.Server.Port),
 		Handler:      r,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+		BaseContext:  lc.BaseContext,
 	}
+	serveErr := make(chan error, 1)
 	go func() {
 		logger.Info("api listening", zap.String("addr", srv.Addr))
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Fatal("server error", zap.Error(err))
+			serveErr <- err
 		}
 	}()
 
-	// Graceful shutdown on SIGTERM
-	sig := make(chan os.Signal, 1)
-	// signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM) // add when importing syscall
-	<-sig
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	srv.Shutdown(ctx)
+	// Graceful shutdown on SIGINT/SIGTERM; a second signal kills the process.
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-sigCtx.Done():
+		logger.Info("shutdown: signal received")
+	case err := <-serveErr:
+		logger.Error("server error", zap.Error(err))
+	}
+	stop()
+	lc.Shutdown(srv, cfg.Server.ShutdownTimeout)
 }
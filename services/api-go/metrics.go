@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics, exposed at /metrics. Everything registers on
+// metricsRegistry rather than the global default registry.
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	factory = promauto.With(metricsRegistry)
+
+	httpRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "metadj",
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by chi route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+	httpInFlight = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "metadj",
+		Name:      "http_requests_in_flight",
+		Help:      "Requests currently being served.",
+	})
+
+	storageRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "metadj",
+		Name:      "storage_requests_total",
+		Help:      "Supabase Storage calls by operation and result.",
+	}, []string{"op", "result"})
+	storageDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "metadj",
+		Name:      "storage_request_duration_seconds",
+		Help:      "Supabase Storage call latency by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"op"})
+
+	ffmpegDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "metadj",
+		Name:      "ffmpeg_run_duration_seconds",
+		Help:      "ffmpeg subprocess wall time by job and result.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"job", "result"})
+	ffmpegFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "metadj",
+		Name:      "ffmpeg_failures_total",
+		Help:      "ffmpeg runs that exited with an error, by job.",
+	}, []string{"job"})
+
+	importScans = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "metadj",
+		Name:      "import_scans_total",
+		Help:      "Import scans by result.",
+	}, []string{"result"})
+	importFiles = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "metadj",
+		Name:      "import_files_total",
+		Help:      "Audio files seen by import scans, by outcome (imported, failed).",
+	}, []string{"outcome"})
+	importScanDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "metadj",
+		Name:      "import_scan_duration_seconds",
+		Help:      "Import scan wall time.",
+		Buckets:   []float64{0.01, 0.1, 0.5, 1, 5, 15, 60, 300},
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{Registry: metricsRegistry})
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// observeStorage records one Supabase Storage call; use as
+// defer observeStorage("upload", time.Now(), &err).
+func observeStorage(op string, start time.Time, err *error) {
+	storageDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	storageRequests.WithLabelValues(op, resultLabel(*err)).Inc()
+}
+
+// observeFFmpeg records one ffmpeg run.
+func observeFFmpeg(job string, start time.Time, err error) {
+	ffmpegDuration.WithLabelValues(job, resultLabel(err)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		ffmpegFailures.WithLabelValues(job).Inc()
+	}
+}
+
+// poolCollector exports pgxpool statistics at scrape time.
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	maxConns, totalConns, acquiredConns, idleConns, constructingConns *prometheus.Desc
+	acquires, acquireSeconds, emptyAcquires, canceledAcquires         *prometheus.Desc
+	newConns, lifetimeDestroys, idleDestroys                          *prometheus.Desc
+}
+
+func newPoolCollector(pool *pgxpool.Pool) *poolCollector {
+	d := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc("metadj_db_pool_"+name, help, nil, nil)
+	}
+	return &poolCollector{
+		pool:              pool,
+		maxConns:          d("max_conns", "Maximum pool size."),
+		totalConns:        d("total_conns", "Open connections."),
+		acquiredConns:     d("acquired_conns", "Connections currently checked out."),
+		idleConns:         d("idle_conns", "Idle connections."),
+		constructingConns: d("constructing_conns", "Connections being established."),
+		acquires:          d("acquires_total", "Successful acquires."),
+		acquireSeconds:    d("acquire_duration_seconds_total", "Total time spent acquiring connections."),
+		emptyAcquires:     d("empty_acquires_total", "Acquires that had to wait for a connection."),
+		canceledAcquires:  d("canceled_acquires_total", "Acquires cancelled by their context."),
+		newConns:          d("new_conns_total", "Connections opened."),
+		lifetimeDestroys:  d("max_lifetime_destroys_total", "Connections closed for exceeding max lifetime."),
+		idleDestroys:      d("max_idle_destroys_total", "Connections closed for exceeding max idle time."),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.pool.Stat()
+	gauge := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v)
+	}
+	counter := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, v)
+	}
+	gauge(c.maxConns, float64(st.MaxConns()))
+	gauge(c.totalConns, float64(st.TotalConns()))
+	gauge(c.acquiredConns, float64(st.AcquiredConns()))
+	gauge(c.idleConns, float64(st.IdleConns()))
+	gauge(c.constructingConns, float64(st.ConstructingConns()))
+	counter(c.acquires, float64(st.AcquireCount()))
+	counter(c.acquireSeconds, st.AcquireDuration().Seconds())
+	counter(c.emptyAcquires, float64(st.EmptyAcquireCount()))
+	counter(c.canceledAcquires, float64(st.CanceledAcquireCount()))
+	counter(c.newConns, float64(st.NewConnsCount()))
+	counter(c.lifetimeDestroys, float64(st.MaxLifetimeDestroyCount()))
+	counter(c.idleDestroys, float64(st.MaxIdleDestroyCount()))
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Schema migrations live in migrations/ as NNNN_name.up.sql / NNNN_name.down.sql
+// pairs and are embedded into the binary. Applied versions are recorded in
+// schema_migrations, mirroring the SQLite registry used by packages/core.
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the pg_advisory_lock key that serializes concurrent migrators.
+const migrationLockID int64 = 0x6d6574615f646a // "meta_dj"
+
+type Migration struct {
+	Version string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   string     `json:"version"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	Missing   bool       `json:"missing,omitempty"` // applied in the DB but unknown to this binary
+}
+
+type Migrator struct {
+	conn       *pgx.Conn
+	migrations []Migration
+}
+
+func loadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[string]*Migration{}
+	for _, e := range entries {
+		name := e.Name()
+		var version, dir string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			version, dir = strings.TrimSuffix(name, ".up.sql"), "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			version, dir = strings.TrimSuffix(name, ".down.sql"), "down"
+		default:
+			return nil, fmt.Errorf("migration %s: expected .up.sql or .down.sql suffix", name)
+		}
+		b, err := migrationFiles.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version}
+			byVersion[version] = m
+		}
+		if dir == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %s: missing up script", m.Version)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+func NewMigrator(conn *pgx.Conn) (*Migrator, error) {
+	ms, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{conn: conn, migrations: ms}, nil
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	_, err := m.conn.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version TEXT PRIMARY KEY,
+  applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`)
+	return err
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[string]time.Time, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := m.conn.Query(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]time.Time{}
+	for rows.Next() {
+		var v string
+		var t time.Time
+		if err := rows.Scan(&v, &t); err != nil {
+			return nil, err
+		}
+		out[v] = t
+	}
+	return out, rows.Err()
+}
+
+// Status lists every known migration plus any applied version this binary does not know.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := []MigrationStatus{}
+	known := map[string]bool{}
+	for _, mg := range m.migrations {
+		known[mg.Version] = true
+		st := MigrationStatus{Version: mg.Version}
+		if t, ok := applied[mg.Version]; ok {
+			st.AppliedAt = &t
+		}
+		out = append(out, st)
+	}
+	for v, t := range applied {
+		if !known[v] {
+			t := t
+			out = append(out, MigrationStatus{Version: v, AppliedAt: &t, Missing: true})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Pending returns the migrations not yet applied, in order.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := []Migration{}
+	for _, mg := range m.migrations {
+		if _, ok := applied[mg.Version]; !ok {
+			out = append(out, mg)
+		}
+	}
+	return out, nil
+}
+
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	if _, err := m.conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return nil, err
+	}
+	return func() { m.conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID) }, nil
+}
+
+// Up applies up to n pending migrations (all when n <= 0), each in its own transaction.
+func (m *Migrator) Up(ctx context.Context, n int) ([]string, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 && n < len(pending) {
+		pending = pending[:n]
+	}
+	done := []string{}
+	for _, mg := range pending {
+		err := pgx.BeginFunc(ctx, m.conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, mg.Up); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, `INSERT INTO schema_migrations(version) VALUES ($1)`, mg.Version)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %s up: %w", mg.Version, err)
+		}
+		done = append(done, mg.Version)
+	}
+	return done, nil
+}
+
+// Down reverts the n most recently applied migrations (one when n <= 0).
+func (m *Migrator) Down(ctx context.Context, n int) ([]string, error) {
+	if n <= 0 {
+		n = 1
+	}
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	done := []string{}
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < n; i-- {
+		mg := m.migrations[i]
+		if _, ok := applied[mg.Version]; !ok {
+			continue
+		}
+		if mg.Down == "" {
+			return done, fmt.Errorf("migration %s: no down script", mg.Version)
+		}
+		err := pgx.BeginFunc(ctx, m.conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, mg.Down); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version=$1`, mg.Version)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %s down: %w", mg.Version, err)
+		}
+		done = append(done, mg.Version)
+	}
+	return done, nil
+}
+
+// CheckSchema returns an error when the database is missing migrations known to this binary.
+func (m *Migrator) CheckSchema(ctx context.Context) error {
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	versions := make([]string, len(pending))
+	for i, mg := range pending {
+		versions[i] = mg.Version
+	}
+	return fmt.Errorf("database schema is behind: %d pending migration(s): %s (run `api migrate up`)", len(pending), strings.Join(versions, ", "))
+}
+
+// runMigrate implements `api migrate up [N] | down [N] | status`.
+func runMigrate(ctx context.Context, dsn string, args []string, out io.Writer) error {
+	if dsn == "" {
+		return fmt.Errorf("DATABASE_URL is required")
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up [N] | down [N] | status")
+	}
+	n := 0
+	if len(args) > 1 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < 0 {
+			return fmt.Errorf("invalid step count %q", args[1])
+		}
+		n = v
+	}
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	m, err := NewMigrator(conn)
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "up":
+		done, err := m.Up(ctx, n)
+		for _, v := range done {
+			fmt.Fprintf(out, "applied %s\n", v)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Fprintln(out, "schema is up to date")
+		}
+		return err
+	case "down":
+		done, err := m.Down(ctx, n)
+		for _, v := range done {
+			fmt.Fprintf(out, "reverted %s\n", v)
+		}
+		return err
+	case "status":
+		sts, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tSTATE\tAPPLIED AT")
+		for _, st := range sts {
+			state, at := "pending", ""
+			if st.AppliedAt != nil {
+				state, at = "applied", st.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			if st.Missing {
+				state = "unknown"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", st.Version, state, at)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q (want up, down or status)", args[0])
+	}
+}
+
+// ensureSchema applies pending migrations when autoMigrate is set, otherwise
+// fails if the schema is behind. Called once at startup before serving.
+func ensureSchema(ctx context.Context, pool *pgxpool.Pool, autoMigrate bool) ([]string, error) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+	m, err := NewMigrator(conn.Conn())
+	if err != nil {
+		return nil, err
+	}
+	if autoMigrate {
+		return m.Up(ctx, 0)
+	}
+	return nil, m.CheckSchema(ctx)
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS sync_changes;
+DROP TABLE IF EXISTS cues;
+DROP TABLE IF EXISTS tracks;
@@ -0,0 +1,37 @@
+-- Baseline: tables previously created by the stores' init methods.
+-- IF NOT EXISTS lets databases created before migrations adopt this version.
+CREATE TABLE IF NOT EXISTS tracks (
+  id TEXT PRIMARY KEY,
+  title TEXT,
+  file_path TEXT NOT NULL,
+  year INTEGER,
+  genre TEXT,
+  duration_ms BIGINT,
+  bpm_override DOUBLE PRECISION
+);
+CREATE INDEX IF NOT EXISTS idx_tracks_title ON tracks(title);
+CREATE INDEX IF NOT EXISTS idx_tracks_path ON tracks(file_path);
+
+CREATE TABLE IF NOT EXISTS cues (
+  id TEXT PRIMARY KEY,
+  track_id TEXT NOT NULL,
+  position_ms BIGINT NOT NULL,
+  color TEXT,
+  label TEXT,
+  type TEXT NOT NULL DEFAULT 'HOT',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_cues_track ON cues(track_id);
+
+CREATE TABLE IF NOT EXISTS sync_changes (
+  id SERIAL PRIMARY KEY,
+  entity_type TEXT NOT NULL,
+  entity_id TEXT NOT NULL,
+  field TEXT NOT NULL,
+  value_hash TEXT NOT NULL,
+  device_id TEXT NOT NULL,
+  lamport_clock BIGINT NOT NULL,
+  vector_clock TEXT NOT NULL,
+  ts TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sync_changes_ts ON sync_changes(ts);
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS export_profiles;
+DROP TABLE IF EXISTS track_tags;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS playlist_tracks;
+DROP TABLE IF EXISTS playlists;
+DROP TABLE IF EXISTS loops;
+DROP TABLE IF EXISTS analysis;
+DROP TABLE IF EXISTS artwork;
+DROP TABLE IF EXISTS album_artists;
+DROP TABLE IF EXISTS track_artists;
+DROP TABLE IF EXISTS albums;
+DROP TABLE IF EXISTS artists;
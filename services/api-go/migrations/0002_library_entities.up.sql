@@ -0,0 +1,114 @@
+-- Library entities mirrored from packages/core/migrations (SQLite).
+CREATE TABLE artists (
+  id TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CONSTRAINT uq_artists_name UNIQUE(name)
+);
+
+CREATE TABLE albums (
+  id TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  year INTEGER,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE track_artists (
+  track_id TEXT NOT NULL REFERENCES tracks(id) ON UPDATE CASCADE ON DELETE CASCADE,
+  artist_id TEXT NOT NULL REFERENCES artists(id) ON UPDATE CASCADE ON DELETE CASCADE,
+  role TEXT NOT NULL DEFAULT 'PRIMARY',
+  position INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (track_id, artist_id, role)
+);
+CREATE INDEX idx_track_artists_track ON track_artists(track_id);
+CREATE INDEX idx_track_artists_artist ON track_artists(artist_id);
+
+CREATE TABLE album_artists (
+  album_id TEXT NOT NULL REFERENCES albums(id) ON UPDATE CASCADE ON DELETE CASCADE,
+  artist_id TEXT NOT NULL REFERENCES artists(id) ON UPDATE CASCADE ON DELETE CASCADE,
+  role TEXT NOT NULL DEFAULT 'PRIMARY',
+  position INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (album_id, artist_id, role)
+);
+
+CREATE TABLE artwork (
+  id TEXT PRIMARY KEY,
+  track_id TEXT NOT NULL REFERENCES tracks(id) ON UPDATE CASCADE ON DELETE CASCADE,
+  blob_hash TEXT NOT NULL,
+  mime_type TEXT,
+  width INTEGER,
+  height INTEGER,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE analysis (
+  id TEXT PRIMARY KEY,
+  track_id TEXT NOT NULL REFERENCES tracks(id) ON UPDATE CASCADE ON DELETE CASCADE,
+  analyzer_version TEXT NOT NULL,
+  bpm DOUBLE PRECISION,
+  bpm_confidence DOUBLE PRECISION,
+  musical_key TEXT,
+  key_confidence DOUBLE PRECISION,
+  beatgrid_json TEXT,
+  lufs DOUBLE PRECISION,
+  peak DOUBLE PRECISION,
+  waveform_ref TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX idx_analysis_track ON analysis(track_id);
+
+CREATE TABLE loops (
+  id TEXT PRIMARY KEY,
+  track_id TEXT NOT NULL REFERENCES tracks(id) ON UPDATE CASCADE ON DELETE CASCADE,
+  start_ms BIGINT NOT NULL,
+  length_beats INTEGER NOT NULL,
+  color TEXT,
+  label TEXT,
+  autogen BOOLEAN NOT NULL DEFAULT false,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX idx_loops_track ON loops(track_id);
+
+CREATE TABLE playlists (
+  id TEXT PRIMARY KEY,
+  parent_id TEXT REFERENCES playlists(id) ON UPDATE CASCADE ON DELETE SET NULL,
+  name TEXT NOT NULL,
+  smart_rules_json TEXT,
+  order_index INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE playlist_tracks (
+  playlist_id TEXT NOT NULL REFERENCES playlists(id) ON UPDATE CASCADE ON DELETE CASCADE,
+  track_id TEXT NOT NULL REFERENCES tracks(id) ON UPDATE CASCADE ON DELETE CASCADE,
+  position INTEGER NOT NULL,
+  PRIMARY KEY (playlist_id, track_id)
+);
+CREATE INDEX idx_playlist_tracks_playlist ON playlist_tracks(playlist_id);
+CREATE INDEX idx_playlist_tracks_track ON playlist_tracks(track_id);
+
+CREATE TABLE tags (
+  id TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  color TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CONSTRAINT uq_tags_name UNIQUE(name)
+);
+
+CREATE TABLE track_tags (
+  track_id TEXT NOT NULL REFERENCES tracks(id) ON UPDATE CASCADE ON DELETE CASCADE,
+  tag_id TEXT NOT NULL REFERENCES tags(id) ON UPDATE CASCADE ON DELETE CASCADE,
+  PRIMARY KEY (track_id, tag_id)
+);
+CREATE INDEX idx_track_tags_track ON track_tags(track_id);
+CREATE INDEX idx_track_tags_tag ON track_tags(tag_id);
+
+CREATE TABLE export_profiles (
+  id TEXT PRIMARY KEY,
+  user_id TEXT,
+  target TEXT NOT NULL,
+  options_json TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,2 @@
+ALTER TABLE cues DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE cues DROP COLUMN IF EXISTS autogen;
@@ -0,0 +1,3 @@
+-- Align cues with the SQLite schema: autogen flag and updated_at.
+ALTER TABLE cues ADD COLUMN autogen BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE cues ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
@@ -0,0 +1,3 @@
+ALTER TABLE tracks DROP COLUMN IF EXISTS modified_at;
+ALTER TABLE tracks DROP COLUMN IF EXISTS added_at;
+ALTER TABLE tracks DROP COLUMN IF EXISTS version;
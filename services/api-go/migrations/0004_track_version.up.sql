@@ -0,0 +1,5 @@
+-- Optimistic concurrency for track edits: version is bumped on every write and
+-- exposed as the track's ETag. The timestamps carry the core CLI's names.
+ALTER TABLE tracks ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE tracks ADD COLUMN added_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE tracks ADD COLUMN modified_at TIMESTAMPTZ NOT NULL DEFAULT now();
@@ -0,0 +1,20 @@
+DROP INDEX IF EXISTS idx_tracks_content_hash;
+DROP INDEX IF EXISTS idx_tracks_album;
+ALTER TABLE tracks
+  DROP COLUMN IF EXISTS tag_key_raw,
+  DROP COLUMN IF EXISTS tag_bpm_raw,
+  DROP COLUMN IF EXISTS tag_key,
+  DROP COLUMN IF EXISTS tag_bpm,
+  DROP COLUMN IF EXISTS disc_total,
+  DROP COLUMN IF EXISTS disc_no,
+  DROP COLUMN IF EXISTS track_total,
+  DROP COLUMN IF EXISTS track_no,
+  DROP COLUMN IF EXISTS rating,
+  DROP COLUMN IF EXISTS color,
+  DROP COLUMN IF EXISTS comments,
+  DROP COLUMN IF EXISTS channels,
+  DROP COLUMN IF EXISTS sample_rate_hz,
+  DROP COLUMN IF EXISTS bit_rate_kbps,
+  DROP COLUMN IF EXISTS codec,
+  DROP COLUMN IF EXISTS album_id,
+  DROP COLUMN IF EXISTS content_hash;
@@ -0,0 +1,21 @@
+-- Track columns of the core CLI's SQLite library (packages/core/migrations).
+ALTER TABLE tracks ADD COLUMN content_hash TEXT;
+ALTER TABLE tracks ADD COLUMN album_id TEXT
+  CONSTRAINT fk_tracks_album REFERENCES albums(id) ON UPDATE CASCADE ON DELETE SET NULL;
+ALTER TABLE tracks ADD COLUMN codec TEXT;
+ALTER TABLE tracks ADD COLUMN bit_rate_kbps INTEGER;
+ALTER TABLE tracks ADD COLUMN sample_rate_hz INTEGER;
+ALTER TABLE tracks ADD COLUMN channels INTEGER;
+ALTER TABLE tracks ADD COLUMN comments TEXT;
+ALTER TABLE tracks ADD COLUMN color TEXT;
+ALTER TABLE tracks ADD COLUMN rating INTEGER;
+ALTER TABLE tracks ADD COLUMN track_no INTEGER;
+ALTER TABLE tracks ADD COLUMN track_total INTEGER;
+ALTER TABLE tracks ADD COLUMN disc_no INTEGER;
+ALTER TABLE tracks ADD COLUMN disc_total INTEGER;
+ALTER TABLE tracks ADD COLUMN tag_bpm DOUBLE PRECISION;
+ALTER TABLE tracks ADD COLUMN tag_key TEXT;
+ALTER TABLE tracks ADD COLUMN tag_bpm_raw DOUBLE PRECISION;
+ALTER TABLE tracks ADD COLUMN tag_key_raw TEXT;
+CREATE INDEX idx_tracks_album ON tracks(album_id);
+CREATE INDEX idx_tracks_content_hash ON tracks(content_hash);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_tracks_title_id;
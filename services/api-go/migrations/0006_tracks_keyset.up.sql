@@ -0,0 +1,3 @@
+-- Keyset pagination orders by (COALESCE(title, ''), id); this index serves
+-- both directions without a sort.
+CREATE INDEX idx_tracks_title_id ON tracks ((COALESCE(title, '')), id);
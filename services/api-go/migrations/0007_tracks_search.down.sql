@@ -0,0 +1,15 @@
+DROP TRIGGER IF EXISTS trg_tags_search ON tags;
+DROP TRIGGER IF EXISTS trg_albums_search ON albums;
+DROP TRIGGER IF EXISTS trg_artists_search ON artists;
+DROP TRIGGER IF EXISTS trg_track_tags_search ON track_tags;
+DROP TRIGGER IF EXISTS trg_track_artists_search ON track_artists;
+DROP TRIGGER IF EXISTS trg_tracks_search ON tracks;
+DROP FUNCTION IF EXISTS metadj_tracks_search_rename();
+DROP FUNCTION IF EXISTS metadj_tracks_search_link();
+DROP FUNCTION IF EXISTS metadj_tracks_search_own();
+DROP FUNCTION IF EXISTS metadj_track_search_vector(tracks);
+DROP INDEX IF EXISTS idx_tracks_search;
+ALTER TABLE tracks DROP COLUMN IF EXISTS search_vector;
+DROP TEXT SEARCH CONFIGURATION IF EXISTS metadj;
+DROP TEXT SEARCH DICTIONARY IF EXISTS metadj_stem;
+-- The unaccent extension is left installed; other schemas may use it.
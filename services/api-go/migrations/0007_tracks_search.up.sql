@@ -0,0 +1,83 @@
+-- Full-text search over title, artists, album, tags and comments, mirroring
+-- the core CLI's FTS5 index (porter stemming) plus accent folding.
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+-- English stemming without a stopword list, so "The" in "The Prodigy" stays searchable.
+CREATE TEXT SEARCH DICTIONARY metadj_stem (TEMPLATE = snowball, Language = english);
+CREATE TEXT SEARCH CONFIGURATION metadj (COPY = simple);
+ALTER TEXT SEARCH CONFIGURATION metadj
+  ALTER MAPPING FOR asciiword, asciihword, hword_asciipart, word, hword, hword_part
+  WITH unaccent, metadj_stem;
+
+ALTER TABLE tracks ADD COLUMN search_vector tsvector;
+
+-- Weights: title and artists A, album B, tags C, comments D.
+CREATE FUNCTION metadj_track_search_vector(t tracks) RETURNS tsvector
+LANGUAGE sql STABLE AS $$
+  SELECT setweight(to_tsvector('metadj', coalesce(t.title, '')), 'A')
+      || setweight(to_tsvector('metadj', coalesce((
+           SELECT string_agg(a.name, ' ') FROM track_artists ta JOIN artists a ON a.id = ta.artist_id
+           WHERE ta.track_id = t.id), '')), 'A')
+      || setweight(to_tsvector('metadj', coalesce((SELECT al.name FROM albums al WHERE al.id = t.album_id), '')), 'B')
+      || setweight(to_tsvector('metadj', coalesce((
+           SELECT string_agg(g.name, ' ') FROM track_tags tt JOIN tags g ON g.id = tt.tag_id
+           WHERE tt.track_id = t.id), '')), 'C')
+      || setweight(to_tsvector('metadj', coalesce(t.comments, '')), 'D')
+$$;
+
+CREATE FUNCTION metadj_tracks_search_own() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+  NEW.search_vector := metadj_track_search_vector(NEW);
+  RETURN NEW;
+END $$;
+
+CREATE TRIGGER trg_tracks_search
+BEFORE INSERT OR UPDATE OF title, comments, album_id ON tracks
+FOR EACH ROW EXECUTE FUNCTION metadj_tracks_search_own();
+
+-- Link tables: refresh the tracks on either side of the change.
+CREATE FUNCTION metadj_tracks_search_link() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+  IF TG_OP <> 'DELETE' THEN
+    UPDATE tracks t SET search_vector = metadj_track_search_vector(t) WHERE t.id = NEW.track_id;
+  END IF;
+  IF TG_OP <> 'INSERT' THEN
+    UPDATE tracks t SET search_vector = metadj_track_search_vector(t) WHERE t.id = OLD.track_id;
+  END IF;
+  RETURN NULL;
+END $$;
+
+CREATE TRIGGER trg_track_artists_search
+AFTER INSERT OR UPDATE OR DELETE ON track_artists
+FOR EACH ROW EXECUTE FUNCTION metadj_tracks_search_link();
+CREATE TRIGGER trg_track_tags_search
+AFTER INSERT OR UPDATE OR DELETE ON track_tags
+FOR EACH ROW EXECUTE FUNCTION metadj_tracks_search_link();
+
+-- Renames of artists, albums and tags.
+CREATE FUNCTION metadj_tracks_search_rename() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+  IF TG_TABLE_NAME = 'artists' THEN
+    UPDATE tracks t SET search_vector = metadj_track_search_vector(t)
+    WHERE t.id IN (SELECT track_id FROM track_artists WHERE artist_id = NEW.id);
+  ELSIF TG_TABLE_NAME = 'albums' THEN
+    UPDATE tracks t SET search_vector = metadj_track_search_vector(t) WHERE t.album_id = NEW.id;
+  ELSE
+    UPDATE tracks t SET search_vector = metadj_track_search_vector(t)
+    WHERE t.id IN (SELECT track_id FROM track_tags WHERE tag_id = NEW.id);
+  END IF;
+  RETURN NULL;
+END $$;
+
+CREATE TRIGGER trg_artists_search AFTER UPDATE OF name ON artists
+FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name) EXECUTE FUNCTION metadj_tracks_search_rename();
+CREATE TRIGGER trg_albums_search AFTER UPDATE OF name ON albums
+FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name) EXECUTE FUNCTION metadj_tracks_search_rename();
+CREATE TRIGGER trg_tags_search AFTER UPDATE OF name ON tags
+FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name) EXECUTE FUNCTION metadj_tracks_search_rename();
+
+UPDATE tracks t SET search_vector = metadj_track_search_vector(t);
+CREATE INDEX idx_tracks_search ON tracks USING GIN (search_vector);
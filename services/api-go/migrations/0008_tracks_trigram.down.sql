@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_artists_name_trgm;
+DROP INDEX IF EXISTS idx_tracks_title_trgm;
+-- The pg_trgm extension is left installed; other schemas may use it.
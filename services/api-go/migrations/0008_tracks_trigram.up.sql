@@ -0,0 +1,6 @@
+-- Typo-tolerant search: trigram indexes for word_similarity (<%) matching
+-- of titles and artist names.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX idx_tracks_title_trgm ON tracks USING GIN (title gin_trgm_ops);
+CREATE INDEX idx_artists_name_trgm ON artists USING GIN (name gin_trgm_ops);
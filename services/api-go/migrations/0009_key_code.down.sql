@@ -0,0 +1 @@
+DROP FUNCTION IF EXISTS metadj_key_code(text);
@@ -0,0 +1,55 @@
+-- metadj_key_code normalizes a key in any notation the musickey package
+-- parses (classical, Camelot, Open Key, "8A - Am" composites) to a
+-- zero-padded Camelot code such as '08A', so filters and sorts compare keys
+-- whatever the tags or analyzers wrote. Keep it in step with musickey.Parse.
+CREATE FUNCTION metadj_key_code(raw text) RETURNS text
+LANGUAGE plpgsql IMMUTABLE PARALLEL SAFE AS $$
+DECLARE
+  part text;
+  m text[];
+  pc int;
+  rest text;
+  words text;
+  minor boolean;
+BEGIN
+  raw := btrim(replace(replace(raw, '♯', '#'), '♭', 'b'));
+  IF raw IS NULL OR raw = '' THEN
+    RETURN NULL;
+  END IF;
+  FOREACH part IN ARRAY array_prepend(raw, regexp_split_to_array(raw, '\s+-\s+|[/,|()]')) LOOP
+    part := btrim(part);
+    m := regexp_match(part, '^0?(1[0-2]|[1-9])\s*([AaBb])$');
+    IF m IS NOT NULL THEN
+      RETURN lpad(m[1], 2, '0') || upper(m[2]);
+    END IF;
+    -- Open Key 1d is Camelot 8B.
+    m := regexp_match(part, '^0?(1[0-2]|[1-9])\s*([MmDd])$');
+    IF m IS NOT NULL THEN
+      RETURN lpad(((m[1]::int + 6) % 12 + 1)::text, 2, '0') || CASE WHEN lower(m[2]) = 'm' THEN 'A' ELSE 'B' END;
+    END IF;
+    pc := CASE upper(left(part, 1)) WHEN 'C' THEN 0 WHEN 'D' THEN 2 WHEN 'E' THEN 4 WHEN 'F' THEN 5
+                                    WHEN 'G' THEN 7 WHEN 'A' THEN 9 WHEN 'B' THEN 11 END;
+    CONTINUE WHEN pc IS NULL;
+    rest := substr(part, 2);
+    words := lower(ltrim(rest, ' -'));
+    IF left(rest, 1) = '#' THEN
+      pc := pc + 1; rest := substr(rest, 2);
+    ELSIF left(rest, 1) = 'b' THEN
+      pc := pc - 1; rest := substr(rest, 2);
+    ELSIF words LIKE 'sharp%' THEN
+      pc := pc + 1; rest := substr(words, 6);
+    ELSIF words LIKE 'flat%' THEN
+      pc := pc - 1; rest := substr(words, 5);
+    END IF;
+    rest := lower(btrim(rest));
+    IF rest IN ('', 'maj', 'major', 'dur') THEN
+      minor := false;
+    ELSIF rest IN ('m', 'mi', 'min', 'minor', 'moll') THEN
+      minor := true; pc := pc + 3;
+    ELSE
+      CONTINUE;
+    END IF;
+    RETURN lpad(((7 * ((pc + 12) % 12) + 7) % 12 + 1)::text, 2, '0') || CASE WHEN minor THEN 'A' ELSE 'B' END;
+  END LOOP;
+  RETURN NULL;
+END $$;
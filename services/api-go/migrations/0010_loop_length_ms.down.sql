@@ -0,0 +1,5 @@
+ALTER TABLE loops DROP CONSTRAINT IF EXISTS chk_loops_length;
+-- Millisecond-only loops have no beat length to fall back to.
+DELETE FROM loops WHERE length_beats IS NULL;
+ALTER TABLE loops ALTER COLUMN length_beats SET NOT NULL;
+ALTER TABLE loops DROP COLUMN IF EXISTS length_ms;
@@ -0,0 +1,8 @@
+-- Loops may be given in milliseconds instead of beats (no beatgrid needed).
+ALTER TABLE loops ADD COLUMN length_ms BIGINT;
+ALTER TABLE loops ALTER COLUMN length_beats DROP NOT NULL;
+ALTER TABLE loops ADD CONSTRAINT chk_loops_length CHECK (
+  (length_beats IS NOT NULL OR length_ms IS NOT NULL)
+  AND (length_beats IS NULL OR length_beats > 0)
+  AND (length_ms IS NULL OR length_ms > 0)
+);
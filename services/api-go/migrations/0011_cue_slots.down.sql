@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS uq_cues_track_slot;
+ALTER TABLE cues DROP CONSTRAINT IF EXISTS chk_cues_slot;
+ALTER TABLE cues DROP COLUMN IF EXISTS slot;
+ALTER TABLE cues DROP CONSTRAINT IF EXISTS chk_cues_type;
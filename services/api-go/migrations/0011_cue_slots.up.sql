@@ -0,0 +1,13 @@
+-- Hot cue slots A-H (unique per track) and the HOT/MEMORY/LOOP cue types.
+UPDATE cues SET type = upper(type);
+UPDATE cues SET type = 'HOT' WHERE type NOT IN ('HOT', 'MEMORY', 'LOOP');
+ALTER TABLE cues ADD CONSTRAINT chk_cues_type CHECK (type IN ('HOT', 'MEMORY', 'LOOP'));
+
+ALTER TABLE cues ADD COLUMN slot TEXT;
+ALTER TABLE cues ADD CONSTRAINT chk_cues_slot CHECK (slot IS NULL OR (slot ~ '^[A-H]$' AND type = 'HOT'));
+-- Existing hot cues take slots in position order; past eight they stay unslotted.
+UPDATE cues c SET slot = chr(ascii('A') + n.rn - 1)
+FROM (SELECT id, row_number() OVER (PARTITION BY track_id ORDER BY position_ms, id) AS rn
+      FROM cues WHERE type = 'HOT') n
+WHERE c.id = n.id AND n.rn <= 8;
+CREATE UNIQUE INDEX uq_cues_track_slot ON cues(track_id, slot) WHERE slot IS NOT NULL;
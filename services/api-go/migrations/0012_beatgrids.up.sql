@@ -0,0 +1,8 @@
+-- Edited beatgrids; tracks without one fall back to the latest analysis.beatgrid_json.
+CREATE TABLE beatgrids (
+  track_id TEXT PRIMARY KEY REFERENCES tracks(id) ON UPDATE CASCADE ON DELETE CASCADE,
+  beats_per_bar SMALLINT NOT NULL DEFAULT 4 CHECK (beats_per_bar BETWEEN 1 AND 16),
+  markers JSONB NOT NULL CHECK (jsonb_typeof(markers) = 'array' AND jsonb_array_length(markers) > 0),
+  version BIGINT NOT NULL DEFAULT 1,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
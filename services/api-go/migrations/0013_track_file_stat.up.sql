@@ -0,0 +1,4 @@
+-- Size and modification time as the last import scan saw them: a rescan
+-- skips hashing files whose stat is unchanged.
+ALTER TABLE tracks ADD COLUMN file_size BIGINT;
+ALTER TABLE tracks ADD COLUMN file_mtime TIMESTAMPTZ;
@@ -0,0 +1,143 @@
+// Package mixing scores transitions between tracks for harmonic mixing:
+// Camelot key compatibility (keys from package musickey) plus tempo
+// distance, counting half- and double-time.
+package mixing
+
+import (
+	"math"
+
+	"meta-dj/services/api-go/musickey"
+)
+
+// step moves k n positions clockwise around the Camelot wheel.
+func step(k musickey.Key, n int) musickey.Key {
+	return musickey.FromCamelot(k.CamelotNumber()+n, k.Minor)
+}
+
+// Move names how a transition travels around the wheel.
+type Move string
+
+const (
+	MoveSame     Move = "same"
+	MoveAdjacent Move = "adjacent" // one step either way, same letter
+	MoveRelative Move = "relative" // relative major or minor
+	MoveBoost    Move = "boost"    // two steps up: a whole tone, energy boost
+	MoveSemitone Move = "semitone" // seven steps up: a semitone, energy boost
+	MoveDiagonal Move = "diagonal" // one step up and across, energy boost
+)
+
+// keyScores rate each move; energy-boost moves work but are a statement.
+var keyScores = map[Move]float64{
+	MoveSame: 1, MoveRelative: 0.9, MoveAdjacent: 0.85,
+	MoveBoost: 0.7, MoveDiagonal: 0.65, MoveSemitone: 0.6,
+}
+
+// KeyMove returns the move from one key to another, if they are compatible.
+// The boost, semitone and diagonal moves count only with energy.
+func KeyMove(from, to musickey.Key, energy bool) (Move, bool) {
+	switch {
+	case to == from:
+		return MoveSame, true
+	case to == step(from, 1) || to == step(from, -1):
+		return MoveAdjacent, true
+	case to.CamelotNumber() == from.CamelotNumber():
+		return MoveRelative, true
+	case !energy:
+		return "", false
+	case to == step(from, 2):
+		return MoveBoost, true
+	case to == step(from, 7):
+		return MoveSemitone, true
+	case to.Minor != from.Minor && to.CamelotNumber() == step(from, 1).CamelotNumber():
+		return MoveDiagonal, true
+	}
+	return "", false
+}
+
+// KeyScore is the rating Score starts from for a move.
+func KeyScore(m Move) float64 { return keyScores[m] }
+
+// Compatible returns every key KeyMove accepts from k, with its move.
+func Compatible(k musickey.Key, energy bool) map[musickey.Key]Move {
+	out := map[musickey.Key]Move{}
+	for n := 1; n <= 12; n++ {
+		for _, minor := range []bool{true, false} {
+			to := musickey.FromCamelot(n, minor)
+			if m, ok := KeyMove(k, to, energy); ok {
+				out[to] = m
+			}
+		}
+	}
+	return out
+}
+
+// Tempo compares a candidate BPM to the seed's at the same, double and half
+// time. ratio is what the candidate is matched against (2 means the
+// candidate runs at double time) and delta the remaining difference in
+// percent; ok is false when no ratio lands within window percent.
+func Tempo(from, to, window float64) (ratio, delta float64, ok bool) {
+	if from <= 0 || to <= 0 {
+		return 0, 0, false
+	}
+	delta = math.Inf(1)
+	for _, r := range []float64{1, 2, 0.5} {
+		if d := math.Abs(to-from*r) / (from * r) * 100; d < delta {
+			ratio, delta = r, d
+		}
+	}
+	return ratio, delta, delta <= window
+}
+
+// Track is what scoring needs to know about a track. Key may be in any
+// notation musickey parses.
+type Track struct {
+	Key string
+	Bpm float64
+}
+
+// Options tune Score.
+type Options struct {
+	// BpmWindow is the tempo difference allowed, in percent.
+	BpmWindow float64
+	// EnergyBoost admits the boost, semitone and diagonal moves.
+	EnergyBoost bool
+}
+
+// Transition describes mixing from one track into another.
+type Transition struct {
+	Move       Move         `json:"move"`
+	Key        musickey.Key `json:"key"` // the candidate's, written in Camelot
+	TempoRatio float64      `json:"tempo_ratio"`
+	BpmDelta   float64      `json:"bpm_delta"` // percent, after the ratio
+	// Score ranks transitions in (0, 1]: the key move's rating scaled down
+	// by tempo distance, and a little more for half- or double-time.
+	Score float64 `json:"score"`
+}
+
+// Score rates the transition from one track to another; ok is false when
+// the keys clash, a key is unknown or the tempos are too far apart.
+func Score(from, to Track, opts Options) (t Transition, ok bool) {
+	fk, ok1 := musickey.Parse(from.Key)
+	tk, ok2 := musickey.Parse(to.Key)
+	if !ok1 || !ok2 {
+		return t, false
+	}
+	move, ok := KeyMove(fk, tk, opts.EnergyBoost)
+	if !ok {
+		return t, false
+	}
+	ratio, delta, ok := Tempo(from.Bpm, to.Bpm, opts.BpmWindow)
+	if !ok {
+		return t, false
+	}
+	score := keyScores[move]
+	if opts.BpmWindow > 0 {
+		score *= 1 - 0.5*delta/opts.BpmWindow
+	}
+	if ratio != 1 {
+		score *= 0.9
+	}
+	return Transition{Move: move, Key: tk, TempoRatio: ratio, BpmDelta: round(delta), Score: round(score)}, true
+}
+
+func round(v float64) float64 { return math.Round(v*1000) / 1000 }
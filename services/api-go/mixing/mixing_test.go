@@ -0,0 +1,123 @@
+package mixing
+
+import (
+	"testing"
+
+	"meta-dj/services/api-go/musickey"
+)
+
+func key(t *testing.T, s string) musickey.Key {
+	t.Helper()
+	k, ok := musickey.Parse(s)
+	if !ok {
+		t.Fatalf("musickey.Parse(%q) failed", s)
+	}
+	return k
+}
+
+func TestKeyMove(t *testing.T) {
+	for _, tc := range []struct {
+		from, to string
+		energy   bool
+		want     Move
+		ok       bool
+	}{
+		{"8A", "8A", false, MoveSame, true},
+		{"8A", "Am", false, MoveSame, true},
+		{"8A", "9A", false, MoveAdjacent, true},
+		{"8A", "7A", false, MoveAdjacent, true},
+		{"12A", "1A", false, MoveAdjacent, true}, // around the wheel
+		{"1B", "12B", false, MoveAdjacent, true},
+		{"8A", "8B", false, MoveRelative, true}, // Am to C
+		{"C", "Am", false, MoveRelative, true},
+		{"8A", "10A", false, "", false}, // energy moves need energy
+		{"8A", "3A", false, "", false},
+		{"8A", "9B", false, "", false},
+		{"8A", "10A", true, MoveBoost, true},
+		{"11A", "1A", true, MoveBoost, true},
+		{"8A", "3A", true, MoveSemitone, true},
+		{"8A", "9B", true, MoveDiagonal, true},
+		{"8B", "9A", true, MoveDiagonal, true},
+		{"8A", "9A", true, MoveAdjacent, true}, // energy does not change the plain moves
+		{"8A", "6A", true, "", false},
+		{"8A", "2B", true, "", false},
+	} {
+		got, ok := KeyMove(key(t, tc.from), key(t, tc.to), tc.energy)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("KeyMove(%s, %s, %v) = %q, %v; want %q, %v", tc.from, tc.to, tc.energy, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	for _, tc := range []struct {
+		energy bool
+		want   map[string]Move
+	}{
+		{false, map[string]Move{"8A": MoveSame, "7A": MoveAdjacent, "9A": MoveAdjacent, "8B": MoveRelative}},
+		{true, map[string]Move{
+			"8A": MoveSame, "7A": MoveAdjacent, "9A": MoveAdjacent, "8B": MoveRelative,
+			"10A": MoveBoost, "3A": MoveSemitone, "9B": MoveDiagonal,
+		}},
+	} {
+		got := Compatible(key(t, "8A"), tc.energy)
+		if len(got) != len(tc.want) {
+			t.Errorf("Compatible(8A, %v) = %v, want %v", tc.energy, got, tc.want)
+		}
+		for s, m := range tc.want {
+			if got[key(t, s)] != m {
+				t.Errorf("Compatible(8A, %v)[%s] = %q, want %q", tc.energy, s, got[key(t, s)], m)
+			}
+		}
+	}
+}
+
+func TestTempo(t *testing.T) {
+	for _, tc := range []struct {
+		from, to, window float64
+		ratio, delta     float64
+		ok               bool
+	}{
+		{128, 128, 6, 1, 0, true},
+		{128, 124, 6, 1, 3.125, true},
+		{128, 256, 6, 2, 0, true},
+		{140, 70, 6, 0.5, 0, true},
+		{128, 140, 6, 1, 9.375, false},
+		{128, 0, 6, 0, 0, false},
+	} {
+		ratio, delta, ok := Tempo(tc.from, tc.to, tc.window)
+		if ratio != tc.ratio || delta != tc.delta || ok != tc.ok {
+			t.Errorf("Tempo(%v, %v, %v) = %v, %v, %v; want %v, %v, %v", tc.from, tc.to, tc.window, ratio, delta, ok, tc.ratio, tc.delta, tc.ok)
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	seed := Track{Key: "Am", Bpm: 128}
+	plain, boost := Options{BpmWindow: 6}, Options{BpmWindow: 6, EnergyBoost: true}
+	for _, tc := range []struct {
+		to    Track
+		opts  Options
+		move  Move
+		score float64
+		ok    bool
+	}{
+		{Track{"8A", 128}, plain, MoveSame, 1, true},
+		{Track{"9A", 128}, plain, MoveAdjacent, 0.85, true},
+		{Track{"C", 128}, plain, MoveRelative, 0.9, true},
+		{Track{"8A", 124.16}, plain, MoveSame, 0.75, true}, // 3% off: a quarter down
+		{Track{"8A", 256}, plain, MoveSame, 0.9, true},     // double time
+		{Track{"10A", 128}, boost, MoveBoost, 0.7, true},
+		{Track{"3A", 128}, boost, MoveSemitone, 0.6, true},
+		{Track{"9B", 128}, boost, MoveDiagonal, 0.65, true},
+		{Track{"10A", 128}, plain, "", 0, false},
+		{Track{"8A", 140}, plain, "", 0, false},
+		{Track{"", 128}, plain, "", 0, false},
+		{Track{"8A", 128}, Options{}, MoveSame, 1, true}, // no window: exact tempo only
+	} {
+		tr, ok := Score(seed, tc.to, tc.opts)
+		if ok != tc.ok || tr.Move != tc.move || tr.Score != tc.score {
+			t.Errorf("Score(%+v, %+v) = %+v, %v; want %q %v, %v", tc.to, tc.opts, tr, ok, tc.move, tc.score, tc.ok)
+		}
+	}
+}
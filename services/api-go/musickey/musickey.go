@@ -0,0 +1,169 @@
+// Package musickey parses musical keys in the notations DJ software and
+// file tags use and converts between them: classical ("Am", "F# major",
+// "A-flat minor"), Camelot ("8A"), Open Key ("1m") and Mixed In Key style
+// composites ("8A - Am"). Enharmonic spellings normalize to one key.
+package musickey
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Key is a tonic pitch class (0 for C through 11 for B) and a mode.
+type Key struct {
+	Tonic int
+	Minor bool
+}
+
+// Notation names a way of writing keys.
+type Notation string
+
+const (
+	Classical Notation = "classical" // Am, Db, F#m
+	Camelot   Notation = "camelot"   // 8A, 3B
+	OpenKey   Notation = "open_key"  // 1m, 8d
+)
+
+// ParseNotation accepts the Notation names, case-insensitively.
+func ParseNotation(s string) (Notation, bool) {
+	switch n := Notation(strings.ToLower(s)); n {
+	case Classical, Camelot, OpenKey:
+		return n, true
+	}
+	return "", false
+}
+
+var (
+	camelotRe = regexp.MustCompile(`^0?(1[0-2]|[1-9])\s*([AaBb])$`)
+	openKeyRe = regexp.MustCompile(`^0?(1[0-2]|[1-9])\s*([MmDd])$`)
+	// separators split composite strings such as "8A - Am" or "Am/8A".
+	separators = regexp.MustCompile(`\s+-\s+|[/,|()]`)
+	symbols    = strings.NewReplacer("♯", "#", "♭", "b")
+)
+
+var naturals = map[byte]int{'C': 0, 'D': 2, 'E': 4, 'F': 5, 'G': 7, 'A': 9, 'B': 11}
+
+// Parse reads a key in any supported notation. In a composite string the
+// first part that parses wins.
+func Parse(s string) (Key, bool) {
+	s = symbols.Replace(strings.TrimSpace(s))
+	if s == "" {
+		return Key{}, false
+	}
+	if k, ok := parseOne(s); ok {
+		return k, true
+	}
+	for _, part := range separators.Split(s, -1) {
+		if k, ok := parseOne(strings.TrimSpace(part)); ok {
+			return k, true
+		}
+	}
+	return Key{}, false
+}
+
+func parseOne(s string) (Key, bool) {
+	if m := camelotRe.FindStringSubmatch(s); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return FromCamelot(n, strings.EqualFold(m[2], "A")), true
+	}
+	if m := openKeyRe.FindStringSubmatch(s); m != nil {
+		// Open Key 1d is C major, Camelot 8B.
+		n, _ := strconv.Atoi(m[1])
+		return FromCamelot(n+7, strings.EqualFold(m[2], "m")), true
+	}
+	if s == "" {
+		return Key{}, false
+	}
+	pc, ok := naturals[strings.ToUpper(s[:1])[0]]
+	if !ok {
+		return Key{}, false
+	}
+	rest := s[1:]
+	words := strings.ToLower(strings.TrimLeft(rest, " -"))
+	switch {
+	case strings.HasPrefix(rest, "#"):
+		pc, rest = pc+1, rest[1:]
+	case strings.HasPrefix(rest, "b"): // lowercase only: "Bb", never "BB"
+		pc, rest = pc-1, rest[1:]
+	case strings.HasPrefix(words, "sharp"):
+		pc, rest = pc+1, words[len("sharp"):]
+	case strings.HasPrefix(words, "flat"):
+		pc, rest = pc-1, words[len("flat"):]
+	}
+	k := Key{Tonic: (pc + 12) % 12}
+	switch strings.ToLower(strings.TrimSpace(rest)) {
+	case "", "maj", "major", "dur":
+	case "m", "mi", "min", "minor", "moll":
+		k.Minor = true
+	default:
+		return Key{}, false
+	}
+	return k, true
+}
+
+// FromCamelot returns the key at Camelot position n (wrapping around the
+// wheel), A for minor and B for major.
+func FromCamelot(n int, minor bool) Key {
+	n = ((n-1)%12 + 12) % 12
+	// 8B is C major and each step clockwise is a fifth up; minor keys sit
+	// three semitones below their relative major.
+	tonic := (n - 7) * 7
+	if minor {
+		tonic -= 3
+	}
+	return Key{Tonic: (tonic%12 + 12) % 12, Minor: minor}
+}
+
+// CamelotNumber is the key's position on the Camelot wheel, 1-12.
+func (k Key) CamelotNumber() int {
+	pc := k.Tonic
+	if k.Minor {
+		pc += 3
+	}
+	return (7*pc+7)%12 + 1
+}
+
+func (k Key) Camelot() string {
+	if k.Minor {
+		return strconv.Itoa(k.CamelotNumber()) + "A"
+	}
+	return strconv.Itoa(k.CamelotNumber()) + "B"
+}
+
+func (k Key) OpenKey() string {
+	n := (k.CamelotNumber()+4)%12 + 1
+	if k.Minor {
+		return strconv.Itoa(n) + "m"
+	}
+	return strconv.Itoa(n) + "d"
+}
+
+// Spellings DJ software settles on for each tonic.
+var (
+	majorNames = [12]string{"C", "Db", "D", "Eb", "E", "F", "F#", "G", "Ab", "A", "Bb", "B"}
+	minorNames = [12]string{"Cm", "C#m", "Dm", "Ebm", "Em", "Fm", "F#m", "Gm", "G#m", "Am", "Bbm", "Bm"}
+)
+
+func (k Key) Classical() string {
+	if k.Minor {
+		return minorNames[k.Tonic]
+	}
+	return majorNames[k.Tonic]
+}
+
+// Format writes k in notation n; anything unknown is Camelot.
+func (k Key) Format(n Notation) string {
+	switch n {
+	case Classical:
+		return k.Classical()
+	case OpenKey:
+		return k.OpenKey()
+	}
+	return k.Camelot()
+}
+
+func (k Key) String() string { return k.Classical() }
+
+// MarshalText writes Camelot, the notation mixing tools compare in.
+func (k Key) MarshalText() ([]byte, error) { return []byte(k.Camelot()), nil }
@@ -0,0 +1,101 @@
+package musickey
+
+import "testing"
+
+// wheel is every key in each notation, Camelot order.
+var wheel = []struct {
+	camelot, openKey, classical string
+	key                         Key
+}{
+	{"1A", "6m", "G#m", Key{8, true}}, {"1B", "6d", "B", Key{11, false}},
+	{"2A", "7m", "Ebm", Key{3, true}}, {"2B", "7d", "F#", Key{6, false}},
+	{"3A", "8m", "Bbm", Key{10, true}}, {"3B", "8d", "Db", Key{1, false}},
+	{"4A", "9m", "Fm", Key{5, true}}, {"4B", "9d", "Ab", Key{8, false}},
+	{"5A", "10m", "Cm", Key{0, true}}, {"5B", "10d", "Eb", Key{3, false}},
+	{"6A", "11m", "Gm", Key{7, true}}, {"6B", "11d", "Bb", Key{10, false}},
+	{"7A", "12m", "Dm", Key{2, true}}, {"7B", "12d", "F", Key{5, false}},
+	{"8A", "1m", "Am", Key{9, true}}, {"8B", "1d", "C", Key{0, false}},
+	{"9A", "2m", "Em", Key{4, true}}, {"9B", "2d", "G", Key{7, false}},
+	{"10A", "3m", "Bm", Key{11, true}}, {"10B", "3d", "D", Key{2, false}},
+	{"11A", "4m", "F#m", Key{6, true}}, {"11B", "4d", "A", Key{9, false}},
+	{"12A", "5m", "C#m", Key{1, true}}, {"12B", "5d", "E", Key{4, false}},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, w := range wheel {
+		for _, in := range []string{w.camelot, w.openKey, w.classical} {
+			k, ok := Parse(in)
+			if !ok || k != w.key {
+				t.Errorf("Parse(%q) = %+v, %v; want %+v", in, k, ok, w.key)
+			}
+		}
+		k := w.key
+		if k.Camelot() != w.camelot || k.OpenKey() != w.openKey || k.Classical() != w.classical {
+			t.Errorf("%+v writes %s %s %s, want %s %s %s", k, k.Camelot(), k.OpenKey(), k.Classical(), w.camelot, w.openKey, w.classical)
+		}
+		for n, want := range map[Notation]string{Camelot: w.camelot, OpenKey: w.openKey, Classical: w.classical, "bogus": w.camelot} {
+			if got := k.Format(n); got != want {
+				t.Errorf("%+v.Format(%s) = %q, want %q", k, n, got, want)
+			}
+		}
+		if FromCamelot(k.CamelotNumber(), k.Minor) != k {
+			t.Errorf("FromCamelot(%d, %v) != %+v", k.CamelotNumber(), k.Minor, k)
+		}
+	}
+}
+
+func TestParseSpellings(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		// Enharmonics fold to one key.
+		{"Abm", "1A"}, {"G#m", "1A"}, {"D#m", "2A"}, {"A#m", "3A"}, {"Dbm", "12A"},
+		{"Gb", "2B"}, {"C#", "3B"}, {"G#", "4B"}, {"D#", "5B"}, {"A#", "6B"},
+		{"Cb", "1B"}, {"E#", "7B"}, {"Fb", "12B"}, {"B#m", "5A"},
+		// Spelled out, symbols, case and spacing.
+		{"A minor", "8A"}, {"A-flat minor", "1A"}, {"F sharp major", "2B"}, {"C maj", "8B"},
+		{"E min", "9A"}, {"A moll", "8A"}, {"D dur", "10B"}, {"B♭m", "3A"}, {"F♯", "2B"},
+		{"am", "8A"}, {"  C  ", "8B"},
+		// Camelot and Open Key variants.
+		{"08A", "8A"}, {"8a", "8A"}, {"12 B", "12B"}, {"01m", "8A"}, {"1D", "8B"},
+		// Composites: the first part that parses wins.
+		{"8A - Am", "8A"}, {"Am/8A", "8A"}, {"x - 9B", "9B"}, {"Am (1m)", "8A"}, {"8A|Am", "8A"},
+	} {
+		k, ok := Parse(tc.in)
+		if !ok || k.Camelot() != tc.want {
+			t.Errorf("Parse(%q) = %s, %v; want %s", tc.in, k.Camelot(), ok, tc.want)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, in := range []string{
+		"", "   ", "H", "Hm", "13A", "0A", "8C", "13m", "0d", "12", "m", "#",
+		"BB", "Ax", "A minorish", "C major minor", "x - y", "8A8A",
+	} {
+		if k, ok := Parse(in); ok {
+			t.Errorf("Parse(%q) = %+v, want failure", in, k)
+		}
+	}
+}
+
+func TestFromCamelotWraps(t *testing.T) {
+	for n, want := range map[int]string{13: "1A", 0: "12A", -1: "11A", 25: "1A"} {
+		if got := FromCamelot(n, true).Camelot(); got != want {
+			t.Errorf("FromCamelot(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestParseNotation(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want Notation
+		ok   bool
+	}{
+		{"camelot", Camelot, true}, {"Open_Key", OpenKey, true}, {"CLASSICAL", Classical, true},
+		{"openkey", "", false}, {"", "", false},
+	} {
+		if got, ok := ParseNotation(tc.in); got != tc.want || ok != tc.ok {
+			t.Errorf("ParseNotation(%q) = %q, %v; want %q, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
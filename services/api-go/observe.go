@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// routeLabel carries the matched route pattern for requests answered before
+// chi routes them (e.g. rejected by spec validation).
+type routeLabel struct{ pattern string }
+
+type routeLabelKey struct{}
+
+// setRouteLabel records pattern as the request's route when chi has none.
+func setRouteLabel(ctx context.Context, pattern string) {
+	if l, ok := ctx.Value(routeLabelKey{}).(*routeLabel); ok {
+		l.pattern = pattern
+	}
+}
+
+// routeOf prefers chi's pattern; unmatched requests share one label so
+// arbitrary paths cannot blow up metric cardinality.
+func routeOf(r *http.Request, l *routeLabel) string {
+	if rc := chi.RouteContext(r.Context()); rc != nil {
+		if p := rc.RoutePattern(); p != "" {
+			return p
+		}
+	}
+	if l.pattern != "" {
+		return l.pattern
+	}
+	return "unmatched"
+}
+
+// logFields returns the request and trace IDs to attach to log lines.
+func logFields(ctx context.Context) []zap.Field {
+	fields := []zap.Field{zap.String("request_id", middleware.GetReqID(ctx))}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields = append(fields, zap.String("trace_id", sc.TraceID().String()), zap.String("span_id", sc.SpanID().String()))
+	}
+	return fields
+}
+
+// observeHTTP opens the server span for each request, then records the
+// per-route latency histogram and one structured access log line. Mount it on
+// the root router after RequestID so the route pattern is complete once the
+// handler returns.
+func observeHTTP(logger *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			))
+			defer span.End()
+			l := &routeLabel{}
+			r = r.WithContext(context.WithValue(ctx, routeLabelKey{}, l))
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			httpInFlight.Inc()
+			defer func() {
+				httpInFlight.Dec()
+				status := ww.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+				elapsed := time.Since(start)
+				route := routeOf(r, l)
+				httpRequestDuration.WithLabelValues(r.Method, route, strconv.Itoa(status)).Observe(elapsed.Seconds())
+
+				span.SetName(r.Method + " " + route)
+				span.SetAttributes(semconv.HTTPRoute(route), semconv.HTTPResponseStatusCode(status))
+				if status >= 500 {
+					span.SetStatus(codes.Error, http.StatusText(status))
+				}
+
+				fields := append(logFields(ctx),
+					zap.String("method", r.Method),
+					zap.String("path", r.URL.Path),
+					zap.String("route", route),
+					zap.Int("status", status),
+					zap.Int("bytes", ww.BytesWritten()),
+					zap.Float64("duration_ms", float64(elapsed)/float64(time.Millisecond)),
+					zap.String("remote", r.RemoteAddr),
+				)
+				if status >= 500 {
+					logger.Error("request", fields...)
+				} else {
+					logger.Info("request", fields...)
+				}
+			}()
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
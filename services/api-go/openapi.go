@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/go-chi/chi/v5"
+)
+
+// openapi.yaml is the contract for every route: it is served at
+// /v1/openapi.json, requests are validated against it, and startup fails when
+// a mounted /v1 route is missing from it.
+//
+//go:embed openapi.yaml
+var openapiYAML []byte
+
+func init() {
+	openapi3filter.RegisterBodyDecoder("application/merge-patch+json", openapi3filter.JSONBodyDecoder)
+}
+
+type APISpec struct {
+	doc    *openapi3.T
+	router routers.Router
+	json   []byte
+}
+
+func LoadAPISpec(ctx context.Context) (*APISpec, error) {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(openapiYAML)
+	if err != nil {
+		return nil, fmt.Errorf("openapi: load: %w", err)
+	}
+	if err := doc.Validate(ctx); err != nil {
+		return nil, fmt.Errorf("openapi: invalid document: %w", err)
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("openapi: router: %w", err)
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("openapi: marshal: %w", err)
+	}
+	return &APISpec{doc: doc, router: router, json: raw}, nil
+}
+
+func (s *APISpec) handleJSON(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(s.json)
+}
+
+// Validate rejects requests whose parameters or body do not match the spec.
+// Requests for undocumented routes pass through to the router's 404/405.
+// Security requirements are enforced by Auth, not here.
+func (s *APISpec) Validate(next http.Handler) http.Handler {
+	opts := &openapi3filter.Options{
+		MultiError:         true,
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, params, err := s.router.FindRoute(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		in := &openapi3filter.RequestValidationInput{Request: r, PathParams: params, Route: route, Options: opts}
+		if err := openapi3filter.ValidateRequest(r.Context(), in); err != nil {
+			setRouteLabel(r.Context(), route.Path)
+			writeProblem(w, r, specProblem(err))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Undocumented lists mounted /v1 routes that have no operation in the spec.
+// 503 catch-alls of unavailable services ("/*") are ignored.
+func (s *APISpec) Undocumented(routes chi.Routes) []string {
+	var missing []string
+	chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if !strings.HasPrefix(route, "/v1/") || strings.HasSuffix(route, "*") {
+			return nil
+		}
+		item := s.doc.Paths.Find(route)
+		if item == nil || item.GetOperation(method) == nil {
+			missing = append(missing, method+" "+route)
+		}
+		return nil
+	})
+	sort.Strings(missing)
+	return missing
+}
+
+// specProblem converts validation errors into a problem with one FieldError
+// per violation. Unparseable JSON bodies keep the invalid_json code and a
+// non-JSON Content-Type is a 415.
+func specProblem(err error) *Problem {
+	var fields []FieldError
+	var walk func(err error, prefix string)
+	walk = func(err error, prefix string) {
+		switch e := err.(type) {
+		case openapi3.MultiError:
+			for _, inner := range e {
+				walk(inner, prefix)
+			}
+		case *openapi3filter.RequestError:
+			field := prefix
+			if e.Parameter != nil {
+				field = e.Parameter.Name
+			}
+			if e.Err == nil {
+				fields = append(fields, FieldError{Field: fieldOrBody(field), Code: "invalid", Message: e.Reason})
+				return
+			}
+			walk(e.Err, field)
+		case *openapi3.SchemaError:
+			ptr, code := e.JSONPointer(), schemaCode(e.SchemaField)
+			// Unknown members are reported against their parent; name the member itself.
+			var name string
+			if n, _ := fmt.Sscanf(e.Reason, "property %q is unsupported", &name); n == 1 {
+				ptr, code = append(ptr, name), "unknown_field"
+			}
+			fields = append(fields, FieldError{Field: fieldOrBody(jsonPointerField(prefix, ptr)), Code: code, Message: e.Reason})
+		default:
+			code := "invalid"
+			if errors.Is(err, openapi3filter.ErrInvalidRequired) {
+				code = "required"
+			}
+			fields = append(fields, FieldError{Field: fieldOrBody(prefix), Code: code, Message: err.Error()})
+		}
+	}
+	var parseErr *openapi3filter.ParseError
+	var reqErr *openapi3filter.RequestError
+	if errors.As(err, &reqErr) && reqErr.RequestBody != nil {
+		if errors.As(err, &parseErr) && parseErr.Kind == openapi3filter.KindUnsupportedFormat {
+			return newProblem(http.StatusUnsupportedMediaType, CodeUnsupportedMedia, parseErr.Error())
+		}
+		if errors.As(err, &parseErr) {
+			return newProblem(http.StatusBadRequest, CodeInvalidJSON, "request body is not valid JSON: "+parseErr.Error())
+		}
+		if reqErr.Err == nil && strings.HasPrefix(reqErr.Reason, "header Content-Type") {
+			return newProblem(http.StatusUnsupportedMediaType, CodeUnsupportedMedia, "request body must be application/json")
+		}
+	}
+	walk(err, "")
+	return validationProblem(fields...)
+}
+
+func fieldOrBody(field string) string {
+	if field == "" {
+		return "body"
+	}
+	return field
+}
+
+// jsonPointerField renders ["2","entity_id"] as "[2].entity_id".
+func jsonPointerField(prefix string, ptr []string) string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	for _, p := range ptr {
+		if _, err := strconv.Atoi(p); err == nil {
+			b.WriteString("[" + p + "]")
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(p)
+	}
+	return b.String()
+}
+
+func schemaCode(keyword string) string {
+	switch keyword {
+	case "required":
+		return "required"
+	case "type", "nullable":
+		return "invalid_type"
+	case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum":
+		return "out_of_range"
+	case "minLength", "maxLength", "minItems", "maxItems":
+		return "invalid_length"
+	case "enum":
+		return "invalid_value"
+	case "format", "pattern":
+		return "invalid_format"
+	default:
+		return "invalid"
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+// Error responses follow RFC 7807 (application/problem+json). Code is the
+// stable machine-readable identifier clients should branch on; Type is a
+// relative URI derived from it.
+
+const problemContentType = "application/problem+json"
+
+// Stable problem codes.
+const (
+	CodeInvalidJSON        = "invalid_json"
+	CodeValidation         = "validation_failed"
+	CodeUnsupportedMedia   = "unsupported_media_type"
+	CodeUnauthorized       = "unauthorized"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodePreconditionFailed = "precondition_failed"
+	CodePreconditionReq    = "precondition_required"
+	CodeUnprocessable      = "unprocessable"
+	CodeServiceUnavailable = "service_unavailable"
+	CodeUpstream           = "upstream_failed"
+	CodeTimeout            = "timeout"
+	CodeInternal           = "internal_error"
+)
+
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Code      string       `json:"code"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError pinpoints one invalid input; Field is a JSON pointer-ish path
+// ("position_ms", "[2].entity_id") or a query parameter name.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Code + ": " + p.Detail
+	}
+	return p.Code
+}
+
+func newProblem(status int, code, detail string) *Problem {
+	return &Problem{Status: status, Code: code, Detail: detail}
+}
+
+// validationProblem reports field-level input errors as a 400.
+func validationProblem(fields ...FieldError) *Problem {
+	return &Problem{Status: http.StatusBadRequest, Code: CodeValidation, Detail: "request validation failed", Errors: fields}
+}
+
+// UpstreamError marks a failure of an external HTTP dependency (502).
+type UpstreamError struct {
+	Service string
+	Err     error
+}
+
+func (e *UpstreamError) Error() string { return e.Service + ": " + e.Err.Error() }
+func (e *UpstreamError) Unwrap() error { return e.Err }
+
+// decodeJSON decodes the request body into dst, reporting malformed input as a problem.
+func decodeJSON(r *http.Request, dst any) error {
+	if err := json.NewDecoder(r.Body).Decode(dst); err != nil {
+		return newProblem(http.StatusBadRequest, CodeInvalidJSON, "request body is not valid JSON: "+err.Error())
+	}
+	return nil
+}
+
+// problemFor maps an error to its response. Unexpected errors become an opaque
+// 500; the caller logs the cause.
+func problemFor(r *http.Request, err error) (p *Problem, unexpected bool) {
+	var prob *Problem
+	var pgErr *pgconn.PgError
+	var upErr *UpstreamError
+	switch {
+	case errors.As(err, &prob):
+		return prob, false
+	case errors.Is(err, ErrNotFound), errors.Is(err, pgx.ErrNoRows):
+		return newProblem(http.StatusNotFound, CodeNotFound, "resource not found"), false
+	case errors.Is(err, ErrConflict):
+		return newProblem(http.StatusConflict, CodeConflict, "resource already exists"), false
+	case errors.Is(err, ErrOtherTrack):
+		return newProblem(http.StatusConflict, CodeConflict, err.Error()), false
+	case errors.Is(err, ErrVersionMismatch):
+		return newProblem(http.StatusPreconditionFailed, CodePreconditionFailed, "resource was modified; fetch it again and retry with the new ETag"), false
+	case errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "23"):
+		return constraintProblem(r, pgErr), false
+	case errors.As(err, &upErr):
+		return newProblem(http.StatusBadGateway, CodeUpstream, upErr.Service+" request failed"), true
+	case errors.Is(err, ErrShuttingDown):
+		return newProblem(http.StatusServiceUnavailable, CodeServiceUnavailable, "server is shutting down"), false
+	case errors.Is(err, context.DeadlineExceeded):
+		return newProblem(http.StatusGatewayTimeout, CodeTimeout, "operation timed out"), true
+	default:
+		return newProblem(http.StatusInternalServerError, CodeInternal, "internal error"), true
+	}
+}
+
+// fkMissing parses the detail of a foreign key violation on insert or
+// update: Key (track_id)=(abc) is not present in table "tracks".
+var fkMissing = regexp.MustCompile(`^Key \((.+)\)=\((.*)\) is not present in table "(.+)"`)
+
+// constraintProblem maps a class 23 integrity violation. Unique and
+// exclusion violations are conflicts (409); a missing not-null value is a
+// validation error (400) and a failed check unprocessable (422). A foreign
+// key naming an entity that does not exist is a 404 when r addressed that
+// entity in its path, else 422; deleting a row still referenced is 422.
+func constraintProblem(r *http.Request, pgErr *pgconn.PgError) *Problem {
+	detail := "constraint violation"
+	if pgErr.ConstraintName != "" {
+		detail = fmt.Sprintf("constraint %s violated", pgErr.ConstraintName)
+	}
+	switch pgErr.Code {
+	case "23505", "23P01":
+		return newProblem(http.StatusConflict, CodeConflict, detail)
+	case "23502":
+		return validationProblem(FieldError{Field: pgErr.ColumnName, Code: "required", Message: pgErr.ColumnName + " is required"})
+	case "23503":
+		m := fkMissing.FindStringSubmatch(pgErr.Detail)
+		if m == nil {
+			return newProblem(http.StatusUnprocessableEntity, CodeUnprocessable, detail)
+		}
+		if rc := chi.RouteContext(r.Context()); rc != nil && slices.Contains(rc.URLParams.Values, m[2]) {
+			return newProblem(http.StatusNotFound, CodeNotFound, "resource not found")
+		}
+		return newProblem(http.StatusUnprocessableEntity, CodeUnprocessable, fmt.Sprintf("%s %s does not exist in %s", m[1], m[2], m[3]))
+	default: // 23514 check, 23001 restrict and the rest
+		return newProblem(http.StatusUnprocessableEntity, CodeUnprocessable, detail)
+	}
+}
+
+// writeError maps err to a problem response, logging causes that are not surfaced.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	p, unexpected := problemFor(r, err)
+	if unexpected {
+		zap.L().Error("request failed", append(logFields(r.Context()),
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", p.Status),
+			zap.Error(err))...)
+	}
+	writeProblem(w, r, p)
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	out := *p
+	if out.Type == "" {
+		out.Type = "/problems/" + out.Code
+	}
+	if out.Title == "" {
+		out.Title = http.StatusText(out.Status)
+	}
+	out.Instance = r.URL.Path
+	out.RequestID = middleware.GetReqID(r.Context())
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(out.Status)
+	json.NewEncoder(w).Encode(out)
+}
+
+// recoverProblem turns handler panics into a logged 500 problem response.
+func recoverProblem(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				zap.L().Error("panic", append(logFields(r.Context()),
+					zap.Any("panic", rec),
+					zap.Stack("stack"))...)
+				writeProblem(w, r, newProblem(http.StatusInternalServerError, CodeInternal, "internal error"))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, newProblem(http.StatusNotFound, CodeNotFound, "no route for "+r.Method+" "+r.URL.Path))
+}
+
+func handleMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, newProblem(http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" not allowed on "+r.URL.Path))
+}
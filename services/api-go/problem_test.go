@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestConstraintProblem(t *testing.T) {
+	missingTrack := `Key (track_id)=(t1) is not present in table "tracks".`
+	for _, tc := range []struct {
+		name   string
+		err    pgconn.PgError
+		param  string // the request's {id}
+		status int
+		code   string
+	}{
+		{"unique", pgconn.PgError{Code: "23505", ConstraintName: "uq_cues_track_slot"}, "", 409, CodeConflict},
+		{"exclusion", pgconn.PgError{Code: "23P01"}, "", 409, CodeConflict},
+		{"not null", pgconn.PgError{Code: "23502", ColumnName: "title"}, "", 400, CodeValidation},
+		{"check", pgconn.PgError{Code: "23514", ConstraintName: "loops_length_check"}, "", 422, CodeUnprocessable},
+		{"fk in body", pgconn.PgError{Code: "23503", Detail: missingTrack}, "c1", 422, CodeUnprocessable},
+		{"fk in path", pgconn.PgError{Code: "23503", Detail: missingTrack}, "t1", 404, CodeNotFound},
+		{"fk still referenced", pgconn.PgError{Code: "23503", Detail: `Key (id)=(t1) is still referenced from table "cues".`}, "t1", 422, CodeUnprocessable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/", nil)
+			if tc.param != "" {
+				rc := chi.NewRouteContext()
+				rc.URLParams.Add("id", tc.param)
+				r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rc))
+			}
+			p, unexpected := problemFor(r, &tc.err)
+			if unexpected || p.Status != tc.status || p.Code != tc.code {
+				t.Errorf("got %d %s (unexpected=%v), want %d %s", p.Status, p.Code, unexpected, tc.status, tc.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+
+	"meta-dj/services/api-go/beatgrid"
+)
+
+// quantizer snaps write positions to track beatgrids for ?quantize=beat|bar.
+type quantizer struct {
+	grids BeatgridStore
+	unit  beatgrid.Unit // "" leaves positions alone
+	cache map[string]beatgrid.Grid
+}
+
+func newQuantizer(r *http.Request, grids BeatgridStore) (*quantizer, error) {
+	q := &quantizer{grids: grids, cache: map[string]beatgrid.Grid{}}
+	v := r.URL.Query().Get("quantize")
+	if v == "" {
+		return q, nil
+	}
+	u, ok := beatgrid.ParseUnit(v)
+	if !ok {
+		return nil, validationProblem(FieldError{Field: "quantize", Code: "invalid_value", Message: "quantize must be beat or bar"})
+	}
+	q.unit = u
+	return q, nil
+}
+
+// snap returns ms on the nearest beat or bar of the track's grid; a track
+// without one cannot be quantized (422).
+func (q *quantizer) snap(ctx context.Context, trackID string, ms int64) (int64, error) {
+	if q.unit == "" {
+		return ms, nil
+	}
+	g, ok := q.cache[trackID]
+	if !ok {
+		row, err := q.grids.Get(ctx, trackID)
+		if errors.Is(err, ErrNotFound) {
+			return 0, newProblem(http.StatusUnprocessableEntity, CodeUnprocessable, "track "+trackID+" has no beatgrid to quantize to")
+		}
+		if err != nil {
+			return 0, err
+		}
+		g, q.cache[trackID] = row.Grid, row.Grid
+	}
+	return max(0, int64(math.Round(g.Snap(float64(ms), q.unit)))), nil
+}
@@ -6,7 +6,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 // Minimal Supabase Storage client using REST API.
@@ -19,31 +22,51 @@ type SupabaseStorage struct {
 	client  *http.Client
 }
 
-func NewSupabaseStorage() *SupabaseStorage {
+func NewSupabaseStorage(cfg StorageConfig) *SupabaseStorage {
 	return &SupabaseStorage{
-		baseURL: os.Getenv("SUPABASE_URL"),
-		key:     os.Getenv("SUPABASE_SERVICE_ROLE_KEY"),
-		bucket:  os.Getenv("SUPABASE_STORAGE_BUCKET"),
-		client:  &http.Client{},
+		baseURL: strings.TrimRight(cfg.SupabaseURL, "/"),
+		key:     cfg.ServiceRoleKey,
+		bucket:  cfg.Bucket,
+		client:  &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)},
 	}
 }
 
-func (s *SupabaseStorage) Upload(ctx context.Context, path string, data []byte, contentType string) error {
+func (s *SupabaseStorage) Upload(ctx context.Context, path string, data []byte, contentType string) (err error) {
+	defer observeStorage("upload", time.Now(), &err)
 	req, _ := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/storage/v1/object/%s/%s", s.baseURL, s.bucket, path), bytes.NewReader(data))
 	req.Header.Set("Authorization", "Bearer "+s.key)
 	req.Header.Set("Content-Type", contentType)
 	res, err := s.client.Do(req)
+	if err != nil {
+		return &UpstreamError{Service: "storage", Err: err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return &UpstreamError{Service: "storage", Err: fmt.Errorf("upload failed: %s", res.Status)}
+	}
+	return nil
+}
+
+// Ping checks that the bucket exists and the service key is accepted.
+func (s *SupabaseStorage) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/storage/v1/bucket/%s", s.baseURL, s.bucket), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.key)
+	res, err := s.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 	if res.StatusCode >= 300 {
-		return fmt.Errorf("upload failed: %s", res.Status)
+		return fmt.Errorf("bucket %s: %s", s.bucket, res.Status)
 	}
 	return nil
 }
 
-func (s *SupabaseStorage) SignURL(ctx context.Context, path string, expiresIn int) (string, error) {
+func (s *SupabaseStorage) SignURL(ctx context.Context, path string, expiresIn int) (_ string, err error) {
+	defer observeStorage("sign", time.Now(), &err)
 	body := map[string]any{"expiresIn": expiresIn}
 	b, _ := json.Marshal(body)
 	req, _ := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/storage/v1/object/sign/%s/%s", s.baseURL, s.bucket, path), bytes.NewReader(b))
@@ -51,20 +74,20 @@ func (s *SupabaseStorage) SignURL(ctx context.Context, path string, expiresIn in
 	req.Header.Set("Content-Type", "application/json")
 	res, err := s.client.Do(req)
 	if err != nil {
-		return "", err
+		return "", &UpstreamError{Service: "storage", Err: err}
 	}
 	defer res.Body.Close()
 	if res.StatusCode >= 300 {
-		return "", fmt.Errorf("sign failed: %s", res.Status)
+		return "", &UpstreamError{Service: "storage", Err: fmt.Errorf("sign failed: %s", res.Status)}
 	}
 	var out struct {
 		SignedURL string `json:"signedURL"`
 	}
 	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
-		return "", err
+		return "", &UpstreamError{Service: "storage", Err: err}
 	}
 	if out.SignedURL == "" {
-		return "", fmt.Errorf("empty signed url")
+		return "", &UpstreamError{Service: "storage", Err: fmt.Errorf("empty signed url")}
 	}
 	// The returned signedURL is a path; prepend base
 	return s.baseURL + out.SignedURL, nil
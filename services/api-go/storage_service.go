@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"encoding/base64"
 	"encoding/json"
 	"net/http"
@@ -11,8 +10,8 @@ import (
 
 type StorageService struct{ store *SupabaseStorage }
 
-func NewStorageService() *StorageService {
-	return &StorageService{store: NewSupabaseStorage()}
+func NewStorageService(store *SupabaseStorage) *StorageService {
+	return &StorageService{store: store}
 }
 
 func (s *StorageService) ProtectedRoutes(r chi.Router) {
@@ -25,8 +24,12 @@ func (s *StorageService) handleSign(w http.ResponseWriter, r *http.Request) {
 		Path      string `json:"path"`
 		ExpiresIn int    `json:"expiresIn"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Path == "" {
-		http.Error(w, "bad json", http.StatusBadRequest)
+	if err := decodeJSON(r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if body.Path == "" {
+		writeProblem(w, r, validationProblem(FieldError{Field: "path", Code: "required", Message: "path is required"}))
 		return
 	}
 	if body.ExpiresIn <= 0 {
@@ -34,7 +37,7 @@ func (s *StorageService) handleSign(w http.ResponseWriter, r *http.Request) {
 	}
 	url, err := s.store.SignURL(r.Context(), body.Path, body.ExpiresIn)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -47,17 +50,28 @@ func (s *StorageService) handleUpload(w http.ResponseWriter, r *http.Request) {
 		Data        string `json:"dataBase64"`
 		ContentType string `json:"contentType"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Path == "" || body.Data == "" {
-		http.Error(w, "bad json", http.StatusBadRequest)
+	if err := decodeJSON(r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var fields []FieldError
+	if body.Path == "" {
+		fields = append(fields, FieldError{Field: "path", Code: "required", Message: "path is required"})
+	}
+	if body.Data == "" {
+		fields = append(fields, FieldError{Field: "dataBase64", Code: "required", Message: "dataBase64 is required"})
+	}
+	if len(fields) > 0 {
+		writeProblem(w, r, validationProblem(fields...))
 		return
 	}
 	b, err := base64.StdEncoding.DecodeString(body.Data)
 	if err != nil {
-		http.Error(w, "invalid base64", http.StatusBadRequest)
+		writeProblem(w, r, validationProblem(FieldError{Field: "dataBase64", Code: "invalid_base64", Message: "dataBase64 is not valid base64"}))
 		return
 	}
-	if err := s.store.Upload(context.Background(), body.Path, b, body.ContentType); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := s.store.Upload(r.Context(), body.Path, b, body.ContentType); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"meta-dj/services/api-go/beatgrid"
+	"meta-dj/services/api-go/mixing"
+	"meta-dj/services/api-go/musickey"
+)
+
+// Storage contracts the HTTP services depend on. Postgres implementations live
+// in *_store_pg.go, thread-safe in-memory ones in *_store_mem.go / sync.go.
+
+var (
+	// ErrNotFound is returned by stores when the requested entity does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when creating an entity whose id is taken.
+	ErrConflict = errors.New("already exists")
+	// ErrVersionMismatch is returned by conditional writes whose expected
+	// version is no longer current.
+	ErrVersionMismatch = errors.New("version mismatch")
+	// ErrOtherTrack is returned when writing a cue or loop under an id that
+	// belongs to another track; wrapped with the id.
+	ErrOtherTrack = errors.New("belongs to another track")
+)
+
+// TrackQuery selects a page of tracks and what to load for each. Tracks
+// are ordered by Sort (default title), then id.
+type TrackQuery struct {
+	// Q is a full-text search (prefix matching, accent-insensitive).
+	Q string
+	// Fuzzy, when above zero, also matches Q against titles and artist names
+	// whose trigram word similarity to it is at least Fuzzy (0-1).
+	Fuzzy  float64
+	Folder string
+	Filter []TrackCond
+	Sort   []TrackSort
+	// Highlight adds a snippet marking the matches of Q.
+	Highlight bool
+	Limit     int
+	Offset    int
+	// After or Before switches to keyset paging: rows strictly after (or
+	// before) this TrackRow.SortKey, returned in sort order. Offset is ignored.
+	After  []any
+	Before []any
+	// Mix ranks tracks by how well they mix out of a seed, best first and
+	// ahead of Sort. It only ranks: tracks Score rejects sort last.
+	Mix *MixQuery
+	// Exclude drops these track ids.
+	Exclude []string
+	TrackSelect
+}
+
+// FileStat is what an import scan compares to tell a file is unchanged.
+type FileStat struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// ScannedFile is a track file as an import scan found it.
+type ScannedFile struct {
+	ID, Title, Path string
+	ContentHash     string
+	FileStat
+}
+
+// MixQuery is the seed of a mixing.Score ranking.
+type MixQuery struct {
+	Key musickey.Key
+	Bpm float64
+	mixing.Options
+}
+
+type TrackStore interface {
+	List(ctx context.Context, tq TrackQuery) ([]TrackRow, error)
+	// Count counts the tracks tq matches, ignoring paging. Without exact a
+	// store may answer from planner statistics; estimated reports that.
+	Count(ctx context.Context, tq TrackQuery, exact bool) (n int64, estimated bool, err error)
+	Get(ctx context.Context, id string) (*TrackRow, error)
+	// Find is Get narrowed to sel. Stores may load more than sel asks for;
+	// responses are projected by renderTrack.
+	Find(ctx context.Context, id string, sel TrackSelect) (*TrackRow, error)
+	// UpsertScanned inserts or refreshes a track discovered by an import
+	// scan. An empty ContentHash keeps the recorded one.
+	UpsertScanned(ctx context.Context, f ScannedFile) error
+	// FileStats returns the stat the last scan recorded for each hashed
+	// track under folder, by file path.
+	FileStats(ctx context.Context, folder string) (map[string]FileStat, error)
+	// Create inserts t at version 1; ErrConflict if the id exists.
+	Create(ctx context.Context, t TrackRow) (*TrackRow, error)
+	// Update replaces the editable fields of t.ID and bumps its version. A
+	// non-zero version must match the stored one (ErrVersionMismatch).
+	Update(ctx context.Context, t TrackRow, version int64) (*TrackRow, error)
+	// Delete removes the track with its cues and loops, with the same
+	// version check.
+	Delete(ctx context.Context, id string, version int64) error
+	SetBpmOverride(ctx context.Context, id string, bpm float64, version int64) (*TrackRow, error)
+	// Suggest returns up to limit titles and artist names resembling q with
+	// a trigram word similarity of at least threshold, closest first.
+	Suggest(ctx context.Context, q string, threshold float64, limit int) ([]TrackSuggestion, error)
+	// Duplicates groups tracks that look like copies of one recording, by
+	// each of the criteria in by (dupByHash, dupByMetadata, dupByAudio).
+	Duplicates(ctx context.Context, by []string) ([]DuplicateGroup, error)
+	// Merge folds duplicates into survivor in one transaction: their cues,
+	// loops, tags and playlist entries move over and they are deleted. A
+	// non-zero version must match the survivor's.
+	Merge(ctx context.Context, survivor string, duplicates []string, version int64) (*MergeResult, error)
+}
+
+// MergeResult is the survivor of a merge and what moving the cues gave up.
+type MergeResult struct {
+	Track *TrackRow `json:"track"`
+	// DroppedCues had the type of a survivor cue within 50ms of it.
+	DroppedCues int64 `json:"dropped_cues"`
+	// ReassignedSlots counts cues that moved without their hot cue slot,
+	// held by the survivor or a lower cue id.
+	ReassignedSlots int64 `json:"reassigned_slots"`
+}
+
+// DuplicateGroup is a set of tracks one criterion considers the same recording.
+type DuplicateGroup struct {
+	By     string     `json:"by"`
+	Key    string     `json:"key"` // what the tracks share
+	Tracks []TrackRow `json:"tracks"`
+}
+
+// TrackSuggestion is a "did you mean" candidate for a search.
+type TrackSuggestion struct {
+	Text       string  `json:"text"`
+	Kind       string  `json:"kind"` // title or artist
+	Similarity float64 `json:"similarity"`
+}
+
+type CueStore interface {
+	ListByTrack(ctx context.Context, trackID string) ([]CueRow, error)
+	Upsert(ctx context.Context, cue CueRow) error
+	// UpsertMany writes cues, across any tracks, in one transaction.
+	UpsertMany(ctx context.Context, cues []CueRow) error
+	// ReplaceTrack makes cues the track's whole cue set in one transaction.
+	ReplaceTrack(ctx context.Context, trackID string, cues []CueRow) error
+	// ReplaceAutogen swaps the track's autogen cues for cues in one
+	// transaction, leaving the user's alone.
+	ReplaceAutogen(ctx context.Context, trackID string, cues []CueRow) error
+	Delete(ctx context.Context, id string) error
+}
+
+type LoopStore interface {
+	ListByTrack(ctx context.Context, trackID string) ([]LoopRow, error)
+	Upsert(ctx context.Context, loop LoopRow) error
+	// ReplaceAutogen swaps the track's autogen loops for loops in one
+	// transaction.
+	ReplaceAutogen(ctx context.Context, trackID string, loops []LoopRow) error
+	Delete(ctx context.Context, id string) error
+}
+
+type BeatgridStore interface {
+	// Get returns the track's edited beatgrid, else the one in its latest
+	// analysis (Source "analysis", Version 0); ErrNotFound if neither exists.
+	Get(ctx context.Context, trackID string) (*BeatgridRow, error)
+	// Put saves an edited beatgrid. A non-zero version must match the
+	// stored grid's; ErrVersionMismatch otherwise.
+	Put(ctx context.Context, trackID string, g beatgrid.Grid, version int64) (*BeatgridRow, error)
+	// Insert saves the track's first edited beatgrid; ErrVersionMismatch
+	// if it has one already.
+	Insert(ctx context.Context, trackID string, g beatgrid.Grid) (*BeatgridRow, error)
+	// Delete drops the edited grid, so the analysis one applies again.
+	Delete(ctx context.Context, trackID string) error
+}
+
+// BeatgridRow is a track's beatgrid and where it came from.
+type BeatgridRow struct {
+	TrackID string `json:"track_id"`
+	beatgrid.Grid
+	Source    string     `json:"source"` // "edited" or "analysis"
+	Version   int64      `json:"version"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+type ChangeStore interface {
+	Append(ctx context.Context, changes []Change) (int, error)
+	Since(ctx context.Context, since string) ([]Change, error)
+}
+
+var (
+	_ TrackStore    = (*PgTrackStore)(nil)
+	_ TrackStore    = (*MemTrackStore)(nil)
+	_ CueStore      = (*PgCueStore)(nil)
+	_ CueStore      = (*MemCueStore)(nil)
+	_ LoopStore     = (*PgLoopStore)(nil)
+	_ LoopStore     = (*MemLoopStore)(nil)
+	_ BeatgridStore = (*PgBeatgridStore)(nil)
+	_ BeatgridStore = (*MemBeatgridStore)(nil)
+	_ ChangeStore   = (*PgChangeStore)(nil)
+	_ ChangeStore   = (*MemChangeStore)(nil)
+)
@@ -1,8 +1,7 @@
 package main
 
 import (
-	"encoding/json"
-	"net/http"
+	"context"
 	"sync"
 	"time"
 )
@@ -18,12 +17,15 @@ type Change struct {
 	TS           string `json:"ts"`
 }
 
-type ChangeStore struct {
+// MemChangeStore keeps the change journal in memory (no DATABASE_URL).
+type MemChangeStore struct {
 	mu      sync.RWMutex
 	changes []Change
 }
 
-func (s *ChangeStore) Append(changes []Change) int {
+func NewMemChangeStore() *MemChangeStore { return &MemChangeStore{} }
+
+func (s *MemChangeStore) Append(ctx context.Context, changes []Change) (int, error) {
 	now := time.Now().UTC().Format(time.RFC3339)
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -43,17 +45,17 @@ func (s *ChangeStore) Append(changes []Change) int {
 		}
 		s.changes = append(s.changes, c)
 	}
-	return len(changes)
+	return len(changes), nil
 }
 
-func (s *ChangeStore) Since(since string) []Change {
+func (s *MemChangeStore) Since(ctx context.Context, since string) ([]Change, error) {
 	if since == "" {
 		s.mu.RLock()
 		defer s.mu.RUnlock()
 		// return copy
 		out := make([]Change, len(s.changes))
 		copy(out, s.changes)
-		return out
+		return out, nil
 	}
 	// parse since; if invalid, return all
 	t, err := time.Parse(time.RFC3339, since)
@@ -62,7 +64,7 @@ func (s *ChangeStore) Since(since string) []Change {
 	if err != nil {
 		out := make([]Change, len(s.changes))
 		copy(out, s.changes)
-		return out
+		return out, nil
 	}
 	out := make([]Change, 0, len(s.changes))
 	for _, c := range s.changes {
@@ -75,27 +77,5 @@ func (s *ChangeStore) Since(since string) []Change {
 			out = append(out, c)
 		}
 	}
-	return out
-}
-
-var globalChanges = &ChangeStore{}
-
-func handleGetChanges(w http.ResponseWriter, r *http.Request) {
-	since := r.URL.Query().Get("since")
-	changes := globalChanges.Since(since)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(changes)
-}
-
-func handlePostChanges(w http.ResponseWriter, r *http.Request) {
-	var payload []Change
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(&payload); err != nil {
-		http.Error(w, "invalid json", http.StatusBadRequest)
-		return
-	}
-	n := globalChanges.Append(payload)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusAccepted)
-	json.NewEncoder(w).Encode(map[string]any{"status": "ok", "received": n})
+	return out, nil
 }
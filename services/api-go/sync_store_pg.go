@@ -5,41 +5,15 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type PgChangeStore struct {
-	pool *pgx.Conn
+	pool *pgxpool.Pool
 }
 
-func NewPgChangeStore(ctx context.Context, dsn string) (*PgChangeStore, error) {
-	conn, err := pgx.Connect(ctx, dsn)
-	if err != nil {
-		return nil, err
-	}
-	s := &PgChangeStore{pool: conn}
-	if err := s.init(ctx); err != nil {
-		conn.Close(ctx)
-		return nil, err
-	}
-	return s, nil
-}
-
-func (s *PgChangeStore) init(ctx context.Context) error {
-	_, err := s.pool.Exec(ctx, `
-CREATE TABLE IF NOT EXISTS sync_changes (
-  id SERIAL PRIMARY KEY,
-  entity_type TEXT NOT NULL,
-  entity_id TEXT NOT NULL,
-  field TEXT NOT NULL,
-  value_hash TEXT NOT NULL,
-  device_id TEXT NOT NULL,
-  lamport_clock BIGINT NOT NULL,
-  vector_clock TEXT NOT NULL,
-  ts TIMESTAMPTZ NOT NULL
-);
-CREATE INDEX IF NOT EXISTS idx_sync_changes_ts ON sync_changes(ts);
-`)
-	return err
+func NewPgChangeStore(pool *pgxpool.Pool) *PgChangeStore {
+	return &PgChangeStore{pool: pool}
 }
 
 func (s *PgChangeStore) Append(ctx context.Context, changes []Change) (int, error) {
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"meta-dj/services/api-go/beatgrid"
+)
+
+func writeBeatgrid(w http.ResponseWriter, row *BeatgridRow) {
+	if row.Version > 0 {
+		w.Header().Set("ETag", etagFor(row.Version))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(row)
+}
+
+func beatgridProblem(err error) error {
+	return validationProblem(FieldError{Field: "markers", Code: "invalid_value", Message: err.Error()})
+}
+
+func (s *TracksService) handleGetBeatgrid(w http.ResponseWriter, r *http.Request) {
+	row, err := s.Grids.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeBeatgrid(w, row)
+}
+
+// handlePutBeatgrid stores a whole edited grid: the anchor and any
+// tempo-change markers. If-Match is optional.
+func (s *TracksService) handlePutBeatgrid(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var g beatgrid.Grid
+	if err := decodeJSON(r, &g); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if g.BeatsPerBar == 0 {
+		g.BeatsPerBar = 4
+	}
+	if err := g.Validate(); err != nil {
+		writeError(w, r, beatgridProblem(err))
+		return
+	}
+	version, _, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if _, err := s.Store.Get(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	row, err := s.Grids.Put(r.Context(), id, g, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeBeatgrid(w, row)
+}
+
+// beatgridEdit is a PATCH body; edits apply in field order.
+type beatgridEdit struct {
+	// AnchorMs moves the first beat, and the whole grid with it.
+	AnchorMs *float64 `json:"anchor_ms"`
+	// Bpm sets one tempo from the anchor, dropping tempo changes.
+	Bpm         *float64          `json:"bpm"`
+	BeatsPerBar *int              `json:"beats_per_bar"`
+	AddMarkers  []beatgrid.Marker `json:"add_markers"`
+}
+
+// handlePatchBeatgrid edits the current grid, edited or from analysis, and
+// saves the result as the edited grid.
+func (s *TracksService) handlePatchBeatgrid(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var edit beatgridEdit
+	if err := decodeJSON(r, &edit); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version, present, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	cur, err := s.Grids.Get(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if present && version != 0 && cur.Version != version {
+		writeError(w, r, ErrVersionMismatch)
+		return
+	}
+	g := cur.Grid
+	if edit.AnchorMs != nil {
+		g = g.Shift(*edit.AnchorMs)
+	}
+	if edit.Bpm != nil {
+		g = g.WithTempo(*edit.Bpm)
+	}
+	if edit.BeatsPerBar != nil {
+		g.BeatsPerBar = *edit.BeatsPerBar
+	}
+	for _, m := range edit.AddMarkers {
+		g = g.WithMarker(m)
+	}
+	if err := g.Validate(); err != nil {
+		writeError(w, r, beatgridProblem(err))
+		return
+	}
+	// Guard with the version edited so a concurrent write in between is a
+	// 412. A grid from analysis has none: only the first edit may save.
+	var row *BeatgridRow
+	if cur.Version == 0 {
+		row, err = s.Grids.Insert(r.Context(), id, g)
+	} else {
+		row, err = s.Grids.Put(r.Context(), id, g, cur.Version)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeBeatgrid(w, row)
+}
+
+func (s *TracksService) handleDeleteBeatgrid(w http.ResponseWriter, r *http.Request) {
+	if err := s.Grids.Delete(r.Context(), chi.URLParam(r, "id")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"meta-dj/services/api-go/beatgrid"
+)
+
+// analysisGrids always reads the analysis grid, as a PATCH does when another
+// edit is saved between its read and its write.
+type analysisGrids struct {
+	*MemBeatgridStore
+}
+
+func (s analysisGrids) Get(ctx context.Context, trackID string) (*BeatgridRow, error) {
+	return &BeatgridRow{TrackID: trackID, Grid: beatgrid.Grid{BeatsPerBar: 4, Markers: []beatgrid.Marker{{PositionMs: 0, Bpm: 120}}}, Source: "analysis"}, nil
+}
+
+func TestPatchAnalysisBeatgrid(t *testing.T) {
+	grids := analysisGrids{NewMemBeatgridStore()}
+	cfg := defaultConfig()
+	svc := NewTracksService(NewMemTrackStore(NewMemCueStore(), NewMemLoopStore(), grids.MemBeatgridStore), grids, cfg.Search, cfg.Mixing, cfg.Keys)
+	r := chi.NewRouter()
+	r.Route("/v1/tracks", svc.ProtectedRoutes)
+	patch := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/v1/tracks/t1/beatgrid", strings.NewReader(body)))
+		return w
+	}
+
+	w := patch(`{"bpm":124}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("first PATCH = %d %s", w.Code, w.Body)
+	}
+	var row BeatgridRow
+	if err := json.Unmarshal(w.Body.Bytes(), &row); err != nil || row.Version != 1 || row.Markers[0].Bpm != 124 {
+		t.Fatalf("first PATCH = %s, want version 1 at 124 BPM", w.Body)
+	}
+
+	if w := patch(`{"bpm":126}`); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PATCH over an edit saved meanwhile = %d %s, want 412", w.Code, w.Body)
+	}
+	if saved, _ := grids.MemBeatgridStore.Get(context.Background(), "t1"); saved.Version != 1 || saved.Markers[0].Bpm != 124 {
+		t.Errorf("saved grid = %+v, want the first edit kept", saved)
+	}
+}
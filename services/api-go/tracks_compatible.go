@@ -0,0 +1,116 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"meta-dj/services/api-go/mixing"
+	"meta-dj/services/api-go/musickey"
+)
+
+// compatibleTrack is one entry of the compatible listing.
+type compatibleTrack struct {
+	Track any `json:"track"`
+	mixing.Transition
+	Key string `json:"key"` // Transition.Key in the response's notation
+}
+
+// mixTrack is what mixing scores: the track's key and the BPM from the
+// override, the latest analysis, then the file tag.
+func mixTrack(t *TrackRow) mixing.Track {
+	var m mixing.Track
+	if t.Key != nil {
+		m.Key = *t.Key
+	}
+	for _, b := range []*float64{t.BpmOverride, analysisBpm(t), t.TagBpm} {
+		if b != nil && *b > 0 {
+			m.Bpm = *b
+			break
+		}
+	}
+	return m
+}
+
+func analysisBpm(t *TrackRow) *float64 {
+	if t.Analysis == nil {
+		return nil
+	}
+	return t.Analysis.Bpm
+}
+
+// handleCompatible lists tracks that mix harmonically with the seed: a
+// Camelot-compatible key and a BPM within the window at same, half or
+// double time, best transition first.
+func (s *TracksService) handleCompatible(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sel, err := parseTrackSelect(query.Get("fields"), query.Get("include"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	opts := mixing.Options{BpmWindow: s.Mixing.BpmWindow, EnergyBoost: query.Get("energy_boost") == "true"}
+	if v, err := strconv.ParseFloat(query.Get("bpm_window"), 64); err == nil {
+		opts.BpmWindow = v
+	}
+	limit, offset := 50, 0
+	if n, err := strconv.Atoi(query.Get("limit")); err == nil && n > 0 {
+		limit = min(n, 200)
+	}
+	if n, err := strconv.Atoi(query.Get("offset")); err == nil && n >= 0 {
+		offset = n
+	}
+	// Scoring needs the analysed BPM whether or not the client asked for it.
+	load := TrackSelect{Include: append(slices.Clone(sel.Include), "analysis")}
+	seed, err := s.Store.Find(r.Context(), chi.URLParam(r, "id"), load)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	from := mixTrack(seed)
+	key, ok := musickey.Parse(from.Key)
+	if !ok || from.Bpm == 0 {
+		writeError(w, r, newProblem(http.StatusUnprocessableEntity, CodeUnprocessable, "track has no usable key and BPM to match on"))
+		return
+	}
+	// The store filters to compatible keys and tempos and ranks by score,
+	// so pages come out in transition order.
+	keys := TrackCond{Field: "key", Op: ":"}
+	for k := range mixing.Compatible(key, opts.EnergyBoost) {
+		keys.Items = append(keys.Items, condItem{Lo: keyCode(k)})
+	}
+	slices.SortFunc(keys.Items, func(a, b condItem) int { return cmp.Compare(a.Lo.(string), b.Lo.(string)) })
+	bpm := TrackCond{Field: "bpm", Op: ":"}
+	for _, ratio := range []float64{1, 2, 0.5} {
+		mid := from.Bpm * ratio
+		bpm.Items = append(bpm.Items, condItem{Lo: mid * (1 - opts.BpmWindow/100), Hi: mid * (1 + opts.BpmWindow/100), Range: true})
+	}
+	candidates, err := s.Store.List(r.Context(), TrackQuery{
+		Filter: []TrackCond{keys, bpm}, Mix: &MixQuery{Key: key, Bpm: from.Bpm, Options: opts},
+		Exclude: []string{seed.ID}, Limit: limit, Offset: offset, TrackSelect: load,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	notation := s.notation(r)
+	out := []compatibleTrack{}
+	for i := range candidates {
+		t := &candidates[i]
+		tr, ok := mixing.Score(from, mixTrack(t), opts)
+		if !ok {
+			continue
+		}
+		if !slices.Contains(sel.Include, "analysis") {
+			t.Analysis = nil
+		}
+		formatKey(t, notation)
+		out = append(out, compatibleTrack{Track: renderTrack(t, sel), Transition: tr, Key: tr.Key.Format(notation)})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
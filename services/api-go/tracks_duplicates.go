@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// duplicateGroup is one entry of the duplicates listing.
+type duplicateGroup struct {
+	By        string `json:"by"`
+	Key       string `json:"key"`
+	Suggested string `json:"suggested"` // id of the copy to keep
+	Tracks    []any  `json:"tracks"`
+}
+
+// handleDuplicates lists groups of tracks that look like copies of each
+// other under each criterion in by (default hash and metadata).
+func (s *TracksService) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sel, err := parseTrackSelect(query.Get("fields"), "")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	by := []string{dupByHash, dupByMetadata}
+	if v := query.Get("by"); v != "" {
+		by = nil
+		for _, b := range strings.Split(v, ",") {
+			b = strings.TrimSpace(b)
+			if !slices.Contains(dupCriteria, b) {
+				writeError(w, r, validationProblem(FieldError{Field: "by", Code: "invalid_value", Message: "by must list hash, metadata or audio"}))
+				return
+			}
+			if !slices.Contains(by, b) {
+				by = append(by, b)
+			}
+		}
+	}
+	groups, err := s.Store.Duplicates(r.Context(), by)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	notation := s.notation(r)
+	out := make([]duplicateGroup, 0, len(groups))
+	for _, g := range groups {
+		d := duplicateGroup{By: g.By, Key: g.Key, Suggested: suggestSurvivor(g.Tracks)}
+		for i := range g.Tracks {
+			formatKey(&g.Tracks[i], notation)
+			d.Tracks = append(d.Tracks, renderTrack(&g.Tracks[i], sel))
+		}
+		out = append(out, d)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// handleMerge folds duplicates into the track in the path: their cues,
+// loops, tags and playlist entries move over and the duplicates are
+// deleted, all or nothing. If-Match is optional and guards the survivor.
+// The response counts the cues dropped or moved without their slot.
+func (s *TracksService) handleMerge(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var body struct {
+		Duplicates []string `json:"duplicates"`
+	}
+	if err := decodeJSON(r, &body); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(body.Duplicates) == 0 {
+		writeError(w, r, validationProblem(FieldError{Field: "duplicates", Code: "required", Message: "duplicates must list at least one track id"}))
+		return
+	}
+	if slices.Contains(body.Duplicates, id) {
+		writeError(w, r, validationProblem(FieldError{Field: "duplicates", Code: "invalid_value", Message: "a track cannot be merged into itself"}))
+		return
+	}
+	slices.Sort(body.Duplicates)
+	version, _, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	res, err := s.Store.Merge(r.Context(), id, slices.Compact(body.Duplicates), version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	formatKey(res.Track, s.notation(r))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etagFor(res.Track.Version))
+	json.NewEncoder(w).Encode(res)
+}
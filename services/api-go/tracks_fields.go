@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// trackProp describes one Track JSON property, derived from TrackRow's
+// struct tags: `db` names the column, `rel:"join"` marks properties loaded
+// from other tables and `rel:"include"` related resources attached only
+// when requested with include=.
+type trackProp struct {
+	Name     string
+	Column   string // empty for joined properties and includes
+	Writable bool   // part of TrackFields
+	Join     bool
+	Include  bool
+	index    []int
+}
+
+var trackProps, trackPropsByName = buildTrackProps()
+
+func buildTrackProps() ([]trackProp, map[string]*trackProp) {
+	var props []trackProp
+	var walk func(t reflect.Type, prefix []int, writable bool)
+	walk = func(t reflect.Type, prefix []int, writable bool) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			index := append(slices.Clone(prefix), i)
+			if f.Anonymous {
+				walk(f.Type, index, f.Type == reflect.TypeOf(TrackFields{}))
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			rel := f.Tag.Get("rel")
+			props = append(props, trackProp{
+				Name:     name,
+				Column:   f.Tag.Get("db"),
+				Writable: writable,
+				Join:     strings.Contains(rel, "join"),
+				Include:  strings.Contains(rel, "include"),
+				index:    index,
+			})
+		}
+	}
+	walk(reflect.TypeOf(TrackRow{}), nil, false)
+	byName := make(map[string]*trackProp, len(props))
+	for i := range props {
+		byName[props[i].Name] = &props[i]
+	}
+	return props, byName
+}
+
+// selectable reports whether p can be named in fields=.
+func (p *trackProp) selectable() bool { return p.Column != "" || p.Join }
+
+// value returns the property of t as stored (not a copy).
+func (p *trackProp) value(t *TrackRow) reflect.Value {
+	return reflect.ValueOf(t).Elem().FieldByIndex(p.index)
+}
+
+// TrackSelect narrows a track read: Fields lists Track properties to load
+// (nil for all) and Include the related resources to attach.
+type TrackSelect struct {
+	Fields  []string
+	Include []string
+}
+
+// wants reports whether a read with this selection must load prop.
+func (sel TrackSelect) wants(prop string) bool {
+	p := trackPropsByName[prop]
+	if p.Include && slices.Contains(sel.Include, prop) {
+		return true
+	}
+	if p.Include && !p.Join {
+		return false
+	}
+	return sel.Fields == nil || slices.Contains(sel.Fields, prop)
+}
+
+// parseTrackSelect validates the fields and include query parameters.
+func parseTrackSelect(fields, include string) (TrackSelect, error) {
+	var sel TrackSelect
+	var errs []FieldError
+	for _, f := range splitList(fields) {
+		if p := trackPropsByName[f]; p == nil || !p.selectable() {
+			errs = append(errs, FieldError{Field: "fields", Code: "unknown_field", Message: fmt.Sprintf("unknown track field %q", f)})
+			continue
+		}
+		sel.Fields = append(sel.Fields, f)
+	}
+	for _, f := range splitList(include) {
+		if p := trackPropsByName[f]; p == nil || !p.Include {
+			errs = append(errs, FieldError{Field: "include", Code: "invalid_value", Message: fmt.Sprintf("cannot include %q", f)})
+			continue
+		}
+		sel.Include = append(sel.Include, f)
+	}
+	if len(errs) > 0 {
+		return sel, validationProblem(errs...)
+	}
+	return sel, nil
+}
+
+// splitList splits a comma-separated parameter, dropping blanks and repeats.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" && !slices.Contains(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// renderTrack shapes t for the response: only the selected fields, plus
+// each included resource (an empty list rather than omitted).
+func renderTrack(t *TrackRow, sel TrackSelect) any {
+	if sel.Fields == nil && sel.Include == nil {
+		return t
+	}
+	var full map[string]json.RawMessage
+	b, _ := json.Marshal(t)
+	json.Unmarshal(b, &full)
+	out := make(map[string]any, len(sel.Fields)+len(sel.Include))
+	for k, v := range full {
+		p := trackPropsByName[k]
+		computed := p.Column == "" && !p.Join && !p.Include // e.g. snippet
+		if sel.Fields == nil && (p.Join || !p.Include) || computed || slices.Contains(sel.Fields, k) {
+			out[k] = v
+		}
+	}
+	for _, name := range sel.Include {
+		v := trackPropsByName[name].value(t)
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			out[name] = []any{}
+		} else {
+			out[name] = v.Interface()
+		}
+	}
+	return out
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"meta-dj/services/api-go/musickey"
+)
+
+// Track filter grammar, for the filter query parameter (repeating the
+// parameter is the same as joining the clauses with spaces):
+//
+//	filter  = clause { " " clause }               every clause must hold
+//	clause  = field op value
+//	op      = ":" | "=" | "!=" | "~" | "<" | "<=" | ">" | ">="
+//	value   = item { "," item }                   ":" matches any item, "!=" none
+//	item    = literal | [literal] ".." [literal]  inclusive range
+//	literal = bare word | "double quoted"
+//
+// Text matches ignore case and "~" is a substring match; keys match in any
+// notation (key:8A is key:Am is key:1m). Ranges and
+// comparisons apply to numbers, durations (8m, 7m30s, 7:30 or seconds) and
+// dates (2024-01-31 or RFC 3339). For example:
+//
+//	bpm:122..128 key:8A,9A genre:techno,house year>=2020 stars>=4 duration<8m tag:peak-time
+//
+// sort is a comma-separated list of sortable fields, "-" for descending
+// (sort=-bpm,title). Missing values sort last; ties break on id.
+
+type filterKind int
+
+const (
+	kindText filterKind = iota
+	kindNumber
+	kindDuration // compared in milliseconds
+	kindDate
+	kindKey // any notation, compared as a Camelot code
+)
+
+// trackFilterField is one field of the filter and sort grammar.
+type trackFilterField struct {
+	kind filterKind
+	// expr is the SQL expression over tracks. Many-valued fields (artist,
+	// tag) set exists instead: a subquery with a %s for the condition on n.name.
+	expr   string
+	exists string
+	// notNull lets keyset paging use a plain row comparison.
+	notNull  bool
+	sortable bool
+	sortOnly bool
+	// get reads the field for the memory store: a string, float64 (ms for
+	// durations), time.Time, []string for many-valued fields, or nil.
+	get func(t *TrackRow) any
+}
+
+const (
+	latestAnalysis = `(SELECT a.%s FROM analysis a WHERE a.track_id = tracks.id ORDER BY a.created_at DESC LIMIT 1)`
+)
+
+// trackKeyCode is TrackRow.Key in SQL (metadj_key_code, migration 0009).
+var trackKeyCode = "COALESCE(metadj_key_code(" + fmt.Sprintf(latestAnalysis, "musical_key") + "), metadj_key_code(tracks.tag_key))"
+
+var trackFilterFields = map[string]trackFilterField{
+	"title": {kind: kindText, expr: "COALESCE(tracks.title, '')", notNull: true, sortable: true,
+		get: func(t *TrackRow) any { return t.Title }},
+	"path": {kind: kindText, expr: "tracks.file_path", notNull: true, sortable: true,
+		get: func(t *TrackRow) any { return t.FilePath }},
+	"artist": {kind: kindText,
+		exists: `EXISTS (SELECT 1 FROM track_artists ta JOIN artists n ON n.id = ta.artist_id WHERE ta.track_id = tracks.id AND %s)`,
+		get: func(t *TrackRow) any {
+			names := make([]string, len(t.Artists))
+			for i, a := range t.Artists {
+				names[i] = a.Name
+			}
+			return names
+		}},
+	"tag": {kind: kindText,
+		exists: `EXISTS (SELECT 1 FROM track_tags tt JOIN tags n ON n.id = tt.tag_id WHERE tt.track_id = tracks.id AND %s)`,
+		get: func(t *TrackRow) any {
+			names := make([]string, len(t.Tags))
+			for i, g := range t.Tags {
+				names[i] = g.Name
+			}
+			return names
+		}},
+	"album": {kind: kindText, expr: "(SELECT al.name FROM albums al WHERE al.id = tracks.album_id)", sortable: true,
+		get: func(t *TrackRow) any {
+			if t.Album == nil {
+				return nil
+			}
+			return t.Album.Name
+		}},
+	"genre": {kind: kindText, expr: "tracks.genre", sortable: true, get: func(t *TrackRow) any { return strOrNil(t.Genre) }},
+	"codec": {kind: kindText, expr: "tracks.codec", sortable: true, get: func(t *TrackRow) any { return strOrNil(t.Codec) }},
+	"color": {kind: kindText, expr: "tracks.color", sortable: true, get: func(t *TrackRow) any { return strOrNil(t.Color) }},
+	// key and bpm prefer the user's override, then the latest analysis, then file tags.
+	"key": {kind: kindKey, expr: trackKeyCode, sortable: true, get: func(t *TrackRow) any { return strOrNil(t.Key) }},
+	"bpm": {kind: kindNumber, expr: "COALESCE(tracks.bpm_override, " + fmt.Sprintf(latestAnalysis, "bpm") + ", tracks.tag_bpm)", sortable: true,
+		get: func(t *TrackRow) any { return numOrNil(trackBpm(t)) }},
+	// relevance ranks full-text matches of q (Postgres only); sort-only.
+	"relevance": {kind: kindNumber, expr: "ts_rank(tracks.search_vector, query)::float8", notNull: true, sortable: true, sortOnly: true,
+		get: func(t *TrackRow) any { return nil }},
+	"year":   {kind: kindNumber, expr: "tracks.year", sortable: true, get: func(t *TrackRow) any { return numOrNil(t.Year) }},
+	"rating": {kind: kindNumber, expr: "tracks.rating", sortable: true, get: func(t *TrackRow) any { return numOrNil(t.Rating) }},
+	// stars is rating on the 0-5 scale DJs use.
+	"stars": {kind: kindNumber, expr: "tracks.rating::float8 / 20", sortable: true,
+		get: func(t *TrackRow) any {
+			if t.Rating == nil {
+				return nil
+			}
+			return float64(*t.Rating) / 20
+		}},
+	"track_no":       {kind: kindNumber, expr: "tracks.track_no", sortable: true, get: func(t *TrackRow) any { return numOrNil(t.TrackNo) }},
+	"disc_no":        {kind: kindNumber, expr: "tracks.disc_no", sortable: true, get: func(t *TrackRow) any { return numOrNil(t.DiscNo) }},
+	"bit_rate_kbps":  {kind: kindNumber, expr: "tracks.bit_rate_kbps", sortable: true, get: func(t *TrackRow) any { return numOrNil(t.BitRateKbps) }},
+	"sample_rate_hz": {kind: kindNumber, expr: "tracks.sample_rate_hz", sortable: true, get: func(t *TrackRow) any { return numOrNil(t.SampleRateHz) }},
+	"channels":       {kind: kindNumber, expr: "tracks.channels", sortable: true, get: func(t *TrackRow) any { return numOrNil(t.Channels) }},
+	"duration":       {kind: kindDuration, expr: "tracks.duration_ms", sortable: true, get: func(t *TrackRow) any { return numOrNil(t.DurationMs) }},
+	"added_at":       {kind: kindDate, expr: "tracks.added_at", notNull: true, sortable: true, get: func(t *TrackRow) any { return t.AddedAt }},
+	"modified_at":    {kind: kindDate, expr: "tracks.modified_at", notNull: true, sortable: true, get: func(t *TrackRow) any { return t.ModifiedAt }},
+}
+
+// keyCode is k as TrackRow.Key holds it: "08A".
+func keyCode(k musickey.Key) string {
+	if k.Minor {
+		return fmt.Sprintf("%02dA", k.CamelotNumber())
+	}
+	return fmt.Sprintf("%02dB", k.CamelotNumber())
+}
+
+// trackKeyOf is keyCode of a raw key, nil when it does not parse.
+func trackKeyOf(raw *string) *string {
+	if raw == nil {
+		return nil
+	}
+	k, ok := musickey.Parse(*raw)
+	if !ok {
+		return nil
+	}
+	code := keyCode(k)
+	return &code
+}
+
+// trackBpm is the bpm field's value as the SQL COALESCE reads it: the
+// override, the latest analysis, then the file tag.
+func trackBpm(t *TrackRow) *float64 {
+	switch {
+	case t.BpmOverride != nil:
+		return t.BpmOverride
+	case t.Analysis != nil && t.Analysis.Bpm != nil:
+		return t.Analysis.Bpm
+	}
+	return t.TagBpm
+}
+
+func strOrNil(s *string) any {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
+
+func numOrNil[T int | int64 | float64](n *T) any {
+	if n == nil {
+		return nil
+	}
+	return float64(*n)
+}
+
+// TrackCond is one parsed filter clause.
+type TrackCond struct {
+	Field string
+	Op    string // ":" (any item), "!=" (no item), "~" (substring), "<", "<=", ">", ">="
+	Items []condItem
+}
+
+// condItem is a single value (Lo only) or an inclusive range with
+// optional open ends. Values are typed per field kind: string, float64
+// (milliseconds for durations) or time.Time.
+type condItem struct {
+	Lo, Hi any
+	Range  bool
+}
+
+// TrackSort is one key of the sort parameter.
+type TrackSort struct {
+	Field string
+	Desc  bool
+}
+
+// defaultTrackSort orders listings by title (then id).
+var defaultTrackSort = []TrackSort{{Field: "title"}}
+
+// parseTrackFilter parses filter clauses; errors name the offending clause.
+func parseTrackFilter(s string) ([]TrackCond, error) {
+	var conds []TrackCond
+	var errs []FieldError
+	bad := func(code, format string, args ...any) {
+		errs = append(errs, FieldError{Field: "filter", Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+	clauses, err := splitOutsideQuotes(s, ' ', false)
+	if err != nil {
+		return nil, validationProblem(FieldError{Field: "filter", Code: "invalid_format", Message: err.Error()})
+	}
+	for _, clause := range clauses {
+		n := strings.IndexFunc(clause, func(r rune) bool { return !(r >= 'a' && r <= 'z' || r == '_') })
+		if n <= 0 {
+			bad("invalid_format", "clause %q is not field, operator, value", clause)
+			continue
+		}
+		name, rest := clause[:n], clause[n:]
+		field, ok := trackFilterFields[name]
+		if !ok || field.sortOnly {
+			bad("unknown_field", "unknown filter field %q", name)
+			continue
+		}
+		op := ""
+		for _, o := range []string{">=", "<=", "!=", ":", "=", "~", ">", "<"} {
+			if strings.HasPrefix(rest, o) {
+				op = o
+				break
+			}
+		}
+		if op == "" || len(rest) == len(op) {
+			bad("invalid_format", "clause %q is not field, operator, value", clause)
+			continue
+		}
+		if op == "=" {
+			op = ":"
+		}
+		if !field.allows(op) {
+			bad("invalid_operator", "operator %q does not apply to %s", op, name)
+			continue
+		}
+		values, err := splitOutsideQuotes(rest[len(op):], ',', true)
+		if err != nil {
+			bad("invalid_format", "%s: %v", clause, err)
+			continue
+		}
+		cond := TrackCond{Field: name, Op: op}
+		for _, v := range values {
+			item, err := field.parseItem(v, op)
+			if err != nil {
+				bad("invalid_value", "%s: %v", clause, err)
+				cond.Items = nil
+				break
+			}
+			cond.Items = append(cond.Items, item)
+		}
+		if cond.Items == nil {
+			continue
+		}
+		if len(cond.Items) > 1 && op != ":" && op != "!=" && op != "~" {
+			bad("invalid_value", "%s: %q takes a single value", clause, op)
+			continue
+		}
+		conds = append(conds, cond)
+	}
+	if len(errs) > 0 {
+		return nil, validationProblem(errs...)
+	}
+	return conds, nil
+}
+
+func (f trackFilterField) allows(op string) bool {
+	switch op {
+	case ":", "!=":
+		return true
+	case "~":
+		return f.kind == kindText
+	default:
+		return f.kind != kindText && f.kind != kindKey
+	}
+}
+
+func (f trackFilterField) parseItem(v, op string) (condItem, error) {
+	if lo, hi, ok := strings.Cut(v, ".."); ok && f.kind != kindText && f.kind != kindKey {
+		if op != ":" && op != "!=" {
+			return condItem{}, fmt.Errorf("ranges only work with \":\" or \"!=\"")
+		}
+		if lo == "" && hi == "" {
+			return condItem{}, fmt.Errorf("range %q has no bounds", v)
+		}
+		item := condItem{Range: true}
+		var err error
+		if lo != "" {
+			if item.Lo, err = f.parseLiteral(lo); err != nil {
+				return item, err
+			}
+		}
+		if hi != "" {
+			if item.Hi, err = f.parseLiteral(hi); err != nil {
+				return item, err
+			}
+		}
+		return item, nil
+	}
+	lit, err := f.parseLiteral(v)
+	return condItem{Lo: lit}, err
+}
+
+func (f trackFilterField) parseLiteral(v string) (any, error) {
+	if v == "" {
+		return nil, fmt.Errorf("empty value")
+	}
+	switch f.kind {
+	case kindNumber:
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", v)
+		}
+		return n, nil
+	case kindDuration:
+		ms, err := parseDurationMs(v)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a duration (8m, 7m30s, 7:30 or seconds)", v)
+		}
+		return ms, nil
+	case kindDate:
+		for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not a date (2006-01-02 or RFC 3339)", v)
+	case kindKey:
+		k, ok := musickey.Parse(v)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a musical key (8A, 1m, Am, A minor)", v)
+		}
+		return keyCode(k), nil
+	}
+	return v, nil
+}
+
+// parseDurationMs reads "8m", "7m30s", "7:30", "1:02:03" or plain seconds.
+func parseDurationMs(v string) (float64, error) {
+	if strings.Contains(v, ":") {
+		var secs float64
+		for _, part := range strings.Split(v, ":") {
+			n, err := strconv.ParseFloat(part, 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("bad duration")
+			}
+			secs = secs*60 + n
+		}
+		return secs * 1000, nil
+	}
+	if n, err := strconv.ParseFloat(v, 64); err == nil {
+		return n * 1000, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	return float64(d.Milliseconds()), nil
+}
+
+// splitOutsideQuotes splits s on sep, honouring "double quotes" so values
+// can contain spaces and commas. unquote drops the quotes.
+func splitOutsideQuotes(s string, sep rune, unquote bool) ([]string, error) {
+	var out []string
+	var cur strings.Builder
+	quoted, started := false, false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted, started = !quoted, true
+			if !unquote {
+				cur.WriteRune(r)
+			}
+		case r == sep && !quoted:
+			if started {
+				out = append(out, cur.String())
+			}
+			cur.Reset()
+			started = false
+		default:
+			cur.WriteRune(r)
+			started = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if started {
+		out = append(out, cur.String())
+	}
+	return out, nil
+}
+
+// parseTrackSort parses the sort parameter; empty means defaultTrackSort.
+func parseTrackSort(s string) ([]TrackSort, error) {
+	var keys []TrackSort
+	var errs []FieldError
+	for _, k := range splitList(s) {
+		desc := strings.HasPrefix(k, "-")
+		name := strings.TrimLeft(k, "+-")
+		field, ok := trackFilterFields[name]
+		switch {
+		case !ok:
+			errs = append(errs, FieldError{Field: "sort", Code: "unknown_field", Message: fmt.Sprintf("unknown sort field %q", name)})
+		case !field.sortable:
+			errs = append(errs, FieldError{Field: "sort", Code: "not_sortable", Message: fmt.Sprintf("%s cannot be sorted on", name)})
+		default:
+			keys = append(keys, TrackSort{Field: name, Desc: desc})
+		}
+	}
+	if len(errs) > 0 {
+		return nil, validationProblem(errs...)
+	}
+	if len(keys) == 0 {
+		return defaultTrackSort, nil
+	}
+	return keys, nil
+}
+
+func trackSortString(keys []TrackSort) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k.Field
+		if k.Desc {
+			parts[i] = "-" + k.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// matchTrack evaluates conds against a loaded track (memory store).
+func matchTrack(t *TrackRow, conds []TrackCond) bool {
+	for _, c := range conds {
+		if !matchCond(t, c) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchCond(t *TrackRow, c TrackCond) bool {
+	var vals []any
+	switch v := trackFilterFields[c.Field].get(t).(type) {
+	case nil:
+	case []string:
+		for _, n := range v {
+			vals = append(vals, n)
+		}
+	default:
+		vals = []any{v}
+	}
+	switch c.Op {
+	case ":", "~", "!=":
+		found := false
+		for _, v := range vals {
+			for _, it := range c.Items {
+				found = found || matchItem(v, it, c.Op == "~")
+			}
+		}
+		return found != (c.Op == "!=")
+	}
+	if len(vals) == 0 {
+		return false
+	}
+	n := compareValues(vals[0], c.Items[0].Lo)
+	switch c.Op {
+	case "<":
+		return n < 0
+	case "<=":
+		return n <= 0
+	case ">":
+		return n > 0
+	default:
+		return n >= 0
+	}
+}
+
+func matchItem(v any, it condItem, substring bool) bool {
+	switch {
+	case substring:
+		return strings.Contains(strings.ToLower(v.(string)), strings.ToLower(it.Lo.(string)))
+	case it.Range:
+		return (it.Lo == nil || compareValues(v, it.Lo) >= 0) && (it.Hi == nil || compareValues(v, it.Hi) <= 0)
+	}
+	return compareValues(v, it.Lo) == 0
+}
+
+// compareValues orders two field values of the same kind; strings compare
+// case-insensitively and a string meeting a time.Time (from a cursor) is
+// parsed as RFC 3339. nil sorts after everything.
+func compareValues(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	switch x := a.(type) {
+	case float64:
+		y, _ := b.(float64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case time.Time:
+		y, ok := b.(time.Time)
+		if !ok {
+			y, _ = time.Parse(time.RFC3339Nano, fmt.Sprint(b))
+		}
+		return x.Compare(y)
+	case string:
+		if y, ok := b.(time.Time); ok {
+			return -compareValues(y, x)
+		}
+		return strings.Compare(strings.ToLower(x), strings.ToLower(fmt.Sprint(b)))
+	}
+	return 0
+}
+
+// sortTracks orders rows by keys then id, filling each row's SortKey.
+func sortTracks(rows []TrackRow, keys []TrackSort) {
+	for i := range rows {
+		rows[i].SortKey = make([]any, 0, len(keys)+1)
+		for _, k := range keys {
+			rows[i].SortKey = append(rows[i].SortKey, trackFilterFields[k.Field].get(&rows[i]))
+		}
+		rows[i].SortKey = append(rows[i].SortKey, rows[i].ID)
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return compareSortKeys(rows[i].SortKey, rows[j].SortKey, keys) < 0 })
+}
+
+// compareSortKeys compares full sort keys (the sort fields, then id) with
+// missing values last in either direction, as the Postgres store orders.
+func compareSortKeys(a, b []any, keys []TrackSort) int {
+	for i := range a {
+		if i >= len(b) {
+			return 1
+		}
+		n := compareValues(a[i], b[i])
+		if i < len(keys) && keys[i].Desc && a[i] != nil && b[i] != nil {
+			n = -n
+		}
+		if n != 0 {
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTrackFilter(t *testing.T) {
+	date := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		in   string
+		want []TrackCond
+	}{
+		{"genre:techno,house", []TrackCond{{Field: "genre", Op: ":", Items: []condItem{{Lo: "techno"}, {Lo: "house"}}}}},
+		{"genre=techno", []TrackCond{{Field: "genre", Op: ":", Items: []condItem{{Lo: "techno"}}}}},
+		{`title~"deep love"`, []TrackCond{{Field: "title", Op: "~", Items: []condItem{{Lo: "deep love"}}}}},
+		{`artist:"Simon, Paul"`, []TrackCond{{Field: "artist", Op: ":", Items: []condItem{{Lo: "Simon, Paul"}}}}},
+		{"bpm:122..128", []TrackCond{{Field: "bpm", Op: ":", Items: []condItem{{Lo: 122.0, Hi: 128.0, Range: true}}}}},
+		{"bpm:..100,140..", []TrackCond{{Field: "bpm", Op: ":", Items: []condItem{{Hi: 100.0, Range: true}, {Lo: 140.0, Range: true}}}}},
+		{"year>=2020 stars>4", []TrackCond{
+			{Field: "year", Op: ">=", Items: []condItem{{Lo: 2020.0}}},
+			{Field: "stars", Op: ">", Items: []condItem{{Lo: 4.0}}},
+		}},
+		{"duration<7m30s", []TrackCond{{Field: "duration", Op: "<", Items: []condItem{{Lo: 450000.0}}}}},
+		{"duration:6:00..7:30", []TrackCond{{Field: "duration", Op: ":", Items: []condItem{{Lo: 360000.0, Hi: 450000.0, Range: true}}}}},
+		{"duration!=90", []TrackCond{{Field: "duration", Op: "!=", Items: []condItem{{Lo: 90000.0}}}}},
+		{"added_at>=2024-01-31", []TrackCond{{Field: "added_at", Op: ">=", Items: []condItem{{Lo: date}}}}},
+		{"key:8A,Am,1m,C", []TrackCond{{Field: "key", Op: ":", Items: []condItem{{Lo: "08A"}, {Lo: "08A"}, {Lo: "08A"}, {Lo: "08B"}}}}},
+		{"  tag:peak-time  ", []TrackCond{{Field: "tag", Op: ":", Items: []condItem{{Lo: "peak-time"}}}}},
+		{"", nil},
+	} {
+		got, err := parseTrackFilter(tc.in)
+		if err != nil {
+			t.Errorf("parseTrackFilter(%q): %v", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseTrackFilter(%q) = %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestParseTrackFilterErrors(t *testing.T) {
+	for _, tc := range []struct {
+		in    string
+		codes []string
+	}{
+		{"bogus:1", []string{"unknown_field"}},
+		{"relevance>1", []string{"unknown_field"}},
+		{"genre", []string{"invalid_format"}},
+		{"genre:", []string{"invalid_format"}},
+		{":techno", []string{"invalid_format"}},
+		{`title:"open`, []string{"invalid_format"}},
+		{"genre>techno", []string{"invalid_operator"}},
+		{"key>8A", []string{"invalid_operator"}},
+		{"bpm~12", []string{"invalid_operator"}},
+		{"bpm:fast", []string{"invalid_value"}},
+		{"bpm:..", []string{"invalid_value"}},
+		{"bpm>=120..130", []string{"invalid_value"}},
+		{"bpm>120,130", []string{"invalid_value"}},
+		{"key:H", []string{"invalid_value"}},
+		{"duration<long", []string{"invalid_value"}},
+		{"added_at>yesterday", []string{"invalid_value"}},
+		{"bogus:1 bpm:fast", []string{"unknown_field", "invalid_value"}},
+	} {
+		_, err := parseTrackFilter(tc.in)
+		var p *Problem
+		if !errors.As(err, &p) {
+			t.Errorf("parseTrackFilter(%q) = %v, want a validation problem", tc.in, err)
+			continue
+		}
+		var codes []string
+		for _, e := range p.Errors {
+			if e.Field != "filter" {
+				t.Errorf("parseTrackFilter(%q): field %q, want filter", tc.in, e.Field)
+			}
+			codes = append(codes, e.Code)
+		}
+		if !reflect.DeepEqual(codes, tc.codes) {
+			t.Errorf("parseTrackFilter(%q) codes = %v, want %v", tc.in, codes, tc.codes)
+		}
+	}
+}
+
+func TestParseTrackSort(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []TrackSort
+		code string
+	}{
+		{"", defaultTrackSort, ""},
+		{"-bpm,title", []TrackSort{{Field: "bpm", Desc: true}, {Field: "title"}}, ""},
+		{"+year", []TrackSort{{Field: "year"}}, ""},
+		{"relevance", []TrackSort{{Field: "relevance"}}, ""},
+		{"bogus", nil, "unknown_field"},
+		{"artist", nil, "not_sortable"},
+	} {
+		got, err := parseTrackSort(tc.in)
+		var p *Problem
+		switch {
+		case tc.code == "" && err != nil:
+			t.Errorf("parseTrackSort(%q): %v", tc.in, err)
+		case tc.code != "" && (!errors.As(err, &p) || p.Errors[0].Code != tc.code):
+			t.Errorf("parseTrackSort(%q) = %v, want %s", tc.in, err, tc.code)
+		case !reflect.DeepEqual(got, tc.want):
+			t.Errorf("parseTrackSort(%q) = %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestTrackCondSQL(t *testing.T) {
+	bpm := trackFilterFields["bpm"].expr
+	for _, tc := range []struct {
+		filter string
+		sql    string
+		args   []any
+	}{
+		{"genre:techno,House", `(lower(tracks.genre) = ANY($1::text[]))`, []any{[]string{"techno", "house"}}},
+		{"genre!=techno", `(tracks.genre IS NULL OR NOT (lower(tracks.genre) = ANY($1::text[])))`, []any{[]string{"techno"}}},
+		{"title~50%_off", `(COALESCE(tracks.title, '') ILIKE '%' || $1 || '%')`, []any{`50\%\_off`}},
+		{"bpm:122..128,140", `(` + bpm + ` BETWEEN $1::float8 AND $2::float8 OR ` + bpm + ` = $3::float8)`, []any{122.0, 128.0, 140.0}},
+		{"bpm:..100", `(` + bpm + ` <= $1::float8)`, []any{100.0}},
+		{"year>=2020", `tracks.year >= $1::float8`, []any{2020.0}},
+		{"duration<8m", `tracks.duration_ms < $1::float8`, []any{480000.0}},
+		{"key:Am", `(` + trackKeyCode + ` = $1::text)`, []any{"08A"}},
+		{"tag:peak", `EXISTS (SELECT 1 FROM track_tags tt JOIN tags n ON n.id = tt.tag_id WHERE tt.track_id = tracks.id AND (lower(n.name) = ANY($1::text[])))`, []any{[]string{"peak"}}},
+		{"artist!=drake", `NOT EXISTS (SELECT 1 FROM track_artists ta JOIN artists n ON n.id = ta.artist_id WHERE ta.track_id = tracks.id AND (lower(n.name) = ANY($1::text[])))`, []any{[]string{"drake"}}},
+	} {
+		conds, err := parseTrackFilter(tc.filter)
+		if err != nil {
+			t.Fatalf("parseTrackFilter(%q): %v", tc.filter, err)
+		}
+		var args sqlArgs
+		sql := trackCondSQL(conds[0], &args)
+		if sql != tc.sql {
+			t.Errorf("%q:\n got %s\nwant %s", tc.filter, sql, tc.sql)
+		}
+		if !reflect.DeepEqual([]any(args), tc.args) {
+			t.Errorf("%q: args %#v, want %#v", tc.filter, args, tc.args)
+		}
+	}
+}
+
+func TestMatchTrack(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	genre, key := "Techno", "08A"
+	track := TrackRow{
+		TrackFields: TrackFields{Title: "Deep Love", Genre: &genre, TagBpm: f(120), DurationMs: new(int64)},
+		Key:         &key,
+		Analysis:    &AnalysisRow{Bpm: f(124)},
+		Artists:     []ArtistCredit{{Name: "Simon"}},
+	}
+	*track.DurationMs = 420000
+	for _, tc := range []struct {
+		filter string
+		want   bool
+	}{
+		{"genre:techno", true},
+		{"genre!=techno", false},
+		{"title~love", true},
+		{"artist:simon", true},
+		{"artist!=simon", false},
+		{"tag:peak", false},
+		{"tag!=peak", true},
+		{"year>2000", false}, // missing values match no comparison
+		{"key:Am", true},
+		{"duration:6m..7m", true},
+		// bpm reads the analysis before the tag, as the SQL COALESCE does.
+		{"bpm:124", true},
+		{"bpm:120", false},
+	} {
+		conds, err := parseTrackFilter(tc.filter)
+		if err != nil {
+			t.Fatalf("parseTrackFilter(%q): %v", tc.filter, err)
+		}
+		if got := matchTrack(&track, conds); got != tc.want {
+			t.Errorf("matchTrack(%q) = %v, want %v", tc.filter, got, tc.want)
+		}
+	}
+	track.BpmOverride = f(126)
+	if conds, _ := parseTrackFilter("bpm:126"); !matchTrack(&track, conds) {
+		t.Error("bpm_override does not win over the analysis BPM")
+	}
+}
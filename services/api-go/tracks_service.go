@@ -1,99 +1,445 @@
 package main
 
 import (
-	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
 
 	"github.com/go-chi/chi/v5"
+
+	"meta-dj/services/api-go/beatgrid"
+	"meta-dj/services/api-go/musickey"
 )
 
 type TracksService struct {
-	Store *PgTrackStore
+	Store  TrackStore
+	Search SearchConfig
+	Mixing MixingConfig
+	Keys   KeysConfig
+	Grids  BeatgridStore
 }
 
 func (s *TracksService) Routes(r chi.Router) {
 	r.Get("/", s.handleList)
+	r.Get("/duplicates", s.handleDuplicates)
 	r.Get("/{id}", s.handleGet)
+	r.Get("/{id}/compatible", s.handleCompatible)
+	r.Get("/{id}/beatgrid", s.handleGetBeatgrid)
 }
 
 // ProtectedRoutes registers mutating endpoints that should be behind auth.
+// PATCH and DELETE require If-Match with the track's ETag.
 func (s *TracksService) ProtectedRoutes(r chi.Router) {
+	r.Post("/", s.handleCreate)
+	r.Patch("/{id}", s.handlePatch)
+	r.Delete("/{id}", s.handleDelete)
 	r.Put("/{id}/bpm-override", s.handlePutBpmOverride)
+	r.Post("/{id}/merge", s.handleMerge)
+	r.Put("/{id}/beatgrid", s.handlePutBeatgrid)
+	r.Patch("/{id}/beatgrid", s.handlePatchBeatgrid)
+	r.Delete("/{id}/beatgrid", s.handleDeleteBeatgrid)
+}
+
+// trackInput holds the client-writable track fields.
+type trackInput struct {
+	ID string `json:"id"`
+	TrackFields
+}
+
+func (in trackInput) row() TrackRow {
+	return TrackRow{ID: in.ID, TrackFields: in.TrackFields}
+}
+
+func inputOf(t *TrackRow) trackInput {
+	return trackInput{ID: t.ID, TrackFields: t.TrackFields}
+}
+
+func etagFor(version int64) string { return `"` + strconv.FormatInt(version, 10) + `"` }
+
+// ifMatchVersion reads If-Match: 0 for "*", the version for a strong ETag.
+// present is false when the header is absent.
+func ifMatchVersion(r *http.Request) (version int64, present bool, err error) {
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	if h == "" {
+		return 0, false, nil
+	}
+	if h == "*" {
+		return 0, true, nil
+	}
+	n, perr := strconv.ParseInt(strings.Trim(h, `"`), 10, 64)
+	if perr != nil || n <= 0 || !strings.HasPrefix(h, `"`) || !strings.HasSuffix(h, `"`) {
+		return 0, true, validationProblem(FieldError{Field: "If-Match", Code: "invalid_format", Message: `If-Match must be "*" or a single strong ETag such as "3"`})
+	}
+	return n, true, nil
+}
+
+// requireIfMatch is ifMatchVersion for writes that must be conditional (428 without it).
+func requireIfMatch(r *http.Request) (int64, error) {
+	v, present, err := ifMatchVersion(r)
+	if err != nil {
+		return 0, err
+	}
+	if !present {
+		return 0, newProblem(http.StatusPreconditionRequired, CodePreconditionReq, "If-Match with the track's ETag is required")
+	}
+	return v, nil
+}
+
+func writeTrack(w http.ResponseWriter, status int, t *TrackRow, n musickey.Notation) {
+	formatKey(t, n)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etagFor(t.Version))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(t)
+}
+
+// notation is the key notation of a response: key_notation, else the
+// configured default.
+func (s *TracksService) notation(r *http.Request) musickey.Notation {
+	if n, ok := musickey.ParseNotation(r.URL.Query().Get("key_notation")); ok {
+		return n
+	}
+	n, _ := musickey.ParseNotation(s.Keys.Notation)
+	return n
+}
+
+// formatKey rewrites t.Key from its stored code into notation n.
+func formatKey(t *TrackRow, n musickey.Notation) {
+	if t.Key == nil {
+		return
+	}
+	if k, ok := musickey.Parse(*t.Key); ok {
+		v := k.Format(n)
+		t.Key = &v
+	} else {
+		t.Key = nil
+	}
+}
+
+// trackCursor is the opaque pagination cursor: the sort key a page starts
+// after, or for prev links ends before, and the sort it belongs to.
+type trackCursor struct {
+	Sort   string `json:"s"`
+	Key    []any  `json:"k"`
+	Before bool   `json:"b,omitempty"`
+}
+
+func (c trackCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseTrackCursor(s string, sort []TrackSort) (trackCursor, error) {
+	c := trackCursor{Sort: trackSortString(sort)}
+	if s == "" {
+		return c, nil
+	}
+	sortStr := c.Sort
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if err == nil && (c.Sort != sortStr || len(c.Key) != len(sort)+1) {
+		err = errors.New("cursor is for another sort")
+	}
+	if err != nil {
+		return c, validationProblem(FieldError{Field: "cursor", Code: "invalid_value", Message: "cursor is not one returned by this endpoint"})
+	}
+	return c, nil
 }
 
+// trackPage is the cursor-mode list response.
+type trackPage struct {
+	Items          []any   `json:"items"`
+	Next           *string `json:"next"`
+	Prev           *string `json:"prev"`
+	Total          *int64  `json:"total,omitempty"`
+	TotalEstimated bool    `json:"total_estimated,omitempty"`
+	// Suggestions are "did you mean" candidates when a search finds nothing.
+	Suggestions []TrackSuggestion `json:"suggestions,omitempty"`
+}
+
+// handleList serves a bare array for limit/offset paging (what the web
+// client uses) and a trackPage once cursor is present, even empty.
 func (s *TracksService) handleList(w http.ResponseWriter, r *http.Request) {
-	q := r.URL.Query().Get("q")
-	folder := r.URL.Query().Get("folder")
+	query := r.URL.Query()
 	limit := 200
 	offset := 0
-	if v := r.URL.Query().Get("limit"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 1000 {
-			limit = n
+	if v := query.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			limit = min(n, 1000)
 		}
 	}
-	if v := r.URL.Query().Get("offset"); v != "" {
+	if v := query.Get("offset"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
 			offset = n
 		}
 	}
-	items, err := s.Store.List(r.Context(), q, folder, limit, offset)
+	sel, err := parseTrackSelect(query.Get("fields"), query.Get("include"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	filter, err := parseTrackFilter(strings.Join(query["filter"], " "))
 	if err != nil {
-		http.Error(w, "error", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	fieldsParam := strings.TrimSpace(r.URL.Query().Get("fields"))
+	q := strings.TrimSpace(query.Get("q"))
+	sort, err := parseTrackSort(query.Get("sort"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	switch {
+	case q != "" && query.Get("sort") == "":
+		sort = []TrackSort{{Field: "relevance", Desc: true}}
+	case q == "" && slices.ContainsFunc(sort, func(k TrackSort) bool { return k.Field == "relevance" }):
+		writeError(w, r, validationProblem(FieldError{Field: "sort", Code: "not_sortable", Message: "relevance needs a search (q)"}))
+		return
+	}
+	tq := TrackQuery{
+		Q: q, Folder: query.Get("folder"), Filter: filter, Sort: sort,
+		Limit: limit, Offset: offset, TrackSelect: sel,
+		Highlight: query.Get("highlight") == "true",
+	}
+	if fuzzy := query.Get("fuzzy"); fuzzy == "true" || fuzzy == "" && s.Search.Fuzzy {
+		tq.Fuzzy = s.Search.SimilarityThreshold
+		if v, err := strconv.ParseFloat(query.Get("similarity"), 64); err == nil && v > 0 && v <= 1 {
+			tq.Fuzzy = v
+		}
+	}
+	if query.Has("cursor") {
+		s.listPage(w, r, tq)
+		return
+	}
+	items, err := s.Store.List(r.Context(), tq)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	out := make([]any, len(items))
+	for i := range items {
+		formatKey(&items[i], s.notation(r))
+		out[i] = renderTrack(&items[i], sel)
+	}
+	if len(items) == 0 && q != "" && offset == 0 {
+		// The bare array has no room for suggestions; they go in a header.
+		sugg, err := s.Store.Suggest(r.Context(), q, s.Search.SuggestionThreshold, 5)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if len(sugg) > 0 {
+			w.Header().Set(suggestionsHeader, asciiJSON(sugg))
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	if fieldsParam == "" {
-		json.NewEncoder(w).Encode(items)
-		return
-	}
-	fields := strings.Split(fieldsParam, ",")
-	rows := make([]map[string]any, 0, len(items))
-	for _, it := range items {
-		m := map[string]any{}
-		for _, f := range fields {
-			switch strings.TrimSpace(f) {
-			case "id":
-				m["id"] = it.ID
-			case "title":
-				m["title"] = it.Title
-			case "file_path":
-				m["file_path"] = it.FilePath
-			case "year":
-				if it.Year != nil {
-					m["year"] = *it.Year
-				}
-			case "genre":
-				if it.Genre != nil {
-					m["genre"] = *it.Genre
-				}
-			case "duration_ms":
-				if it.DurationMs != nil {
-					m["duration_ms"] = *it.DurationMs
-				}
-			case "bpm_override":
-				if it.BpmOverride != nil {
-					m["bpm_override"] = *it.BpmOverride
-				}
-			}
+	json.NewEncoder(w).Encode(out)
+}
+
+// suggestionsHeader carries "did you mean" suggestions in offset mode, as
+// the JSON array the cursor envelope would hold.
+const suggestionsHeader = "X-Search-Suggestions"
+
+// asciiJSON encodes v with non-ASCII characters escaped, fit for a header.
+func asciiJSON(v any) string {
+	b, _ := json.Marshal(v)
+	var sb strings.Builder
+	for _, r := range string(b) {
+		if r < utf8.RuneSelf {
+			sb.WriteRune(r)
+			continue
+		}
+		for _, u := range utf16.Encode([]rune{r}) {
+			fmt.Fprintf(&sb, `\u%04x`, u)
+		}
+	}
+	return sb.String()
+}
+
+func (s *TracksService) listPage(w http.ResponseWriter, r *http.Request, tq TrackQuery) {
+	query := r.URL.Query()
+	cur, err := parseTrackCursor(query.Get("cursor"), tq.Sort)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	sel, limit := tq.TrackSelect, tq.Limit
+	// One extra row tells whether there is a page beyond this one.
+	tq.Limit, tq.Offset = limit+1, 0
+	if cur.Before {
+		tq.Before = cur.Key
+	} else {
+		tq.After = cur.Key
+	}
+	items, err := s.Store.List(r.Context(), tq)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	more := len(items) > limit
+	if more && cur.Before {
+		items = items[1:]
+	} else if more {
+		items = items[:limit]
+	}
+	page := trackPage{Items: make([]any, len(items))}
+	for i := range items {
+		formatKey(&items[i], s.notation(r))
+		page.Items[i] = renderTrack(&items[i], sel)
+	}
+	var links []string
+	link := func(c trackCursor, rel string) *string {
+		v := c.String()
+		q := r.URL.Query()
+		q.Set("cursor", v)
+		q.Del("offset")
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, q.Encode(), rel))
+		return &v
+	}
+	if len(items) > 0 {
+		first, last := &items[0], &items[len(items)-1]
+		if more || cur.Before {
+			page.Next = link(trackCursor{Sort: cur.Sort, Key: last.SortKey}, "next")
+		}
+		if cur.Key != nil && (more || !cur.Before) {
+			page.Prev = link(trackCursor{Sort: cur.Sort, Key: first.SortKey, Before: true}, "prev")
 		}
-		rows = append(rows, m)
 	}
-	json.NewEncoder(w).Encode(rows)
+	if len(items) == 0 && tq.Q != "" && cur.Key == nil {
+		page.Suggestions, err = s.Store.Suggest(r.Context(), tq.Q, s.Search.SuggestionThreshold, 5)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	switch query.Get("count") {
+	case "exact", "estimate":
+		n, estimated, err := s.Store.Count(r.Context(), tq, query.Get("count") == "exact")
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		page.Total, page.TotalEstimated = &n, estimated
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
 }
 
 func (s *TracksService) handleGet(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	row, err := s.Store.Get(r.Context(), id)
+	sel, err := parseTrackSelect(r.URL.Query().Get("fields"), r.URL.Query().Get("include"))
 	if err != nil {
-		http.Error(w, "not found", http.StatusNotFound)
+		writeError(w, r, err)
+		return
+	}
+	row, err := s.Store.Find(r.Context(), id, sel)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// Included resources change without bumping the track's version, so
+	// only the bare track can be answered with 304.
+	if inm := r.Header.Get("If-None-Match"); inm != "" && sel.Include == nil && strings.TrimPrefix(inm, "W/") == etagFor(row.Version) {
+		w.Header().Set("ETag", etagFor(row.Version))
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(row)
+	w.Header().Set("ETag", etagFor(row.Version))
+	formatKey(row, s.notation(r))
+	json.NewEncoder(w).Encode(renderTrack(row, sel))
+}
+
+func (s *TracksService) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var in trackInput
+	if err := decodeJSON(r, &in); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if in.ID == "" {
+		// Same id an import scan would assign to this path.
+		in.ID = sha1Hex(in.FilePath)
+	}
+	row, err := s.Store.Create(r.Context(), in.row())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/v1/tracks/"+row.ID)
+	writeTrack(w, http.StatusCreated, row, s.notation(r))
+}
+
+// handlePatch applies a JSON merge patch (RFC 7386) to the editable fields.
+func (s *TracksService) handlePatch(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	version, err := requireIfMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	cur, err := s.Store.Get(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if version != 0 && cur.Version != version {
+		writeError(w, r, ErrVersionMismatch)
+		return
+	}
+	doc, _ := json.Marshal(inputOf(cur))
+	merged, err := mergePatch(doc, patch)
+	if err != nil {
+		writeError(w, r, newProblem(http.StatusBadRequest, CodeInvalidJSON, "request body is not valid JSON: "+err.Error()))
+		return
+	}
+	var in trackInput
+	if err := json.Unmarshal(merged, &in); err != nil {
+		writeError(w, r, validationProblem(FieldError{Field: "body", Code: "invalid_type", Message: err.Error()}))
+		return
+	}
+	if in.FilePath == "" {
+		writeError(w, r, validationProblem(FieldError{Field: "file_path", Code: "required", Message: "file_path cannot be removed"}))
+		return
+	}
+	in.ID = cur.ID
+	// Guard with the version we patched so a concurrent write in between is a 412.
+	row, err := s.Store.Update(r.Context(), in.row(), cur.Version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeTrack(w, http.StatusOK, row, s.notation(r))
+}
+
+func (s *TracksService) handleDelete(w http.ResponseWriter, r *http.Request) {
+	version, err := requireIfMatch(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := s.Store.Delete(r.Context(), chi.URLParam(r, "id"), version); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (s *TracksService) handlePutBpmOverride(w http.ResponseWriter, r *http.Request) {
@@ -101,25 +447,33 @@ func (s *TracksService) handlePutBpmOverride(w http.ResponseWriter, r *http.Requ
 	var body struct {
 		Bpm *float64 `json:"bpm"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		http.Error(w, "bad json", http.StatusBadRequest)
+	if err := decodeJSON(r, &body); err != nil {
+		writeError(w, r, err)
 		return
 	}
 	if body.Bpm == nil {
-		http.Error(w, "bpm required", http.StatusBadRequest)
+		writeProblem(w, r, validationProblem(FieldError{Field: "bpm", Code: "required", Message: "bpm is required"}))
 		return
 	}
-	if _, err := s.Store.conn.Exec(r.Context(), `UPDATE tracks SET bpm_override = $1 WHERE id = $2`, *body.Bpm, id); err != nil {
-		http.Error(w, "error", http.StatusInternalServerError)
+	if !(*body.Bpm > 0 && *body.Bpm <= beatgrid.MaxBpm) {
+		writeProblem(w, r, validationProblem(FieldError{Field: "bpm", Code: "out_of_range", Message: fmt.Sprintf("bpm must be in (0, %d]", beatgrid.MaxBpm)}))
 		return
 	}
+	// If-Match is optional here: older clients send plain overrides.
+	version, _, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	row, err := s.Store.SetBpmOverride(r.Context(), id, *body.Bpm, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etagFor(row.Version))
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func NewTracksService(ctx context.Context, dsn string) (*TracksService, error) {
-	store, err := NewPgTrackStore(ctx, dsn)
-	if err != nil {
-		return nil, err
-	}
-	return &TracksService{Store: store}, nil
+func NewTracksService(store TrackStore, grids BeatgridStore, search SearchConfig, mixing MixingConfig, keys KeysConfig) *TracksService {
+	return &TracksService{Store: store, Grids: grids, Search: search, Mixing: mixing, Keys: keys}
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// newTestTracks serves the tracks routes over memory stores holding rows.
+func newTestTracks(t *testing.T, rows ...TrackRow) http.Handler {
+	t.Helper()
+	grids := NewMemBeatgridStore()
+	store := NewMemTrackStore(NewMemCueStore(), NewMemLoopStore(), grids)
+	for _, row := range rows {
+		row.FilePath = "/music/" + row.ID + ".mp3"
+		if _, err := store.Create(context.Background(), row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := defaultConfig()
+	svc := NewTracksService(store, grids, cfg.Search, cfg.Mixing, cfg.Keys)
+	r := chi.NewRouter()
+	r.Route("/v1/tracks", func(r chi.Router) {
+		svc.Routes(r)
+		svc.ProtectedRoutes(r)
+	})
+	return r
+}
+
+func TestListSuggestionsOnEmptySearch(t *testing.T) {
+	h := newTestTracks(t, TrackRow{ID: "a", TrackFields: TrackFields{Title: "Amélie Hypnotized"}},
+		TrackRow{ID: "b", TrackFields: TrackFields{Title: "Deep Love"}})
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", url, w.Code, w.Body)
+		}
+		return w
+	}
+	wantFirst := func(mode string, sugg []TrackSuggestion) {
+		t.Helper()
+		if len(sugg) == 0 || sugg[0].Text != "Amélie Hypnotized" || sugg[0].Kind != "title" {
+			t.Errorf("%s: suggestions = %+v, want Amélie Hypnotized first", mode, sugg)
+		}
+	}
+
+	t.Run("offset", func(t *testing.T) {
+		w := get("/v1/tracks/?q=Amelie+Hypnotised&fuzzy=false")
+		var items []any
+		if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil || len(items) != 0 {
+			t.Fatalf("body = %s, want []", w.Body)
+		}
+		raw := w.Header().Get(suggestionsHeader)
+		for _, c := range raw {
+			if c > 127 {
+				t.Fatalf("%s is not ASCII: %q", suggestionsHeader, raw)
+			}
+		}
+		var sugg []TrackSuggestion
+		if err := json.Unmarshal([]byte(raw), &sugg); err != nil {
+			t.Fatalf("%s = %q: %v", suggestionsHeader, raw, err)
+		}
+		wantFirst("offset", sugg)
+
+		// Later pages and searches with hits suggest nothing.
+		for _, url := range []string{"/v1/tracks/?q=Amelie+Hypnotised&fuzzy=false&offset=10", "/v1/tracks/?q=deep"} {
+			if v := get(url).Header().Get(suggestionsHeader); v != "" {
+				t.Errorf("GET %s: unexpected suggestions %s", url, v)
+			}
+		}
+	})
+
+	t.Run("cursor", func(t *testing.T) {
+		w := get("/v1/tracks/?q=Amelie+Hypnotised&fuzzy=false&cursor=")
+		var page trackPage
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) != 0 {
+			t.Fatalf("items = %v, want none", page.Items)
+		}
+		wantFirst("cursor", page.Suggestions)
+		if v := w.Header().Get(suggestionsHeader); v != "" {
+			t.Errorf("cursor mode sets %s: %s", suggestionsHeader, v)
+		}
+	})
+}
+
+func TestListCompatible(t *testing.T) {
+	track := func(id, key string, bpm float64) TrackRow {
+		return TrackRow{ID: id, TrackFields: TrackFields{Title: id, TagKey: &key, TagBpm: &bpm}}
+	}
+	h := newTestTracks(t,
+		track("seed", "Am", 128),
+		track("same", "8A", 128),
+		track("relative", "C", 128),
+		track("adjacent-off", "Em", 125),
+		track("double", "Am", 256),
+		track("boost", "Bm", 128),
+		track("clash", "F#", 128),
+		track("slow", "Am", 100),
+	)
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"same", "double", "relative", "adjacent-off"}},
+		{"?energy_boost=true", []string{"same", "double", "relative", "boost", "adjacent-off"}},
+		{"?limit=2&offset=1", []string{"double", "relative"}},
+		{"?offset=10", []string{}},
+		{"?bpm_window=1", []string{"same", "double", "relative"}},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/tracks/seed/compatible"+tc.query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", tc.query, w.Code, w.Body)
+		}
+		var out []struct {
+			Track struct{ ID string }
+			Score float64
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for i, c := range out {
+			got = append(got, c.Track.ID)
+			if i > 0 && c.Score > out[i-1].Score {
+				t.Errorf("%s: %s scores above %s", tc.query, c.Track.ID, out[i-1].Track.ID)
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("compatible%s = %v, want %v", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestPutBpmOverride(t *testing.T) {
+	h := newTestTracks(t, TrackRow{ID: "a", TrackFields: TrackFields{Title: "a"}})
+	for _, tc := range []struct {
+		body   string
+		status int
+	}{
+		{`{"bpm":0}`, http.StatusBadRequest},
+		{`{"bpm":-120}`, http.StatusBadRequest},
+		{`{"bpm":1e12}`, http.StatusBadRequest},
+		{`{"bpm":999.5}`, http.StatusBadRequest},
+		{`{"bpm":999}`, http.StatusNoContent},
+		{`{"bpm":124.5}`, http.StatusNoContent},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/v1/tracks/a/bpm-override", strings.NewReader(tc.body)))
+		if w.Code != tc.status {
+			t.Errorf("PUT %s = %d %s, want %d", tc.body, w.Code, w.Body, tc.status)
+		}
+	}
+}
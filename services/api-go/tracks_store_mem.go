@@ -0,0 +1,352 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"meta-dj/services/api-go/mixing"
+)
+
+type MemTrackStore struct {
+	mu     sync.RWMutex
+	tracks map[string]TrackRow
+	stats  map[string]FileStat // by track id, as the last import scan saw the file
+	// cues and loops serve include=cues,loops; the memory backend keeps no
+	// analysis or tags, so Key comes from tag_key alone.
+	cues  *MemCueStore
+	loops *MemLoopStore
+	grids *MemBeatgridStore // dropped with the track, as by the cascade in Postgres
+}
+
+func NewMemTrackStore(cues *MemCueStore, loops *MemLoopStore, grids *MemBeatgridStore) *MemTrackStore {
+	return &MemTrackStore{tracks: map[string]TrackRow{}, stats: map[string]FileStat{}, cues: cues, loops: loops, grids: grids}
+}
+
+// match returns the tracks tq filters to in sort order, with SortKey set.
+func (s *MemTrackStore) match(tq TrackQuery) []TrackRow {
+	s.mu.RLock()
+	out := make([]TrackRow, 0, len(s.tracks))
+	needle := strings.ToLower(tq.Q)
+	for _, t := range s.tracks {
+		if tq.Q != "" && !strings.Contains(strings.ToLower(t.Title), needle) && !fuzzyMatch(&t, tq.Q, tq.Fuzzy) {
+			continue
+		}
+		if tq.Folder != "" && !strings.HasPrefix(t.FilePath, tq.Folder) {
+			continue
+		}
+		if !matchTrack(&t, tq.Filter) || slices.Contains(tq.Exclude, t.ID) {
+			continue
+		}
+		out = append(out, t)
+	}
+	s.mu.RUnlock()
+	if tq.Sort == nil {
+		tq.Sort = defaultTrackSort
+	}
+	sortTracks(out, tq.Sort)
+	if tq.Mix != nil {
+		from := mixing.Track{Key: tq.Mix.Key.Camelot(), Bpm: tq.Mix.Bpm}
+		for i := range out {
+			var score any
+			if tr, ok := mixing.Score(from, mixTrack(&out[i]), tq.Mix.Options); ok {
+				score = tr.Score
+			}
+			out[i].SortKey = append([]any{score}, out[i].SortKey...)
+		}
+		keys := memSortKeys(tq)
+		sort.SliceStable(out, func(i, j int) bool { return compareSortKeys(out[i].SortKey, out[j].SortKey, keys) < 0 })
+	}
+	return out
+}
+
+// memSortKeys are the sort keys of tq's SortKey values: a mix ranking's
+// score, then Sort.
+func memSortKeys(tq TrackQuery) []TrackSort {
+	if tq.Mix == nil {
+		return tq.Sort
+	}
+	return append([]TrackSort{{Field: "mix", Desc: true}}, tq.Sort...)
+}
+
+func (s *MemTrackStore) List(ctx context.Context, tq TrackQuery) ([]TrackRow, error) {
+	out := s.match(tq)
+	keys := memSortKeys(tq)
+	limit, offset := tq.Limit, tq.Offset
+	switch {
+	case tq.After != nil:
+		offset = sort.Search(len(out), func(i int) bool { return compareSortKeys(out[i].SortKey, tq.After, keys) > 0 })
+	case tq.Before != nil:
+		end := sort.Search(len(out), func(i int) bool { return compareSortKeys(out[i].SortKey, tq.Before, keys) >= 0 })
+		offset = max(end-limit, 0)
+		out = out[:end]
+	}
+	if offset >= len(out) {
+		return []TrackRow{}, nil
+	}
+	out = out[offset:]
+	if limit > 0 && limit < len(out) {
+		out = out[:limit]
+	}
+	for i := range out {
+		if err := s.include(ctx, &out[i], tq.TrackSelect); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// fuzzyMatch reports whether the title or an artist of t resembles q by at
+// least threshold; never when threshold is zero.
+func fuzzyMatch(t *TrackRow, q string, threshold float64) bool {
+	if threshold <= 0 {
+		return false
+	}
+	if wordSimilarity(q, t.Title) >= threshold {
+		return true
+	}
+	return slices.ContainsFunc(t.Artists, func(a ArtistCredit) bool { return wordSimilarity(q, a.Name) >= threshold })
+}
+
+func (s *MemTrackStore) Suggest(ctx context.Context, q string, threshold float64, limit int) ([]TrackSuggestion, error) {
+	seen := map[TrackSuggestion]bool{}
+	add := func(text, kind string) {
+		if sim := wordSimilarity(q, text); text != "" && sim >= threshold {
+			seen[TrackSuggestion{Text: text, Kind: kind, Similarity: sim}] = true
+		}
+	}
+	s.mu.RLock()
+	for _, t := range s.tracks {
+		add(t.Title, "title")
+		for _, a := range t.Artists {
+			add(a.Name, "artist")
+		}
+	}
+	s.mu.RUnlock()
+	out := make([]TrackSuggestion, 0, len(seen))
+	for sg := range seen {
+		out = append(out, sg)
+	}
+	slices.SortFunc(out, func(a, b TrackSuggestion) int {
+		if a.Similarity != b.Similarity {
+			return cmp.Compare(b.Similarity, a.Similarity)
+		}
+		if sa, sb := similarity(q, a.Text), similarity(q, b.Text); sa != sb {
+			return cmp.Compare(sb, sa)
+		}
+		return strings.Compare(a.Text, b.Text)
+	})
+	return out[:min(limit, len(out))], nil
+}
+
+func (s *MemTrackStore) Count(ctx context.Context, tq TrackQuery, exact bool) (int64, bool, error) {
+	return int64(len(s.match(tq))), false, nil
+}
+
+func (s *MemTrackStore) Find(ctx context.Context, id string, sel TrackSelect) (*TrackRow, error) {
+	t, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return t, s.include(ctx, t, sel)
+}
+
+func (s *MemTrackStore) include(ctx context.Context, t *TrackRow, sel TrackSelect) (err error) {
+	if slices.Contains(sel.Include, "cues") && s.cues != nil {
+		if t.Cues, err = s.cues.ListByTrack(ctx, t.ID); err != nil {
+			return err
+		}
+	}
+	if slices.Contains(sel.Include, "loops") && s.loops != nil {
+		t.Loops, err = s.loops.ListByTrack(ctx, t.ID)
+	}
+	return err
+}
+
+func (s *MemTrackStore) Get(ctx context.Context, id string) (*TrackRow, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.tracks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &t, nil
+}
+
+func (s *MemTrackStore) UpsertScanned(ctx context.Context, f ScannedFile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tracks[f.ID]
+	same := ok && t.Title == f.Title && t.FilePath == f.Path && (f.ContentHash == "" || t.ContentHash != nil && *t.ContentHash == f.ContentHash)
+	if same && s.stats[f.ID] == f.FileStat {
+		return nil
+	}
+	s.stats[f.ID] = f.FileStat
+	if same {
+		return nil
+	}
+	now := time.Now().UTC()
+	if !ok {
+		t.AddedAt = now
+	}
+	t.ID, t.Title, t.FilePath = f.ID, f.Title, f.Path
+	if f.ContentHash != "" {
+		t.ContentHash = &f.ContentHash
+	}
+	t.Version++
+	t.ModifiedAt = now
+	s.tracks[f.ID] = t
+	return nil
+}
+
+func (s *MemTrackStore) FileStats(ctx context.Context, folder string) (map[string]FileStat, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := map[string]FileStat{}
+	for id, st := range s.stats {
+		if t, ok := s.tracks[id]; ok && t.ContentHash != nil && strings.HasPrefix(t.FilePath, folder) {
+			out[t.FilePath] = st
+		}
+	}
+	return out, nil
+}
+
+func (s *MemTrackStore) Create(ctx context.Context, t TrackRow) (*TrackRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tracks[t.ID]; ok {
+		return nil, ErrConflict
+	}
+	now := time.Now().UTC()
+	t.Version, t.AddedAt, t.ModifiedAt = 1, now, now
+	t.Key = trackKeyOf(t.TagKey)
+	s.tracks[t.ID] = t
+	return &t, nil
+}
+
+// current returns the stored track if version is 0 or matches. Callers hold mu.
+func (s *MemTrackStore) current(id string, version int64) (TrackRow, error) {
+	t, ok := s.tracks[id]
+	if !ok {
+		return t, ErrNotFound
+	}
+	if version != 0 && t.Version != version {
+		return t, ErrVersionMismatch
+	}
+	return t, nil
+}
+
+func (s *MemTrackStore) Update(ctx context.Context, t TrackRow, version int64) (*TrackRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, err := s.current(t.ID, version)
+	if err != nil {
+		return nil, err
+	}
+	t.Version, t.AddedAt, t.ModifiedAt = cur.Version+1, cur.AddedAt, time.Now().UTC()
+	t.Key = trackKeyOf(t.TagKey)
+	s.tracks[t.ID] = t
+	return &t, nil
+}
+
+func (s *MemTrackStore) Delete(ctx context.Context, id string, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.current(id, version); err != nil {
+		return err
+	}
+	delete(s.tracks, id)
+	delete(s.stats, id)
+	// As in Postgres, the track's cues, loops and beatgrid go with it.
+	s.cues.dropTrack(id)
+	s.loops.dropTrack(id)
+	s.grids.dropTrack(id)
+	return nil
+}
+
+func (s *MemTrackStore) SetBpmOverride(ctx context.Context, id string, bpm float64, version int64) (*TrackRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.current(id, version)
+	if err != nil {
+		return nil, err
+	}
+	t.BpmOverride = &bpm
+	t.Version++
+	t.ModifiedAt = time.Now().UTC()
+	s.tracks[id] = t
+	return &t, nil
+}
+
+func (s *MemTrackStore) Duplicates(ctx context.Context, by []string) ([]DuplicateGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	candidates := func(by string) ([]dupCandidate, error) {
+		var out []dupCandidate
+		for _, t := range s.tracks {
+			c := dupCandidate{ID: t.ID, DurationMs: t.DurationMs}
+			switch by {
+			case dupByHash:
+				if t.ContentHash == nil || *t.ContentHash == "" {
+					continue
+				}
+				c.DurationMs, c.Key = nil, *t.ContentHash
+			case dupByMetadata:
+				if c.Key = metadataKey(&t); c.Key == "" {
+					continue
+				}
+			default:
+				continue // audio needs analysis, which the memory backend lacks
+			}
+			out = append(out, c)
+		}
+		return out, nil
+	}
+	load := func(ids []string) (map[string]TrackRow, error) {
+		out := make(map[string]TrackRow, len(ids))
+		for _, id := range ids {
+			out[id] = s.tracks[id]
+		}
+		return out, nil
+	}
+	return findDuplicates(by, candidates, load)
+}
+
+func (s *MemTrackStore) Merge(ctx context.Context, survivor string, duplicates []string, version int64) (*MergeResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Missing tracks are a 404 before a stale version, as in Postgres.
+	for _, id := range duplicates {
+		if _, ok := s.tracks[id]; !ok {
+			return nil, ErrNotFound
+		}
+	}
+	t, err := s.current(survivor, version)
+	if err != nil {
+		return nil, err
+	}
+	var res MergeResult
+	if s.cues != nil {
+		dropped, cleared := s.cues.moveTracks(survivor, duplicates)
+		res.DroppedCues, res.ReassignedSlots = int64(dropped), int64(cleared)
+	}
+	if s.loops != nil {
+		s.loops.moveTracks(survivor, duplicates)
+	}
+	for _, id := range duplicates {
+		delete(s.tracks, id)
+		delete(s.stats, id)
+		if s.grids != nil {
+			s.grids.dropTrack(id)
+		}
+	}
+	t.Version++
+	t.ModifiedAt = time.Now().UTC()
+	s.tracks[survivor] = t
+	res.Track = &t
+	return &res, nil
+}
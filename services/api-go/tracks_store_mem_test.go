@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+
+	"meta-dj/services/api-go/beatgrid"
+)
+
+func TestMemMerge(t *testing.T) {
+	ctx := context.Background()
+	cues, loops := NewMemCueStore(), NewMemLoopStore()
+	grids := NewMemBeatgridStore()
+	store := NewMemTrackStore(cues, loops, grids)
+	for _, id := range []string{"s", "d1", "d2"} {
+		if _, err := store.Create(ctx, TrackRow{ID: id, TrackFields: TrackFields{Title: id, FilePath: "/music/" + id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	slot := func(s string) *string { return &s }
+	if err := cues.UpsertMany(ctx, []CueRow{
+		{ID: "s1", TrackID: "s", Type: CueHot, PositionMs: 1000, Slot: slot("A")},
+		{ID: "s2", TrackID: "s", Type: CueMemory, PositionMs: 5000},
+		{ID: "d1a", TrackID: "d1", Type: CueHot, PositionMs: 1050, Slot: slot("B")}, // s1's twin: dropped
+		{ID: "d1b", TrackID: "d1", Type: CueHot, PositionMs: 8000, Slot: slot("A")}, // s1 holds A
+		{ID: "d1c", TrackID: "d1", Type: CueMemory, PositionMs: 5051},               // just past 50ms
+		{ID: "d1d", TrackID: "d1", Type: CueHot, PositionMs: 9000, Slot: slot("C")},
+		{ID: "d2a", TrackID: "d2", Type: CueHot, PositionMs: 12000, Slot: slot("C")}, // d1d is lower
+		{ID: "d2b", TrackID: "d2", Type: CueHot, PositionMs: 5000, Slot: slot("D")},  // other type than s2
+	}); err != nil {
+		t.Fatal(err)
+	}
+	length := int64(4000)
+	if err := loops.Upsert(ctx, LoopRow{ID: "l1", TrackID: "d2", LengthMs: &length}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := grids.Insert(ctx, "d1", testGrid); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Merge(ctx, "s", []string{"d1", "gone"}, 99); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Merge with a missing duplicate = %v, want ErrNotFound", err)
+	}
+	if _, err := store.Merge(ctx, "s", []string{"d1", "d2"}, 99); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("Merge with a stale version = %v, want ErrVersionMismatch", err)
+	}
+	res, err := store.Merge(ctx, "s", []string{"d1", "d2"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.DroppedCues != 1 || res.ReassignedSlots != 2 || res.Track.Version != 2 {
+		t.Errorf("Merge = dropped %d, reassigned %d, version %d; want 1, 2, 2", res.DroppedCues, res.ReassignedSlots, res.Track.Version)
+	}
+
+	got := map[string]string{}
+	list, _ := cues.ListByTrack(ctx, "s")
+	for _, c := range list {
+		got[c.ID] = ""
+		if c.Slot != nil {
+			got[c.ID] = *c.Slot
+		}
+	}
+	want := map[string]string{"s1": "A", "s2": "", "d1b": "", "d1c": "", "d1d": "C", "d2a": "", "d2b": "D"}
+	if !maps.Equal(got, want) {
+		t.Errorf("survivor cues (id: slot) = %v, want %v", got, want)
+	}
+	if l, _ := loops.ListByTrack(ctx, "s"); len(l) != 1 {
+		t.Errorf("survivor loops = %v, want l1", l)
+	}
+	for _, id := range []string{"d1", "d2"} {
+		if _, err := store.Get(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%s) after merge = %v, want ErrNotFound", id, err)
+		}
+	}
+	if _, err := grids.Get(ctx, "d1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("beatgrid of a merged track = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemDeleteDropsBeatgrid(t *testing.T) {
+	ctx := context.Background()
+	grids := NewMemBeatgridStore()
+	store := NewMemTrackStore(NewMemCueStore(), NewMemLoopStore(), grids)
+	row := TrackRow{ID: "t1", TrackFields: TrackFields{Title: "t1", FilePath: "/music/t1.mp3"}}
+	if _, err := store.Create(ctx, row); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := grids.Insert(ctx, "t1", testGrid); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "t1", 1); err != nil {
+		t.Fatal(err)
+	}
+	// Imported again under the same path-derived id, it starts over.
+	if _, err := store.Create(ctx, row); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := grids.Get(ctx, "t1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("beatgrid after delete and re-import = %v, want ErrNotFound", err)
+	}
+}
+
+// testGrid is 120 BPM from the start.
+var testGrid = beatgrid.Grid{BeatsPerBar: 4, Markers: []beatgrid.Marker{{PositionMs: 0, Bpm: 120}}}
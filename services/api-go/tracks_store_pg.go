@@ -2,98 +2,1032 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"reflect"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"meta-dj/services/api-go/mixing"
+	"meta-dj/services/api-go/musickey"
 )
 
 type TrackRow struct {
-	ID          string   `json:"id"`
-	Title       string   `json:"title"`
-	FilePath    string   `json:"file_path"`
-	Year        *int     `json:"year,omitempty"`
-	Genre       *string  `json:"genre,omitempty"`
-	DurationMs  *int64   `json:"duration_ms,omitempty"`
-	BpmOverride *float64 `json:"bpm_override,omitempty"`
+	ID string `json:"id" db:"id"`
+	TrackFields
+	// Album and Artists are joined from albums / track_artists; read-only.
+	Album   *AlbumRef      `json:"album,omitempty" rel:"join"`
+	Artists []ArtistCredit `json:"artists,omitempty" rel:"join,include"`
+	// Key is the latest analysis's key, else tag_key, read as a zero-padded
+	// Camelot code; responses write it in the requested notation.
+	Key *string `json:"key,omitempty" db:"key"`
+	// Version increments on every write and is served as the ETag.
+	Version    int64     `json:"version" db:"version"`
+	AddedAt    time.Time `json:"added_at" db:"added_at"`
+	ModifiedAt time.Time `json:"modified_at" db:"modified_at"`
+	// Related resources, attached only when requested with include=.
+	Cues     []CueRow     `json:"cues,omitempty" rel:"include"`
+	Loops    []LoopRow    `json:"loops,omitempty" rel:"include"`
+	Analysis *AnalysisRow `json:"analysis,omitempty" rel:"include"`
+	Tags     []TagRow     `json:"tags,omitempty" rel:"include"`
+	// Snippet highlights search matches when a listing asks for it.
+	Snippet *string `json:"snippet,omitempty"`
+	// SortKey holds the listing's sort values then id, for cursors.
+	SortKey []any `json:"-"`
+}
+
+// TrackFields are the client-writable track columns, mirroring the core
+// CLI's SQLite schema.
+type TrackFields struct {
+	Title        string   `json:"title" db:"title"`
+	FilePath     string   `json:"file_path" db:"file_path"`
+	AlbumID      *string  `json:"album_id,omitempty" db:"album_id"`
+	Year         *int     `json:"year,omitempty" db:"year"`
+	Genre        *string  `json:"genre,omitempty" db:"genre"`
+	DurationMs   *int64   `json:"duration_ms,omitempty" db:"duration_ms"`
+	BpmOverride  *float64 `json:"bpm_override,omitempty" db:"bpm_override"`
+	TrackNo      *int     `json:"track_no,omitempty" db:"track_no"`
+	TrackTotal   *int     `json:"track_total,omitempty" db:"track_total"`
+	DiscNo       *int     `json:"disc_no,omitempty" db:"disc_no"`
+	DiscTotal    *int     `json:"disc_total,omitempty" db:"disc_total"`
+	Codec        *string  `json:"codec,omitempty" db:"codec"`
+	BitRateKbps  *int     `json:"bit_rate_kbps,omitempty" db:"bit_rate_kbps"`
+	SampleRateHz *int     `json:"sample_rate_hz,omitempty" db:"sample_rate_hz"`
+	Channels     *int     `json:"channels,omitempty" db:"channels"`
+	Rating       *int     `json:"rating,omitempty" db:"rating"` // 0-100, as written by the CLI importer
+	Color        *string  `json:"color,omitempty" db:"color"`
+	Comments     *string  `json:"comments,omitempty" db:"comments"`
+	ContentHash  *string  `json:"content_hash,omitempty" db:"content_hash"`
+	TagBpm       *float64 `json:"tag_bpm,omitempty" db:"tag_bpm"`
+	TagKey       *string  `json:"tag_key,omitempty" db:"tag_key"`
+	TagBpmRaw    *float64 `json:"tag_bpm_raw,omitempty" db:"tag_bpm_raw"`
+	TagKeyRaw    *string  `json:"tag_key_raw,omitempty" db:"tag_key_raw"`
+}
+
+type ArtistCredit struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	Position int    `json:"position"`
+}
+
+type AlbumRef struct {
+	ID      string         `json:"id"`
+	Name    string         `json:"name"`
+	Year    *int           `json:"year,omitempty"`
+	Artists []ArtistCredit `json:"artists,omitempty"`
+}
+
+// AnalysisRow is the latest analysis run for a track.
+type AnalysisRow struct {
+	ID              string    `json:"id"`
+	AnalyzerVersion string    `json:"analyzer_version"`
+	Bpm             *float64  `json:"bpm,omitempty"`
+	BpmConfidence   *float64  `json:"bpm_confidence,omitempty"`
+	MusicalKey      *string   `json:"musical_key,omitempty"`
+	KeyConfidence   *float64  `json:"key_confidence,omitempty"`
+	Lufs            *float64  `json:"lufs,omitempty"`
+	Peak            *float64  `json:"peak,omitempty"`
+	WaveformRef     *string   `json:"waveform_ref,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type TagRow struct {
+	ID    string  `json:"id"`
+	Name  string  `json:"name"`
+	Color *string `json:"color,omitempty"`
+}
+
+// trackFieldColumns lists the writable columns in TrackFields order.
+var trackFieldColumns = func() string {
+	var cols []string
+	for _, p := range trackProps {
+		if p.Writable {
+			cols = append(cols, p.Column)
+		}
+	}
+	return strings.Join(cols, ", ")
+}()
+
+// trackColumns selects every column of a full TrackRow, in scanAll order.
+var trackColumns, scanAll = trackSelection(nil)
+
+// trackSelection builds the select list for the requested properties and
+// the matching scan targets. id and version are always loaded (includes and
+// ETags need them), album_id whenever album is requested.
+func trackSelection(fields []string) (string, func(*TrackRow) []any) {
+	var exprs []string
+	var props []*trackProp
+	for i := range trackProps {
+		p := &trackProps[i]
+		if p.Column == "" {
+			continue
+		}
+		want := fields == nil || p.Name == "id" || p.Name == "version" || slices.Contains(fields, p.Name) ||
+			p.Name == "album_id" && slices.Contains(fields, "album")
+		if !want {
+			continue
+		}
+		expr := p.Column
+		switch expr {
+		case "title":
+			// Imported rows may lack a title; the API always returns a string.
+			expr = "COALESCE(title, '')"
+		case "key":
+			expr = trackKeyCode
+		}
+		exprs = append(exprs, expr)
+		props = append(props, p)
+	}
+	return strings.Join(exprs, ", "), func(r *TrackRow) []any {
+		dst := make([]any, len(props))
+		for i, p := range props {
+			dst[i] = p.value(r).Addr().Interface()
+		}
+		return dst
+	}
+}
+
+func (f *TrackFields) values() []any {
+	var out []any
+	v := reflect.ValueOf(f).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		out = append(out, v.Field(i).Interface())
+	}
+	return out
+}
+
+func scanTrack(row pgx.Row) (*TrackRow, error) {
+	var r TrackRow
+	if err := row.Scan(scanAll(&r)...); err != nil {
+		return nil, err
+	}
+	return &r, nil
 }
 
 type PgTrackStore struct{ conn *pgxpool.Pool }
 
-func NewPgTrackStore(ctx context.Context, dsn string) (*PgTrackStore, error) {
-	c, err := pgxpool.New(ctx, dsn)
+func NewPgTrackStore(pool *pgxpool.Pool) *PgTrackStore {
+	return &PgTrackStore{conn: pool}
+}
+
+// attach loads the joined properties and includes sel asks for, one query
+// per relation regardless of page size.
+func (s *PgTrackStore) attach(ctx context.Context, rows []TrackRow, sel TrackSelect) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	ids := make([]string, len(rows))
+	var albumIDs []string
+	for i, r := range rows {
+		ids[i] = r.ID
+		if r.AlbumID != nil && sel.wants("album") {
+			albumIDs = append(albumIDs, *r.AlbumID)
+		}
+	}
+	if sel.wants("artists") {
+		credits, err := groupRows(ctx, s.conn, `SELECT ta.track_id, a.id, a.name, ta.role, ta.position
+                 FROM track_artists ta JOIN artists a ON a.id = ta.artist_id
+                 WHERE ta.track_id = ANY($1) ORDER BY ta.position, ta.role`, ids, creditDest)
+		if err != nil {
+			return err
+		}
+		for i := range rows {
+			rows[i].Artists = credits[rows[i].ID]
+		}
+	}
+	if len(albumIDs) > 0 {
+		albums, err := groupRows(ctx, s.conn, `SELECT id, id, name, year FROM albums WHERE id = ANY($1)`, albumIDs,
+			func(a *AlbumRef) []any { return []any{&a.ID, &a.Name, &a.Year} })
+		if err != nil {
+			return err
+		}
+		albumCredits, err := groupRows(ctx, s.conn, `SELECT aa.album_id, a.id, a.name, aa.role, aa.position
+                 FROM album_artists aa JOIN artists a ON a.id = aa.artist_id
+                 WHERE aa.album_id = ANY($1) ORDER BY aa.position, aa.role`, albumIDs, creditDest)
+		if err != nil {
+			return err
+		}
+		for i := range rows {
+			if rows[i].AlbumID == nil || len(albums[*rows[i].AlbumID]) == 0 {
+				continue
+			}
+			a := albums[*rows[i].AlbumID][0]
+			a.Artists = albumCredits[a.ID]
+			rows[i].Album = &a
+		}
+	}
+	if sel.wants("cues") {
+		cues, err := groupRows(ctx, s.conn, `SELECT track_id, `+cueColumns+`
+                 FROM cues WHERE track_id = ANY($1) ORDER BY position_ms`, ids, scanCue)
+		if err != nil {
+			return err
+		}
+		for i := range rows {
+			rows[i].Cues = cues[rows[i].ID]
+		}
+	}
+	if sel.wants("loops") {
+		loops, err := groupRows(ctx, s.conn, `SELECT track_id, `+loopColumns+`
+                 FROM loops WHERE track_id = ANY($1) ORDER BY start_ms`, ids, scanLoop)
+		if err != nil {
+			return err
+		}
+		for i := range rows {
+			rows[i].Loops = loops[rows[i].ID]
+		}
+	}
+	if sel.wants("analysis") {
+		analyses, err := groupRows(ctx, s.conn, `SELECT DISTINCT ON (track_id) track_id, id, analyzer_version, bpm,
+                     bpm_confidence, musical_key, key_confidence, lufs, peak, waveform_ref, created_at
+                 FROM analysis WHERE track_id = ANY($1) ORDER BY track_id, created_at DESC`, ids,
+			func(a *AnalysisRow) []any {
+				return []any{&a.ID, &a.AnalyzerVersion, &a.Bpm, &a.BpmConfidence, &a.MusicalKey,
+					&a.KeyConfidence, &a.Lufs, &a.Peak, &a.WaveformRef, &a.CreatedAt}
+			})
+		if err != nil {
+			return err
+		}
+		for i := range rows {
+			if a := analyses[rows[i].ID]; len(a) > 0 {
+				rows[i].Analysis = &a[0]
+			}
+		}
+	}
+	if sel.wants("tags") {
+		tags, err := groupRows(ctx, s.conn, `SELECT tt.track_id, t.id, t.name, t.color
+                 FROM track_tags tt JOIN tags t ON t.id = tt.tag_id
+                 WHERE tt.track_id = ANY($1) ORDER BY t.name`, ids,
+			func(t *TagRow) []any { return []any{&t.ID, &t.Name, &t.Color} })
+		if err != nil {
+			return err
+		}
+		for i := range rows {
+			rows[i].Tags = tags[rows[i].ID]
+		}
+	}
+	return nil
+}
+
+func creditDest(c *ArtistCredit) []any { return []any{&c.ID, &c.Name, &c.Role, &c.Position} }
+
+// groupRows runs a query whose first column is the owning id (bound to
+// $1 = ids) and groups the rest, scanned through dest, by owner.
+func groupRows[T any](ctx context.Context, conn *pgxpool.Pool, sql string, ids []string, dest func(*T) []any) (map[string][]T, error) {
+	rows, err := conn.Query(ctx, sql, ids)
 	if err != nil {
 		return nil, err
 	}
-	s := &PgTrackStore{conn: c}
-	if err := s.init(ctx); err != nil {
-		c.Close()
+	defer rows.Close()
+	out := map[string][]T{}
+	for rows.Next() {
+		var owner string
+		var v T
+		if err := rows.Scan(append([]any{&owner}, dest(&v)...)...); err != nil {
+			return nil, err
+		}
+		out[owner] = append(out[owner], v)
+	}
+	return out, rows.Err()
+}
+
+// one attaches the default relations to a single returned row.
+func (s *PgTrackStore) one(ctx context.Context, r *TrackRow) (*TrackRow, error) {
+	rows := []TrackRow{*r}
+	if err := s.attach(ctx, rows, TrackSelect{}); err != nil {
 		return nil, err
 	}
-	return s, nil
+	return &rows[0], nil
 }
 
-func (s *PgTrackStore) init(ctx context.Context) error {
-	_, err := s.conn.Exec(ctx, `
-CREATE TABLE IF NOT EXISTS tracks (
-  id TEXT PRIMARY KEY,
-  title TEXT,
-  file_path TEXT NOT NULL,
-  year INTEGER,
-  genre TEXT,
-  duration_ms BIGINT,
-  bpm_override DOUBLE PRECISION
-);
-CREATE INDEX IF NOT EXISTS idx_tracks_title ON tracks(title);
-CREATE INDEX IF NOT EXISTS idx_tracks_path ON tracks(file_path);
-`)
-	return err
+// sqlArgs collects query arguments, numbering placeholders as they are bound.
+type sqlArgs []any
+
+func (a *sqlArgs) bind(v any, cast string) string {
+	*a = append(*a, v)
+	return "$" + strconv.Itoa(len(*a)) + cast
+}
+
+var sqlCasts = map[filterKind]string{kindText: "::text", kindKey: "::text", kindNumber: "::float8", kindDuration: "::float8", kindDate: "::timestamptz"}
+
+// likeEscaper makes a user string literal inside ILIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// searchWords are the terms of a full-text query; everything else is a separator.
+var searchWords = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// tsquery turns user input into a prefix query in to_tsquery syntax:
+// "amel len" becomes 'amel':* & 'len':*. It is "" when q has no words.
+func tsquery(q string) string {
+	words := searchWords.FindAllString(q, 8)
+	for i, w := range words {
+		words[i] = "'" + w + "':*"
+	}
+	return strings.Join(words, " & ")
 }
 
-func (s *PgTrackStore) List(ctx context.Context, q string, folder string, limit, offset int) ([]TrackRow, error) {
+// trackFrom renders FROM and the WHERE conditions for q, folder and the
+// filter DSL. With a search, the parsed query is joined as "query" for
+// ranking and snippets, and for a fuzzy one the raw text as fuzzy.q.
+func trackFrom(tq TrackQuery, args *sqlArgs) (string, []string) {
+	from := " FROM tracks"
 	where := []string{}
-	args := []any{}
-	param := 1
-	if q != "" {
-		where = append(where, fmt.Sprintf("title ILIKE '%%' || $%d || '%%'", param))
-		args = append(args, q)
-		param++
-	}
-	if folder != "" {
-		where = append(where, fmt.Sprintf("file_path LIKE $%d || '%%'", param))
-		args = append(args, folder)
-		param++
-	}
-	sql := "SELECT id, title, file_path, year, genre, duration_ms, bpm_override FROM tracks"
-	if len(where) > 0 {
-		sql += " WHERE " + strings.Join(where, " AND ")
-	}
-	sql += " ORDER BY title LIMIT $" + strconv.Itoa(param) + " OFFSET $" + strconv.Itoa(param+1)
-	args = append(args, limit, offset)
-	rows, err := s.conn.Query(ctx, sql, args...)
+	if ts := tsquery(tq.Q); ts != "" {
+		from += ", to_tsquery('metadj', " + args.bind(ts, "") + ") query"
+		match := "tracks.search_vector @@ query"
+		if tq.Fuzzy > 0 {
+			from += ", (VALUES (" + args.bind(tq.Q, "::text") + ")) fuzzy(q)"
+			match = "(" + match + ` OR fuzzy.q <% tracks.title OR tracks.id IN (
+				SELECT ta.track_id FROM track_artists ta JOIN artists a ON a.id = ta.artist_id WHERE fuzzy.q <% a.name))`
+		}
+		where = append(where, match)
+	}
+	if tq.Folder != "" {
+		where = append(where, "file_path LIKE "+args.bind(likeEscaper.Replace(tq.Folder), "")+" || '%'")
+	}
+	for _, c := range tq.Filter {
+		where = append(where, trackCondSQL(c, args))
+	}
+	if tq.Exclude != nil {
+		where = append(where, "tracks.id <> ALL("+args.bind(tq.Exclude, "::text[]")+")")
+	}
+	if tq.Mix != nil {
+		from += ", " + mixScoreSQL(tq.Mix, args)
+	}
+	return from, where
+}
+
+// mixScoreSQL is mixing.Score in SQL, joined laterally as mix.score: the
+// rating of the move to each compatible key code, scaled by the distance
+// to the nearest of the seed's same, double and half time (0.9 off the
+// same time). Tracks Score rejects, by key or tempo, get NULL.
+func mixScoreSQL(m *MixQuery, args *sqlArgs) string {
+	moves := mixing.Compatible(m.Key, m.EnergyBoost)
+	codes := slices.SortedFunc(maps.Keys(moves), func(a, b musickey.Key) int { return strings.Compare(keyCode(a), keyCode(b)) })
+	whens := make([]string, len(codes))
+	for i, k := range codes {
+		whens[i] = "WHEN " + args.bind(keyCode(k), "::text") + " THEN " + args.bind(mixing.KeyScore(moves[k]), "::float8")
+	}
+	bpm, window := args.bind(m.Bpm, "::float8"), args.bind(m.BpmWindow, "::float8")
+	score := "(CASE c.k " + strings.Join(whens, " ") + " END)"
+	if m.BpmWindow > 0 {
+		score += " * (1 - 0.5 * least(c.d1, c.d2, c.dh) / " + window + ")"
+	}
+	score = "CASE WHEN least(c.d1, c.d2, c.dh) <= " + window + " THEN " + score +
+		" * CASE WHEN c.d1 <= least(c.d2, c.dh) THEN 1 ELSE 0.9 END END"
+	return `LATERAL (SELECT round((` + score + `)::numeric, 3)::float8 AS score
+		FROM (SELECT b.k, abs(b.b - ` + bpm + `) / ` + bpm + ` * 100 AS d1,
+			abs(b.b - 2 * ` + bpm + `) / (2 * ` + bpm + `) * 100 AS d2,
+			abs(b.b - ` + bpm + ` / 2) / (` + bpm + ` / 2) * 100 AS dh
+			FROM (SELECT ` + trackKeyCode + ` AS k, NULLIF(` + trackFilterFields["bpm"].expr + `, 0) AS b) b) c) mix`
+}
+
+func whereSQL(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// trackRelevance is the relevance sort expression for tq, "" without a
+// search. Fuzzy searches add the best title or artist similarity, so exact
+// spellings still rank first.
+func trackRelevance(tq TrackQuery) string {
+	if tsquery(tq.Q) == "" {
+		return ""
+	}
+	expr := trackFilterFields["relevance"].expr
+	if tq.Fuzzy > 0 {
+		expr = `(` + expr + ` + COALESCE(GREATEST(word_similarity(fuzzy.q, tracks.title),
+			(SELECT max(word_similarity(fuzzy.q, a.name)) FROM track_artists ta JOIN artists a ON a.id = ta.artist_id
+			 WHERE ta.track_id = tracks.id)), 0))::float8`
+	}
+	return expr
+}
+
+// trackSnippet is the highlighted text for a search hit.
+const trackSnippet = `ts_headline('metadj', concat_ws(' · ', tracks.title,
+        (SELECT string_agg(a.name, ', ' ORDER BY ta.position) FROM track_artists ta JOIN artists a ON a.id = ta.artist_id WHERE ta.track_id = tracks.id),
+        (SELECT al.name FROM albums al WHERE al.id = tracks.album_id), tracks.comments),
+    query, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5')`
+
+func trackCondSQL(c TrackCond, args *sqlArgs) string {
+	f := trackFilterFields[c.Field]
+	if f.exists != "" {
+		sql := fmt.Sprintf(f.exists, condItemsSQL("n.name", f.kind, c.Items, c.Op == "~", args))
+		if c.Op == "!=" {
+			return "NOT " + sql
+		}
+		return sql
+	}
+	switch c.Op {
+	case ":", "~":
+		return condItemsSQL(f.expr, f.kind, c.Items, c.Op == "~", args)
+	case "!=":
+		// A missing value is not equal to anything.
+		return fmt.Sprintf("(%s IS NULL OR NOT %s)", f.expr, condItemsSQL(f.expr, f.kind, c.Items, false, args))
+	}
+	return fmt.Sprintf("%s %s %s", f.expr, c.Op, args.bind(c.Items[0].Lo, sqlCasts[f.kind]))
+}
+
+// condItemsSQL matches expr against any of items.
+func condItemsSQL(expr string, kind filterKind, items []condItem, substring bool, args *sqlArgs) string {
+	cast := sqlCasts[kind]
+	var ors []string
+	var texts []string
+	for _, it := range items {
+		switch {
+		case substring:
+			ors = append(ors, expr+" ILIKE '%' || "+args.bind(likeEscaper.Replace(it.Lo.(string)), "")+" || '%'")
+		case kind == kindText:
+			texts = append(texts, strings.ToLower(it.Lo.(string)))
+		case !it.Range:
+			ors = append(ors, expr+" = "+args.bind(it.Lo, cast))
+		case it.Lo == nil:
+			ors = append(ors, expr+" <= "+args.bind(it.Hi, cast))
+		case it.Hi == nil:
+			ors = append(ors, expr+" >= "+args.bind(it.Lo, cast))
+		default:
+			ors = append(ors, expr+" BETWEEN "+args.bind(it.Lo, cast)+" AND "+args.bind(it.Hi, cast))
+		}
+	}
+	if texts != nil {
+		ors = append(ors, "lower("+expr+") = ANY("+args.bind(texts, "::text[]")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")"
+}
+
+// trackOrderKey is one ORDER BY term of a listing; the last is always id.
+type trackOrderKey struct {
+	expr    string
+	kind    filterKind
+	desc    bool
+	notNull bool
+}
+
+// trackOrder builds the ORDER BY keys, with relevance as trackRelevance
+// rendered it. Without a search (q had no words) relevance is a constant,
+// keeping cursors the same length. A mix ranking comes first.
+func trackOrder(sort []TrackSort, relevance string, mix bool) []trackOrderKey {
+	var keys []trackOrderKey
+	if mix {
+		keys = append(keys, trackOrderKey{expr: "mix.score", kind: kindNumber, desc: true})
+	}
+	if sort == nil {
+		sort = defaultTrackSort
+	}
+	if relevance == "" {
+		relevance = "0::float8"
+	}
+	for _, k := range sort {
+		f := trackFilterFields[k.Field]
+		if k.Field == "relevance" {
+			f.expr = relevance
+		}
+		keys = append(keys, trackOrderKey{expr: f.expr, kind: f.kind, desc: k.Desc, notNull: f.notNull})
+	}
+	return append(keys, trackOrderKey{expr: "tracks.id", kind: kindText, notNull: true})
+}
+
+// orderSQL renders keys with missing values last; reverse walks the same
+// order backwards (for prev pages).
+func orderSQL(keys []trackOrderKey, reverse bool) string {
+	terms := make([]string, len(keys))
+	for i, k := range keys {
+		dir, nulls := "ASC", "LAST"
+		if k.desc != reverse {
+			dir = "DESC"
+		}
+		if reverse {
+			nulls = "FIRST"
+		}
+		terms[i] = k.expr + " " + dir + " NULLS " + nulls
+	}
+	return " ORDER BY " + strings.Join(terms, ", ")
+}
+
+// keysetSQL selects rows strictly after (or before) key in the keys order.
+func keysetSQL(keys []trackOrderKey, key []any, before bool, args *sqlArgs) string {
+	vals := make([]any, len(key))
+	for i, v := range key {
+		vals[i] = v
+		if s, ok := v.(string); ok && keys[i].kind == kindDate {
+			// Cursor JSON carries timestamps as RFC 3339 strings.
+			vals[i], _ = time.Parse(time.RFC3339Nano, s)
+		}
+	}
+	uniform := true
+	for _, k := range keys {
+		uniform = uniform && k.notNull && k.desc == keys[0].desc
+	}
+	if uniform {
+		// A row comparison can use a matching index, e.g. idx_tracks_title_id.
+		exprs, params := make([]string, len(keys)), make([]string, len(keys))
+		for i, k := range keys {
+			exprs[i], params[i] = k.expr, args.bind(vals[i], sqlCasts[k.kind])
+		}
+		op := ">"
+		if before != keys[0].desc {
+			op = "<"
+		}
+		return "(" + strings.Join(exprs, ", ") + ") " + op + " (" + strings.Join(params, ", ") + ")"
+	}
+	// Otherwise expand to (k1 beyond) OR (k1 equal AND k2 beyond) OR ...,
+	// spelling out NULLS LAST.
+	var ors []string
+	for i, k := range keys {
+		var ands []string
+		for j := 0; j < i; j++ {
+			if vals[j] == nil {
+				ands = append(ands, keys[j].expr+" IS NULL")
+			} else {
+				ands = append(ands, keys[j].expr+" = "+args.bind(vals[j], sqlCasts[keys[j].kind]))
+			}
+		}
+		var beyond string
+		switch {
+		case vals[i] == nil && before:
+			beyond = k.expr + " IS NOT NULL"
+		case vals[i] == nil:
+			continue // nothing sorts after a missing value
+		default:
+			op := ">"
+			if before != k.desc {
+				op = "<"
+			}
+			beyond = k.expr + " " + op + " " + args.bind(vals[i], sqlCasts[k.kind])
+			if !before && !k.notNull {
+				beyond = "(" + beyond + " OR " + k.expr + " IS NULL)"
+			}
+		}
+		ors = append(ors, "("+strings.Join(append(ands, beyond), " AND ")+")")
+	}
+	if ors == nil {
+		return "FALSE"
+	}
+	return "(" + strings.Join(ors, " OR ") + ")"
+}
+
+func (s *PgTrackStore) List(ctx context.Context, tq TrackQuery) ([]TrackRow, error) {
+	var args sqlArgs
+	from, where := trackFrom(tq, &args)
+	relevance := trackRelevance(tq)
+	searching := relevance != ""
+	keys := trackOrder(tq.Sort, relevance, tq.Mix != nil)
+	order := orderSQL(keys, false)
+	page := ""
+	switch {
+	case tq.After != nil || tq.Before != nil:
+		key := tq.After
+		if tq.Before != nil {
+			// Walk backwards from the key, then flip the page below.
+			key, order = tq.Before, orderSQL(keys, true)
+		}
+		where = append(where, keysetSQL(keys, key, tq.Before != nil, &args))
+		page = " LIMIT " + args.bind(tq.Limit, "")
+	default:
+		page = " LIMIT " + args.bind(tq.Limit, "") + " OFFSET " + args.bind(tq.Offset, "")
+	}
+	cols, dest := trackSelection(tq.Fields)
+	for _, k := range keys {
+		cols += ", " + k.expr
+	}
+	highlight := tq.Highlight && searching
+	if highlight {
+		cols += ", " + trackSnippet
+	}
+	sql := "SELECT " + cols + from + whereSQL(where) + order + page
+	out := []TrackRow{}
+	err := s.read(ctx, tq.Fuzzy, func(q pgQuerier) error {
+		rows, err := q.Query(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			r := TrackRow{SortKey: make([]any, len(keys))}
+			dst := dest(&r)
+			for i := range r.SortKey {
+				dst = append(dst, &r.SortKey[i])
+			}
+			if highlight {
+				dst = append(dst, &r.Snippet)
+			}
+			if err := rows.Scan(dst...); err != nil {
+				return err
+			}
+			out = append(out, r)
+		}
+		return rows.Err()
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	out := []TrackRow{}
+	if tq.Before != nil {
+		slices.Reverse(out)
+	}
+	if err := s.attach(ctx, out, tq.TrackSelect); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *PgTrackStore) Count(ctx context.Context, tq TrackQuery, exact bool) (int64, bool, error) {
+	var args sqlArgs
+	from, where := trackFrom(tq, &args)
+	from += whereSQL(where)
+	if !exact && len(where) == 0 {
+		// Table statistics cost nothing, unlike count(*) over a million-track
+		// library. reltuples is -1 until the first ANALYZE.
+		var n float64
+		if err := s.conn.QueryRow(ctx, `SELECT reltuples FROM pg_class WHERE oid = 'tracks'::regclass`).Scan(&n); err != nil {
+			return 0, false, err
+		}
+		if n >= 0 {
+			return int64(n), true, nil
+		}
+	}
+	var n int64
+	estimated := false
+	err := s.read(ctx, tq.Fuzzy, func(q pgQuerier) error {
+		if !exact && len(where) > 0 {
+			var plan []struct {
+				Plan struct {
+					Rows float64 `json:"Plan Rows"`
+				}
+			}
+			if err := q.QueryRow(ctx, "EXPLAIN (FORMAT JSON) SELECT 1"+from, args...).Scan(&plan); err != nil {
+				return err
+			}
+			if len(plan) > 0 {
+				n, estimated = int64(plan[0].Plan.Rows), true
+				return nil
+			}
+		}
+		return q.QueryRow(ctx, "SELECT count(*)"+from, args...).Scan(&n)
+	})
+	return n, estimated, err
+}
+
+func (s *PgTrackStore) Suggest(ctx context.Context, q string, threshold float64, limit int) ([]TrackSuggestion, error) {
+	out := []TrackSuggestion{}
+	err := s.read(ctx, threshold, func(conn pgQuerier) error {
+		rows, err := conn.Query(ctx, `
+			SELECT label, kind, sim FROM (
+				SELECT title AS label, 'title' AS kind, word_similarity($1, title)::float8 AS sim
+				FROM tracks WHERE $1 <% title GROUP BY title
+				UNION ALL
+				SELECT name, 'artist', word_similarity($1, name)::float8 FROM artists WHERE $1 <% name
+			) s
+			ORDER BY sim DESC, similarity($1, label) DESC, label LIMIT $2`, q, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var sg TrackSuggestion
+			if err := rows.Scan(&sg.Text, &sg.Kind, &sg.Similarity); err != nil {
+				return err
+			}
+			out = append(out, sg)
+		}
+		return rows.Err()
+	})
+	return out, err
+}
+
+// normalizedSQL is normalizeName in SQL, over expression %s.
+const normalizedSQL = `btrim(regexp_replace(lower(%s), '[^[:alnum:]]+', ' ', 'g'))`
+
+// dupCandidatesSQL lists, per criterion, id, key, duration_ms, bpm and lufs
+// of the tracks it compares; hash and metadata only keys seen twice.
+var dupCandidatesSQL = map[string]string{
+	dupByHash: `SELECT id, content_hash, NULL::bigint, NULL::float8, NULL::float8 FROM tracks
+        WHERE content_hash IN (SELECT content_hash FROM tracks WHERE content_hash <> '' GROUP BY 1 HAVING count(*) > 1)`,
+	dupByMetadata: `WITH norm AS (
+            SELECT tracks.id, tracks.duration_ms,
+                btrim(coalesce((SELECT string_agg(n, ' ' ORDER BY n COLLATE "C") FROM (
+                    SELECT ` + fmt.Sprintf(normalizedSQL, "a.name") + ` AS n FROM track_artists ta JOIN artists a ON a.id = ta.artist_id
+                    WHERE ta.track_id = tracks.id) names), '')) || ' / ' || ` + fmt.Sprintf(normalizedSQL, "tracks.title") + ` AS k
+            FROM tracks WHERE ` + fmt.Sprintf(normalizedSQL, "coalesce(tracks.title, '')") + ` <> '')
+        SELECT id, k, duration_ms, NULL::float8, NULL::float8 FROM norm
+        WHERE k IN (SELECT k FROM norm GROUP BY k HAVING count(*) > 1)`,
+	dupByAudio: `SELECT tracks.id, ` + trackKeyCode + `, tracks.duration_ms, a.bpm, a.lufs FROM tracks
+        JOIN LATERAL (SELECT bpm, lufs FROM analysis WHERE track_id = tracks.id ORDER BY created_at DESC LIMIT 1) a ON true
+        WHERE a.bpm IS NOT NULL AND a.lufs IS NOT NULL AND tracks.duration_ms IS NOT NULL`,
+}
+
+func (s *PgTrackStore) Duplicates(ctx context.Context, by []string) ([]DuplicateGroup, error) {
+	candidates := func(by string) ([]dupCandidate, error) {
+		rows, err := s.conn.Query(ctx, dupCandidatesSQL[by])
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var out []dupCandidate
+		for rows.Next() {
+			var c dupCandidate
+			var key *string
+			if err := rows.Scan(&c.ID, &key, &c.DurationMs, &c.Bpm, &c.Lufs); err != nil {
+				return nil, err
+			}
+			if key == nil {
+				continue // audio: no key that parses
+			}
+			c.Key = *key
+			if by == dupByAudio {
+				k, _ := musickey.Parse(*key)
+				c.Key = k.Camelot()
+			}
+			out = append(out, c)
+		}
+		return out, rows.Err()
+	}
+	load := func(ids []string) (map[string]TrackRow, error) {
+		rows, err := s.conn.Query(ctx, `SELECT `+trackColumns+` FROM tracks WHERE id = ANY($1)`, ids)
+		if err != nil {
+			return nil, err
+		}
+		tracks, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (TrackRow, error) {
+			var r TrackRow
+			return r, row.Scan(scanAll(&r)...)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := s.attach(ctx, tracks, TrackSelect{}); err != nil {
+			return nil, err
+		}
+		out := make(map[string]TrackRow, len(tracks))
+		for _, t := range tracks {
+			out[t.ID] = t
+		}
+		return out, nil
+	}
+	return findDuplicates(by, candidates, load)
+}
+
+func (s *PgTrackStore) Merge(ctx context.Context, survivor string, duplicates []string, version int64) (*MergeResult, error) {
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	all := append([]string{survivor}, duplicates...)
+	rows, err := tx.Query(ctx, `SELECT id, version FROM tracks WHERE id = ANY($1) ORDER BY id FOR UPDATE`, all)
+	if err != nil {
+		return nil, err
+	}
+	versions := map[string]int64{}
 	for rows.Next() {
-		var r TrackRow
-		if err := rows.Scan(&r.ID, &r.Title, &r.FilePath, &r.Year, &r.Genre, &r.DurationMs, &r.BpmOverride); err != nil {
+		var id string
+		var v int64
+		if err := rows.Scan(&id, &v); err != nil {
 			return nil, err
 		}
-		out = append(out, r)
+		versions[id] = v
 	}
-	return out, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, id := range all {
+		if _, ok := versions[id]; !ok {
+			return nil, ErrNotFound
+		}
+	}
+	if version != 0 && versions[survivor] != version {
+		return nil, ErrVersionMismatch
+	}
+	var res MergeResult
+	for i, sql := range []string{
+		// The same hot cue set on two copies would show up twice.
+		`DELETE FROM cues d WHERE d.track_id = ANY($2) AND EXISTS (
+             SELECT 1 FROM cues c WHERE c.track_id = $1 AND c.type = d.type AND abs(c.position_ms - d.position_ms) <= 50)`,
+		// Hot cue slots stay unique: the survivor's win, then the lowest cue id's.
+		`UPDATE cues d SET slot = NULL WHERE d.track_id = ANY($2) AND d.slot IS NOT NULL AND (
+             EXISTS (SELECT 1 FROM cues c WHERE c.track_id = $1 AND c.slot = d.slot)
+             OR EXISTS (SELECT 1 FROM cues o WHERE o.track_id = ANY($2) AND o.slot = d.slot AND o.id < d.id))`,
+		`UPDATE cues SET track_id = $1, updated_at = now() WHERE track_id = ANY($2)`,
+		`UPDATE loops SET track_id = $1, updated_at = now() WHERE track_id = ANY($2)`,
+		`INSERT INTO track_tags (track_id, tag_id) SELECT $1, tag_id FROM track_tags WHERE track_id = ANY($2)
+             ON CONFLICT DO NOTHING`,
+		// A playlist keeps the survivor's entry, else the earliest duplicate's position.
+		`INSERT INTO playlist_tracks (playlist_id, track_id, position)
+             SELECT DISTINCT ON (playlist_id) playlist_id, $1, position FROM playlist_tracks
+             WHERE track_id = ANY($2) ORDER BY playlist_id, position
+             ON CONFLICT DO NOTHING`,
+		`DELETE FROM tracks WHERE id = ANY($2)`,
+	} {
+		tag, err := tx.Exec(ctx, sql, survivor, duplicates)
+		if err != nil {
+			return nil, err
+		}
+		switch i {
+		case 0:
+			res.DroppedCues = tag.RowsAffected()
+		case 1:
+			res.ReassignedSlots = tag.RowsAffected()
+		}
+	}
+	r, err := scanTrack(tx.QueryRow(ctx,
+		`UPDATE tracks SET version=version+1, modified_at=now() WHERE id=$1 RETURNING `+trackColumns, survivor))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	if res.Track, err = s.one(ctx, r); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// pgQuerier is the read side shared by the pool and a transaction.
+type pgQuerier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// read runs fn on the pool or, with a trigram threshold, in a read-only
+// transaction that sets pg_trgm.word_similarity_threshold: the <% operator
+// (and the trigram indexes behind it) only takes its threshold from there.
+func (s *PgTrackStore) read(ctx context.Context, threshold float64, fn func(pgQuerier) error) error {
+	if threshold <= 0 {
+		return fn(s.conn)
+	}
+	tx, err := s.conn.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`,
+		strconv.FormatFloat(threshold, 'f', -1, 64)); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
 func (s *PgTrackStore) Get(ctx context.Context, id string) (*TrackRow, error) {
-	row := s.conn.QueryRow(ctx, `SELECT id, title, file_path, year, genre, duration_ms, bpm_override FROM tracks WHERE id=$1`, id)
+	return s.Find(ctx, id, TrackSelect{})
+}
+
+func (s *PgTrackStore) Find(ctx context.Context, id string, sel TrackSelect) (*TrackRow, error) {
+	cols, dest := trackSelection(sel.Fields)
 	var r TrackRow
-	if err := row.Scan(&r.ID, &r.Title, &r.FilePath, &r.Year, &r.Genre, &r.DurationMs, &r.BpmOverride); err != nil {
+	err := s.conn.QueryRow(ctx, `SELECT `+cols+` FROM tracks WHERE id=$1`, id).Scan(dest(&r)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
 		return nil, err
 	}
-	return &r, nil
+	rows := []TrackRow{r}
+	if err := s.attach(ctx, rows, sel); err != nil {
+		return nil, err
+	}
+	return &rows[0], nil
+}
+
+func (s *PgTrackStore) Create(ctx context.Context, t TrackRow) (*TrackRow, error) {
+	vals := append([]any{t.ID}, t.TrackFields.values()...)
+	r, err := scanTrack(s.conn.QueryRow(ctx,
+		`INSERT INTO tracks(id, `+trackFieldColumns+`) VALUES(`+placeholders(1, len(vals))+`)
+             ON CONFLICT(id) DO NOTHING
+             RETURNING `+trackColumns,
+		vals...,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.one(ctx, r)
+}
+
+func (s *PgTrackStore) Update(ctx context.Context, t TrackRow, version int64) (*TrackRow, error) {
+	fields := t.TrackFields.values()
+	sets := make([]string, len(fields))
+	for i, col := range strings.Split(trackFieldColumns, ", ") {
+		sets[i] = fmt.Sprintf("%s=$%d", col, i+2)
+	}
+	n := len(fields) + 2
+	args := append(append([]any{t.ID}, fields...), version)
+	r, err := scanTrack(s.conn.QueryRow(ctx,
+		`UPDATE tracks SET `+strings.Join(sets, ", ")+`, version=version+1, modified_at=now()
+             WHERE id=$1 AND ($`+strconv.Itoa(n)+`::bigint = 0 OR version=$`+strconv.Itoa(n)+`)
+             RETURNING `+trackColumns,
+		args...,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, s.missOrStale(ctx, t.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.one(ctx, r)
+}
+
+func (s *PgTrackStore) Delete(ctx context.Context, id string, version int64) error {
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	tag, err := tx.Exec(ctx, `DELETE FROM tracks WHERE id=$1 AND ($2::bigint = 0 OR version=$2)`, id, version)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return s.missOrStale(ctx, id)
+	}
+	// cues.track_id has no foreign key; remove them with the track.
+	if _, err := tx.Exec(ctx, `DELETE FROM cues WHERE track_id=$1`, id); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// missOrStale explains a conditional write that matched no row.
+func (s *PgTrackStore) missOrStale(ctx context.Context, id string) error {
+	var exists bool
+	if err := s.conn.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM tracks WHERE id=$1)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrVersionMismatch
+	}
+	return ErrNotFound
+}
+
+func (s *PgTrackStore) UpsertScanned(ctx context.Context, f ScannedFile) error {
+	_, err := s.conn.Exec(ctx,
+		`INSERT INTO tracks(id, title, file_path, content_hash, file_size, file_mtime) VALUES($1,$2,$3,NULLIF($4,''),$5,$6)
+             ON CONFLICT(id) DO UPDATE SET title=EXCLUDED.title, file_path=EXCLUDED.file_path,
+                 content_hash=COALESCE(EXCLUDED.content_hash, tracks.content_hash),
+                 file_size=EXCLUDED.file_size, file_mtime=EXCLUDED.file_mtime,
+                 version=tracks.version+1, modified_at=now()
+             WHERE tracks.title IS DISTINCT FROM EXCLUDED.title OR tracks.file_path IS DISTINCT FROM EXCLUDED.file_path
+                 OR EXCLUDED.content_hash IS DISTINCT FROM tracks.content_hash AND EXCLUDED.content_hash IS NOT NULL
+                 OR tracks.file_size IS DISTINCT FROM EXCLUDED.file_size OR tracks.file_mtime IS DISTINCT FROM EXCLUDED.file_mtime`,
+		f.ID, f.Title, f.Path, f.ContentHash, f.Size, f.ModTime,
+	)
+	return err
+}
+
+func (s *PgTrackStore) FileStats(ctx context.Context, folder string) (map[string]FileStat, error) {
+	rows, err := s.conn.Query(ctx,
+		`SELECT file_path, file_size, file_mtime FROM tracks
+             WHERE file_path LIKE $1 || '%' AND content_hash IS NOT NULL AND file_size IS NOT NULL AND file_mtime IS NOT NULL`,
+		likeEscaper.Replace(folder))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]FileStat{}
+	for rows.Next() {
+		var path string
+		var st FileStat
+		if err := rows.Scan(&path, &st.Size, &st.ModTime); err != nil {
+			return nil, err
+		}
+		out[path] = st
+	}
+	return out, rows.Err()
 }
 
-// no-op
+func (s *PgTrackStore) SetBpmOverride(ctx context.Context, id string, bpm float64, version int64) (*TrackRow, error) {
+	r, err := scanTrack(s.conn.QueryRow(ctx,
+		`UPDATE tracks SET bpm_override=$2, version=version+1, modified_at=now()
+             WHERE id=$1 AND ($3::bigint = 0 OR version=$3)
+             RETURNING `+trackColumns,
+		id, bpm, version,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, s.missOrStale(ctx, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.one(ctx, r)
+}
+
+// placeholders renders "$from, ..., $(from+n-1)".
+func placeholders(from, n int) string {
+	ps := make([]string, n)
+	for i := range ps {
+		ps[i] = "$" + strconv.Itoa(from+i)
+	}
+	return strings.Join(ps, ", ")
+}
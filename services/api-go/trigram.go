@@ -0,0 +1,68 @@
+package main
+
+import "strings"
+
+// Trigram similarity as pg_trgm computes it, for the memory backend: words
+// are runs of letters and digits, lowercased and padded with two spaces in
+// front and one behind.
+
+// trigrams returns the trigrams of s in order, repeats included.
+func trigrams(s string) []string {
+	var out []string
+	for _, w := range searchWords.FindAllString(strings.ToLower(s), -1) {
+		r := []rune("  " + w + " ")
+		for i := 0; i+3 <= len(r); i++ {
+			out = append(out, string(r[i:i+3]))
+		}
+	}
+	return out
+}
+
+func trigramSet(s string) map[string]bool {
+	set := map[string]bool{}
+	for _, g := range trigrams(s) {
+		set[g] = true
+	}
+	return set
+}
+
+// similarity is pg_trgm's similarity(a, b): shared trigrams over all trigrams.
+func similarity(a, b string) float64 {
+	as, bs := trigramSet(a), trigramSet(b)
+	common := 0
+	for g := range as {
+		if bs[g] {
+			common++
+		}
+	}
+	if all := len(as) + len(bs) - common; all > 0 {
+		return float64(common) / float64(all)
+	}
+	return 0
+}
+
+// wordSimilarity is pg_trgm's word_similarity(q, text): the best similarity
+// between q and any contiguous run of text's trigrams.
+func wordSimilarity(q, text string) float64 {
+	qs := trigramSet(q)
+	if len(qs) == 0 {
+		return 0
+	}
+	ts := trigrams(text)
+	best := 0.0
+	for i := range ts {
+		seen := map[string]bool{}
+		common := 0
+		for _, g := range ts[i:] {
+			if seen[g] {
+				continue
+			}
+			seen[g] = true
+			if qs[g] {
+				common++
+			}
+			best = max(best, float64(common)/float64(len(qs)+len(seen)-common))
+		}
+	}
+	return best
+}